@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
 	"io"
@@ -32,7 +33,7 @@ func (g *Grep) Reader(r io.Reader, filename string) (int, error) {
 	// Read the full file into buffer.
 	n, err := io.ReadFull(r, buf[:cap(buf)])
 	if err == nil {
-		return 0, fmt.Errorf("cannot search file %q, larger than 1M", filename)
+		return 0, fmt.Errorf("cannot search file %q, larger than %d bytes", filename, maxFileSize)
 	} else if err != io.EOF && err != io.ErrUnexpectedEOF {
 		return 0, err
 	}
@@ -108,3 +109,112 @@ func (g *Grep) Reader(r io.Reader, filename string) (int, error) {
 
 	return count, nil
 }
+
+// Stream searches a file line-by-line without reading it into memory, so it
+// can handle files of any size. Output is in the same format as Reader. Since
+// lines are matched individually, matches can't span multiple lines, and lines
+// longer than maxFileSize are truncated.
+func (g *Grep) Stream(r io.Reader, filename string) (int, error) {
+	type pendingMatch struct {
+		startLine     int // first line of context
+		beforeContext int
+		afterContext  int
+		startCol      int
+		endCol        int
+		lines         [][]byte
+	}
+
+	var (
+		br      = bufio.NewReaderSize(r, 64*1024)
+		before  [][]byte        // up to g.Context previous lines
+		pending []*pendingMatch // matches waiting for after-context
+		lineno  = 0
+		count   = 0
+	)
+
+	emit := func(m *pendingMatch) {
+		fmt.Fprintf(g.Stdout, "%s %d %d %d %d %d %q\n",
+			filename, m.startLine, m.beforeContext, m.afterContext, m.startCol, m.endCol,
+			bytes.Join(m.lines, []byte{nl}))
+	}
+
+	for {
+		line, err := readLine(br, maxFileSize)
+		if err == io.EOF && line == nil {
+			break
+		} else if err != nil && err != io.EOF {
+			return count, err
+		}
+		lineno++
+
+		// Add this line to the after-context of earlier matches, then print
+		// any that are complete.
+		var remaining []*pendingMatch
+		for _, m := range pending {
+			m.lines = append(m.lines, line)
+			m.afterContext++
+			if m.afterContext >= g.Context {
+				emit(m)
+			} else {
+				remaining = append(remaining, m)
+			}
+		}
+		pending = remaining
+
+		if loc := g.Regexp.FindIndex(line); loc != nil {
+			var m = &pendingMatch{
+				startLine:     lineno - len(before),
+				beforeContext: len(before),
+				startCol:      loc[0] + 1,
+				endCol:        loc[1] + 1,
+				lines:         append(append([][]byte{}, before...), line),
+			}
+			if g.Context == 0 {
+				emit(m)
+			} else {
+				pending = append(pending, m)
+			}
+			count++
+		}
+
+		if g.Context > 0 {
+			before = append(before, line)
+			if len(before) > g.Context {
+				before = before[1:]
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+
+	// Print matches near the end of the file, which have less after-context
+	for _, m := range pending {
+		emit(m)
+	}
+	return count, nil
+}
+
+// readLine reads a single line, without its trailing newline. If the line is
+// longer than `limit`, the excess is discarded. At the end of the file, it
+// returns the final line (if any) and io.EOF.
+func readLine(br *bufio.Reader, limit int) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := br.ReadSlice(nl)
+		if len(line) < limit {
+			line = append(line, chunk[:min(len(chunk), limit-len(line))]...)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		} else if err == io.EOF {
+			if len(line) == 0 {
+				return nil, io.EOF
+			}
+			return line, io.EOF
+		} else if err != nil {
+			return nil, err
+		}
+		return bytes.TrimSuffix(line, []byte{nl}), nil
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"regexp/syntax"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -18,7 +19,7 @@ import (
 )
 
 const (
-	maxFileSize   = (1 << 20)
+	maxFileSize   = internal.MaxSearchFileSize
 	maxFileVisits = 500
 	maxContext    = 10
 	nl            = '\n'
@@ -27,6 +28,50 @@ const (
 type Index struct {
 	*index.Index
 	Package string
+	Large   bool // files are too large to buffer, see Grep.Stream
+
+	// Unindexed lists the files in a large-file shard that its codesearch
+	// index skipped (see Package.WriteMembers). They can't be filtered by
+	// trigram, so every search scans them.
+	Unindexed []string
+}
+
+// openIndex loads a package's codesearch index and, for a large-file shard,
+// finds the files missing from it.
+func openIndex(filename, pkg string) *Index {
+	var ix = &Index{Index: index.Open(filename), Package: pkg}
+	if !strings.HasSuffix(filename, ".large.csi") {
+		return ix
+	}
+	ix.Large = true
+
+	data, err := os.ReadFile(strings.TrimSuffix(filename, ".csi") + ".list")
+	if os.IsNotExist(err) {
+		return ix // from before member lists were recorded
+	} else if err != nil {
+		panic(err)
+	}
+	var indexed = make(map[string]bool)
+	for _, fileid := range ix.PostingQuery(&index.Query{Op: index.QAll}) {
+		indexed[ix.Name(fileid)] = true
+	}
+	for _, name := range strings.Split(string(data), "\n") {
+		if name != "" && !indexed[name] {
+			ix.Unindexed = append(ix.Unindexed, name)
+		}
+	}
+	return ix
+}
+
+// Candidates returns the files in the index that might match the query, in
+// sorted order.
+func (ix *Index) Candidates(q *index.Query) []string {
+	var names = append([]string{}, ix.Unindexed...)
+	for _, fileid := range ix.PostingQuery(q) {
+		names = append(names, ix.Name(fileid))
+	}
+	sort.Strings(names)
+	return names
 }
 
 func serve() {
@@ -43,7 +88,7 @@ func serve() {
 		}
 		for _, filename := range matches {
 			pkg := filepath.Base(filepath.Dir(filename))
-			indexes[distro] = append(indexes[distro], &Index{index.Open(filename), pkg})
+			indexes[distro] = append(indexes[distro], openIndex(filename, pkg))
 		}
 	}
 
@@ -234,9 +279,7 @@ overall:
 		}
 
 	perfile:
-		for _, fileid := range ix.PostingQuery(iquery) {
-			relative := ix.Name(fileid)
-
+		for _, relative := range ix.Candidates(iquery) {
 			// Handle include and exclude options
 			if len(includes.G) > 0 && !includes.MatchPath(relative) {
 				continue perfile
@@ -259,7 +302,12 @@ overall:
 			}
 
 			// Search source file to confirm matches
-			n, err := grep.Reader(f, relative)
+			var n int
+			if ix.Large {
+				n, err = grep.Stream(f, relative)
+			} else {
+				n, err = grep.Reader(f, relative)
+			}
 			count += n
 			files++
 			if err != nil {
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"testing"
+
+	"github.com/google/codesearch/index"
+)
+
+func TestLargeShardUnindexed(t *testing.T) {
+	// Like an amalgamated or generated source file: over 1 MiB, with far more
+	// than the 20000 distinct trigrams codesearch will index.
+	const alphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	var large bytes.Buffer
+	var symbol = make([]byte, 12)
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; large.Len() < 3<<19; i++ {
+		if i == 20000 {
+			large.WriteString("int sqlite3_needle(void);\n")
+		}
+		for j := range symbol {
+			symbol[j] = alphabet[rnd.Intn(len(alphabet))]
+		}
+		fmt.Fprintf(&large, "static const int %s = %d;\n", symbol, i)
+	}
+	var small = "int small_needle(void);\n"
+
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "pkg_1.0:1.large.csi")
+	iw := index.Create(filename)
+	iw.AddPaths([]string{"pkg"})
+	iw.Add("pkg/sqlite3.c", bytes.NewReader(large.Bytes()))
+	iw.Add("pkg/small.c", strings.NewReader(small))
+	iw.Flush()
+	members := "pkg/small.c\npkg/sqlite3.c\n"
+	if err := os.WriteFile(filepath.Join(dir, "pkg_1.0:1.large.list"), []byte(members), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ix := openIndex(filename, "pkg")
+	if !ix.Large {
+		t.Fatal("Expected a large-file shard")
+	}
+	if len(ix.Unindexed) != 1 || ix.Unindexed[0] != "pkg/sqlite3.c" {
+		t.Fatalf("Expected sqlite3.c to be unindexed, got %v", ix.Unindexed)
+	}
+
+	rsyntax, err := syntax.Parse("sqlite3_needle", syntax.Perl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	candidates := ix.Candidates(index.RegexpQuery(rsyntax.Simplify()))
+	if len(candidates) != 1 || candidates[0] != "pkg/sqlite3.c" {
+		t.Fatalf("Expected sqlite3.c to be searched, got %v", candidates)
+	}
+
+	var out bytes.Buffer
+	var grep = Grep{Regexp: regexp.MustCompile("(?m)sqlite3_needle"), Stdout: &out}
+	n, err := grep.Stream(bytes.NewReader(large.Bytes()), candidates[0])
+	if err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Errorf("Expected 1 match, got %d:\n%s", n, out.String())
+	}
+
+	// Indexed files are still filtered by trigram
+	rsyntax, err = syntax.Parse("small_needle", syntax.Perl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	candidates = ix.Candidates(index.RegexpQuery(rsyntax.Simplify()))
+	if len(candidates) != 2 || candidates[0] != "pkg/small.c" {
+		t.Errorf("Expected small.c and sqlite3.c to be searched, got %v", candidates)
+	}
+}
@@ -122,7 +122,13 @@ func (p Package) Update() (errored bool) {
 	fmt.Printf("[%s] Downloading codesearch index\n", p.Slug())
 	p.Download(fastDir, ".csi")
 	p.Download(bulkDir, ".tar.zst")
-	p.Decompress()
+	p.Decompress(".tar.zst")
+
+	fmt.Printf("[%s] Downloading large-file codesearch index\n", p.Slug())
+	p.Download(fastDir, ".large.csi")
+	p.Download(bulkDir, ".large.tar.zst")
+	members := p.Decompress(".large.tar.zst")
+	p.WriteMembers(fastDir, ".large.list", members)
 	return
 }
 
@@ -141,8 +147,10 @@ func (p Package) Download(base, ext string) {
 	}
 }
 
-func (p Package) Decompress() {
-	archive := filepath.Join(p.LocalDir(bulkDir), p.Filename(".tar.zst"))
+// Decompress extracts an archive downloaded to the bulk data directory, and
+// returns the names of its members.
+func (p Package) Decompress(ext string) []string {
+	archive := filepath.Join(p.LocalDir(bulkDir), p.Filename(ext))
 
 	f, err := os.Open(archive)
 	if err != nil {
@@ -155,6 +163,7 @@ func (p Package) Decompress() {
 	}
 	defer zr.Close()
 	ar := tar.NewReader(zr)
+	var members []string
 	for {
 		hdr, err := ar.Next()
 		if err == io.EOF {
@@ -162,6 +171,7 @@ func (p Package) Decompress() {
 		} else if err != nil {
 			panic(err)
 		}
+		members = append(members, hdr.Name)
 		filename := filepath.Join(p.LocalDir(bulkDir), strings.TrimPrefix(hdr.Name, p.Name))
 		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
 			panic(err)
@@ -177,4 +187,17 @@ func (p Package) Decompress() {
 			panic(err)
 		}
 	}
+	return members
+}
+
+// WriteMembers saves the list of files in a large-file shard. Its codesearch
+// index skips files that don't look like text (too many trigrams, or very long
+// lines), which is most files of that size, so the server needs the full list
+// to find them.
+func (p Package) WriteMembers(base, ext string, members []string) {
+	local := filepath.Join(p.LocalDir(base), p.Filename(ext))
+	data := strings.Join(members, "\n") + "\n"
+	if err := os.WriteFile(local, []byte(data), 0644); err != nil {
+		panic(err)
+	}
 }
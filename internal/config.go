@@ -1,7 +1,24 @@
 package internal
 
+// MaxSearchFileSize is the largest file, in bytes, that the grep server can
+// search in a normal codesearch index, since it reads each file into a
+// fixed-size buffer. Larger files must go in the index of large files, which is
+// searched line by line.
+const MaxSearchFileSize = 1 << 20
+
 type ConfigEntry struct {
 	Mirror     string
 	Areas      []string
 	Components []string
+
+	// Size limits for the codesearch index, in bytes. Text files larger than
+	// LargeFileSize go in a separate index of large files; files larger than
+	// MaxFileSize aren't indexed at all. Zero means "use the default".
+	// LargeFileSize can't be more than MaxSearchFileSize.
+	LargeFileSize int64 `toml:"large_file_size"`
+	MaxFileSize   int64 `toml:"max_file_size"`
+
+	// If set, tar, zip and gzip files inside source packages are expanded
+	// and their contents are indexed as virtual directories.
+	ExpandArchives bool `toml:"expand_archives"`
 }
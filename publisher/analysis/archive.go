@@ -5,7 +5,6 @@ package analysis
 import (
 	"fmt"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
 	"strings"
@@ -17,9 +16,11 @@ import (
 // An Archive represents a package that's been extracted to the local
 // filesystem.
 type Archive struct {
-	Pkg  *apt.Package // source package
-	Dir  string       // local directory
-	Tree Directory    // index of package contents
+	Pkg       *apt.Package // source package
+	Dir       string       // local directory
+	Tree      Directory    // index of package contents
+	Copyright Copyright    // parsed debian/copyright
+	Stats     TreeStats    // statistics from walking the tree
 
 	// Because of how dpkg-extract works, we create a temporary directory and
 	// the archive is extracted to a subdirectory (`Dir`). To make sure we clean
@@ -39,8 +40,8 @@ func (a Archive) CleanUp() error {
 // DownloadExtractAndWalkTree creates an Archive from an apt.Package. It
 // downloads the files listed in the package's control file, extracts and
 // combines them using dpkg-source, and walks the resulting directory to create
-// the index.
-func DownloadExtractAndWalkTree(pkg apt.Package) Archive {
+// the index. Files are hashed in parallel by `hashThreads` workers.
+func DownloadExtractAndWalkTree(pkg apt.Package, hashThreads int) Archive {
 	// Create temporary directory
 	tempdir, err := os.MkdirTemp("", "srccodes-"+pkg.Name)
 	if err != nil {
@@ -74,9 +75,10 @@ func DownloadExtractAndWalkTree(pkg apt.Package) Archive {
 
 	// Extract
 	var extracted = path.Join(tempdir, "source")
-	out, err := exec.Command("dpkg-source", "--extract", dsc, extracted).CombinedOutput()
+	_, err = runTool(dpkgSourceLimits, "", nil, "dpkg-source", "--extract", dsc, extracted)
 	if err != nil {
-		err := fmt.Errorf("dpkg-source failed: %#v\noutput: %s", err, string(out))
+		// Without a source tree, there's nothing else we can do
+		err.(*ToolError).Log(pkg.Slug())
 		panic(err)
 	}
 
@@ -88,12 +90,18 @@ func DownloadExtractAndWalkTree(pkg apt.Package) Archive {
 	}
 
 	// Walk, hash and construct tree
-	var tree = constructTree(extracted)
+	var tree, stats = constructTree(extracted, hashThreads)
+
+	// Resolve the license of every file
+	var copyright = ReadCopyright(extracted)
+	attachLicenses(tree, "", copyright)
 
 	return Archive{
-		Pkg:    &pkg,
-		Dir:    extracted,
-		Tree:   tree,
-		parent: tempdir,
+		Pkg:       &pkg,
+		Dir:       extracted,
+		Tree:      tree,
+		Copyright: copyright,
+		Stats:     stats,
+		parent:    tempdir,
 	}
 }
@@ -0,0 +1,216 @@
+package analysis
+
+import (
+	"bufio"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A ChangelogEntry is one release in a debian/changelog file.
+//
+// https://www.debian.org/doc/debian-policy/ch-source.html#debian-changelog-debian-changelog
+type ChangelogEntry struct {
+	Package       string   `json:"package"`
+	Version       string   `json:"version"`
+	Distributions []string `json:"distributions"`
+	Urgency       string   `json:"urgency,omitempty"`
+	Maintainer    string   `json:"maintainer"`
+	Date          string   `json:"date"` // RFC 3339 (UTC) if parseable, else as written
+	Items         []string `json:"items"`
+	Closes        []int    `json:"closes,omitempty"`    // Debian bugs
+	Launchpad     []int    `json:"launchpad,omitempty"` // Launchpad bugs
+	CVEs          []string `json:"cves,omitempty"`
+}
+
+var (
+	// package (version) distribution(s); key=value, ...
+	changelogHeader = regexp.MustCompile(`^(\S+) \(([^)]+)\) ([^;]*);(.*)$`)
+
+	//  -- Maintainer Name <email@address>  Date
+	changelogTrailer = regexp.MustCompile(`^ -- (.*?)  (.*)$`)
+
+	debianBugs    = regexp.MustCompile(`(?i)closes:\s*(?:bug)?#?\s?\d+(?:,\s*(?:bug)?#?\s?\d+)*`)
+	launchpadBugs = regexp.MustCompile(`(?i)lp:\s+#\d+(?:,\s*#\d+)*`)
+	bugNumber     = regexp.MustCompile(`\d+`)
+	cveID         = regexp.MustCompile(`CVE-\d{4}-\d{4,}`)
+)
+
+// Changelog dates are supposed to be in RFC 2822 format, but in practice there
+// are some variations.
+var changelogDateFormats = []string{
+	time.RFC1123Z,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon,  2 Jan 2006 15:04:05 -0700",
+	"Mon, 02 Jan 2006 15:04:05 MST",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+}
+
+// ConstructChangelogIndex parses the archive's debian/changelog. Entries are
+// returned in the order they appear, newest first. Missing changelogs result in
+// an empty list.
+func ConstructChangelogIndex(a Archive) []ChangelogEntry {
+	f, err := os.Open(filepath.Join(a.Dir, "debian", "changelog"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return []ChangelogEntry{}
+	} else if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	var entries = []ChangelogEntry{}
+	var current *ChangelogEntry
+	var item strings.Builder
+
+	finishItem := func() {
+		if current != nil && item.Len() > 0 {
+			current.Items = append(current.Items, item.String())
+		}
+		item.Reset()
+	}
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1024*1024)
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), " \t\r")
+
+		if m := changelogHeader.FindStringSubmatch(line); m != nil {
+			entries = append(entries, ChangelogEntry{
+				Package:       m[1],
+				Version:       m[2],
+				Distributions: strings.Fields(m[3]),
+				Urgency:       parseUrgency(m[4]),
+				Items:         []string{},
+			})
+			current = &entries[len(entries)-1]
+			continue
+		} else if current == nil {
+			// Garbage before the first entry, or old-format entries at the end
+			// of the file (which we don't parse)
+			continue
+		}
+
+		if m := changelogTrailer.FindStringSubmatch(line); m != nil {
+			finishItem()
+			current.Maintainer = m[1]
+			current.Date = parseChangelogDate(m[2])
+			extractReferences(current)
+			current = nil
+			continue
+		}
+
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+			finishItem()
+		case strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]"):
+			// `[ Contributor Name ]` section heading
+			finishItem()
+		case strings.HasPrefix(trimmed, "* "):
+			finishItem()
+			item.WriteString(strings.TrimPrefix(trimmed, "* "))
+		default:
+			// Continuation of the previous item, or a sub-item
+			if item.Len() > 0 {
+				item.WriteString(" ")
+			}
+			item.WriteString(trimmed)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		panic(err)
+	}
+	if current != nil {
+		// Entry with no trailer; keep whatever we've got
+		finishItem()
+		extractReferences(current)
+	}
+	return entries
+}
+
+func parseUrgency(keyvalues string) string {
+	for _, kv := range strings.Split(keyvalues, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(kv), "=")
+		if found && strings.EqualFold(key, "urgency") {
+			return strings.ToLower(value)
+		}
+	}
+	return ""
+}
+
+func parseChangelogDate(raw string) string {
+	raw = strings.TrimSpace(raw)
+	for _, layout := range changelogDateFormats {
+		if t, err := time.Parse(layout, raw); err == nil {
+			return t.UTC().Format(time.RFC3339)
+		}
+	}
+	return raw
+}
+
+// SortChangelogFeed sorts entries from different packages newest first, then by
+// package name. Entries whose date couldn't be parsed go last.
+func SortChangelogFeed(entries []ChangelogEntry) {
+	var dates = make([]time.Time, len(entries))
+	for i, e := range entries {
+		if t, err := time.Parse(time.RFC3339, e.Date); err == nil {
+			dates[i] = t
+		}
+	}
+	sort.Sort(changelogFeed{entries, dates})
+}
+
+type changelogFeed struct {
+	entries []ChangelogEntry
+	dates   []time.Time // zero if unknown
+}
+
+func (f changelogFeed) Len() int { return len(f.entries) }
+
+func (f changelogFeed) Swap(i, j int) {
+	f.entries[i], f.entries[j] = f.entries[j], f.entries[i]
+	f.dates[i], f.dates[j] = f.dates[j], f.dates[i]
+}
+
+func (f changelogFeed) Less(i, j int) bool {
+	if !f.dates[i].Equal(f.dates[j]) {
+		return f.dates[i].After(f.dates[j])
+	}
+	return f.entries[i].Package < f.entries[j].Package
+}
+
+// extractReferences finds the bug numbers and CVE IDs mentioned in an entry's
+// items.
+func extractReferences(e *ChangelogEntry) {
+	var seenCVEs = make(map[string]bool)
+	for _, item := range e.Items {
+		for _, m := range debianBugs.FindAllString(item, -1) {
+			e.Closes = append(e.Closes, parseBugNumbers(m)...)
+		}
+		for _, m := range launchpadBugs.FindAllString(item, -1) {
+			e.Launchpad = append(e.Launchpad, parseBugNumbers(m)...)
+		}
+		for _, cve := range cveID.FindAllString(item, -1) {
+			if !seenCVEs[cve] {
+				e.CVEs = append(e.CVEs, cve)
+				seenCVEs[cve] = true
+			}
+		}
+	}
+}
+
+func parseBugNumbers(s string) []int {
+	var bugs []int
+	for _, n := range bugNumber.FindAllString(s, -1) {
+		if bug, err := strconv.Atoi(n); err == nil {
+			bugs = append(bugs, bug)
+		}
+	}
+	return bugs
+}
@@ -0,0 +1,95 @@
+package analysis
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const sampleChangelog = `hello (2.10-3ubuntu1) noble; urgency=medium
+
+  * Fix buffer overflow in greeting parser (CVE-2024-12345).
+    Closes: #1001, #1002. LP: #2003
+  * Rebuild against new libc.
+
+ -- Jane Doe <jane@example.com>  Mon, 04 Mar 2024 10:11:12 +0100
+
+hello (2.10-2) unstable; urgency=low
+
+  [ John Smith ]
+  * New upstream release.
+    - Drop patches applied upstream.
+
+ -- John Smith <john@example.com>  Tue,  5 Jan 2021 08:00:00 -0000
+`
+
+func TestConstructChangelogIndex(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "debian"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	err := os.WriteFile(filepath.Join(dir, "debian", "changelog"), []byte(sampleChangelog), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := ConstructChangelogIndex(Archive{Dir: dir})
+	expected := []ChangelogEntry{
+		{
+			Package:       "hello",
+			Version:       "2.10-3ubuntu1",
+			Distributions: []string{"noble"},
+			Urgency:       "medium",
+			Maintainer:    "Jane Doe <jane@example.com>",
+			Date:          "2024-03-04T09:11:12Z",
+			Items: []string{
+				"Fix buffer overflow in greeting parser (CVE-2024-12345). Closes: #1001, #1002. LP: #2003",
+				"Rebuild against new libc.",
+			},
+			Closes:    []int{1001, 1002},
+			Launchpad: []int{2003},
+			CVEs:      []string{"CVE-2024-12345"},
+		},
+		{
+			Package:       "hello",
+			Version:       "2.10-2",
+			Distributions: []string{"unstable"},
+			Urgency:       "low",
+			Maintainer:    "John Smith <john@example.com>",
+			Date:          "2021-01-05T08:00:00Z",
+			Items: []string{
+				"New upstream release. - Drop patches applied upstream.",
+			},
+		},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("Unexpected changelog:\n got: %#v\nwant: %#v", entries, expected)
+	}
+}
+
+func TestConstructChangelogIndexMissing(t *testing.T) {
+	entries := ConstructChangelogIndex(Archive{Dir: t.TempDir()})
+	if entries == nil || len(entries) != 0 {
+		t.Errorf("Expected empty changelog, got %#v", entries)
+	}
+}
+
+func TestSortChangelogFeed(t *testing.T) {
+	feed := []ChangelogEntry{
+		{Package: "a", Date: "Mon, 32 Foo 2024 99:00:00 +0000"}, // unparseable
+		{Package: "b", Date: "2024-01-01T00:00:00Z"},
+		{Package: "c", Date: "2024-06-01T00:00:00Z"},
+		{Package: "d", Date: "2024-01-01T00:00:00Z"},
+		{Package: "e", Date: ""},
+	}
+	SortChangelogFeed(feed)
+	var order []string
+	for _, e := range feed {
+		order = append(order, e.Package)
+	}
+	if strings.Join(order, "") != "cbdae" {
+		t.Errorf("Unexpected order: %v", order)
+	}
+}
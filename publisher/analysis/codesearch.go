@@ -2,130 +2,267 @@ package analysis
 
 import (
 	"archive/tar"
-	"bytes"
 	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
-	"strings"
 
 	"github.com/google/codesearch/index"
 	"github.com/klauspost/compress/zstd"
 )
 
 const (
-	// To determine if a file is binary, check if the first <window> (1K) bytes
-	// contain a null byte.
+	// To determine if a file is binary (or UTF-16), check the first <window>
+	// (1K) bytes for null bytes. See encodingSniffer.
 	binarySniffingWindow = 1024
 
-	// Files over <size> (1M) bytes are assumed not to be code and are skipped.
-	largeFileSize = 1024 * 1024
+	// Files over <size> (1M) bytes are indexed into a separate, "large" index,
+	// since they're less likely to be code and they're expensive to search.
+	// This can be lowered per-distro in the config file, but not raised above
+	// internal.MaxSearchFileSize.
+	DefaultLargeFileSize = 1024 * 1024
+
+	// Files over <size> (64M) bytes are skipped entirely. This can be
+	// overridden per-distro in the config file.
+	DefaultMaxFileSize = 64 * 1024 * 1024
 )
 
-func ConstructCodesearchIndex(a Archive) ([]byte, []byte) {
-	container, err := os.MkdirTemp("", "srccodes-cs-"+a.Pkg.Name)
+// CodesearchLimits controls which files are included in the codesearch index.
+type CodesearchLimits struct {
+	LargeFileSize int64
+	MaxFileSize   int64
+}
+
+// A CodesearchIndex is the output of ConstructCodesearchIndex. Each shard
+// consists of an index and a tarball of the indexed source files. Large files
+// are kept in their own shard so the grep server can search them differently.
+//
+// For big packages these run to hundreds of megabytes, so they're left on disk
+// for the uploader to stream. Call Remove once they've been uploaded.
+type CodesearchIndex struct {
+	Index, Source           Artifact
+	LargeIndex, LargeSource Artifact
+
+	container string
+}
+
+// An Artifact is a file produced by the analysis, to be streamed to storage.
+type Artifact struct {
+	Path string
+	Size int64
+}
+
+func newArtifact(path string) Artifact {
+	stat, err := os.Stat(path)
 	if err != nil {
 		panic(err)
 	}
-	defer os.RemoveAll(container)
+	return Artifact{path, stat.Size()}
+}
 
-	cs := filepath.Join(container, "codesearch")
-	ix := index.Create(cs)
-	ix.AddPaths([]string{
-		a.Pkg.Name,
-	})
+// createArtifact creates a temporary file for an analysis to write its output
+// to. Pass it to closeArtifact once it's written.
+func createArtifact(name string) *os.File {
+	f, err := os.CreateTemp("", "srccodes-"+name+"-")
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+func closeArtifact(f *os.File) Artifact {
+	if err := f.Close(); err != nil {
+		panic(err)
+	}
+	return newArtifact(f.Name())
+}
+
+// Open opens the artifact for reading.
+func (a Artifact) Open() *os.File {
+	f, err := os.Open(a.Path)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// Remove deletes an artifact from disk. (The artifacts in a CodesearchIndex are
+// removed along with it instead.)
+func (a Artifact) Remove() {
+	if err := os.Remove(a.Path); err != nil {
+		panic(err)
+	}
+}
+
+// Remove deletes the index's files from disk.
+func (cs CodesearchIndex) Remove() {
+	if cs.container == "" {
+		return
+	}
+	if err := os.RemoveAll(cs.container); err != nil {
+		panic(err)
+	}
+}
+
+// A codesearchShard is an index and tarball under construction.
+type codesearchShard struct {
+	ix *index.IndexWriter
+	af *os.File
+	zw *zstd.Encoder
+	ar *tar.Writer
 
-	an := filepath.Join(container, "tar")
-	af, err := os.Create(an)
+	csPath, arPath string
+}
+
+func newCodesearchShard(container, name, pkg string) *codesearchShard {
+	var s = codesearchShard{
+		csPath: filepath.Join(container, name+".csi"),
+		arPath: filepath.Join(container, name+".tar"),
+	}
+	s.ix = index.Create(s.csPath)
+	s.ix.AddPaths([]string{pkg})
+
+	var err error
+	s.af, err = os.Create(s.arPath)
 	if err != nil {
 		panic(err)
 	}
-	zw, err := zstd.NewWriter(af)
+	s.zw, err = zstd.NewWriter(s.af)
 	if err != nil {
 		panic(err)
 	}
-	ar := tar.NewWriter(zw)
+	s.ar = tar.NewWriter(s.zw)
+	return &s
+}
 
-	win := make([]byte, binarySniffingWindow)
+// add adds a file to both the tarball and the index. The file must be encoded
+// in UTF-8.
+func (s *codesearchShard) add(name string, mode fs.FileMode, file *os.File) {
+	info, err := file.Stat()
+	if err != nil {
+		panic(err)
+	}
 
-	dir := strings.TrimSuffix(a.Dir, "/")
-	err = filepath.Walk(dir, func(path string, info fs.FileInfo, err error) error {
-		if err != nil {
-			return err
-		} else if !info.Mode().IsRegular() {
-			return nil
-		}
+	// Add to tar archive
+	hdr := &tar.Header{
+		Name: name,
+		Mode: int64(mode),
+		Size: info.Size(),
+	}
+	if err := s.ar.WriteHeader(hdr); err != nil {
+		panic(err)
+	}
+	if _, err := io.Copy(s.ar, file); err != nil {
+		panic(err)
+	}
 
-		if info.Size() > largeFileSize {
-			return nil
-		}
+	// Reset cursor to start of file
+	if _, err := file.Seek(0, 0); err != nil {
+		panic(err)
+	}
 
-		file, err := os.Open(path)
-		if err != nil {
-			panic(err)
-		}
-		defer file.Close()
+	// Add to codesearch index
+	s.ix.Add(name, file)
+}
 
-		// Skip binary files (see binarySniffingWindow, above)
-		n, err := file.Read(win)
-		if err != nil && err != io.EOF {
-			panic(err)
-		}
-		if bytes.Contains(win[:n], []byte("\x00")) {
-			return nil
+// finish flushes the index and tarball to disk.
+func (s *codesearchShard) finish() (Artifact, Artifact) {
+	s.ix.Flush()
+
+	if err := s.ar.Close(); err != nil {
+		panic(err)
+	}
+	if err := s.zw.Close(); err != nil {
+		panic(err)
+	}
+	if err := s.af.Close(); err != nil {
+		panic(err)
+	}
+	return newArtifact(s.csPath), newArtifact(s.arPath)
+}
+
+func ConstructCodesearchIndex(a Archive, limits CodesearchLimits) CodesearchIndex {
+	container, err := os.MkdirTemp("", "srccodes-cs-"+a.Pkg.Name)
+	if err != nil {
+		panic(err)
+	}
+	var ok bool
+	defer func() {
+		if !ok {
+			os.RemoveAll(container)
 		}
+	}()
 
-		// Reset cursor to start of file
-		if _, err = file.Seek(0, 0); err != nil {
-			panic(err)
+	normal := newCodesearchShard(container, "codesearch", a.Pkg.Name)
+	large := newCodesearchShard(container, "large", a.Pkg.Name)
+
+	// Walk the tree rather than the filesystem, so that we pick up the
+	// contents of nested archives too (if they were expanded).
+	a.Tree.WalkFiles(func(path string, f File) {
+		if f.Size > limits.MaxFileSize {
+			return
 		}
 
-		// Add to tar archive
-		name := filepath.Join(a.Pkg.Name, strings.TrimPrefix(path, dir))
-		hdr := &tar.Header{
-			Name: name,
-			Mode: int64(info.Mode()),
-			Size: info.Size(),
+		// Skip binary files (see encodingSniffer)
+		if f.Encoding == EncodingBinary {
+			return
 		}
-		if err := ar.WriteHeader(hdr); err != nil {
+
+		file, err := os.Open(f.LocalPath)
+		if err != nil {
 			panic(err)
 		}
-		if _, err := io.Copy(ar, file); err != nil {
-			panic(err)
+		defer file.Close()
+
+		// Index and serve a UTF-8 copy of files in other encodings. Files in
+		// unrecognized encodings are indexed as-is.
+		if f.Encoding != EncodingUTF8 && f.Encoding != EncodingUnknown {
+			file = transcodeFile(container, file, f.Encoding)
+			defer os.Remove(file.Name())
+			defer file.Close()
 		}
 
-		// Reset cursor to start of file
-		if _, err = file.Seek(0, 0); err != nil {
+		// Size limits apply to the transcoded file
+		stat, err := file.Stat()
+		if err != nil {
 			panic(err)
 		}
 
-		// Add to codesearch index
-		ix.Add(name, file)
-		return nil
+		// Most large files are too big for codesearch to index, so the grep
+		// server scans everything in the large shard's tarball that's missing
+		// from its index.
+		name := a.Pkg.Name + "/" + path
+		if stat.Size() > limits.LargeFileSize {
+			large.add(name, f.Mode, file)
+		} else {
+			normal.add(name, f.Mode, file)
+		}
 	})
-	if err != nil {
-		panic(err)
-	}
 
-	ix.Flush()
+	var result = CodesearchIndex{container: container}
+	result.Index, result.Source = normal.finish()
+	result.LargeIndex, result.LargeSource = large.finish()
+	ok = true
+	return result
+}
 
-	if err := ar.Close(); err != nil {
-		panic(err)
-	}
-	if err := zw.Close(); err != nil {
+// transcodeFile converts a file to UTF-8, writing the result to a temporary file
+// in the given directory. The caller is responsible for closing and removing the
+// returned file.
+func transcodeFile(container string, file *os.File, enc string) *os.File {
+	r, err := NewUTF8Reader(file, enc)
+	if err != nil {
 		panic(err)
 	}
-	if err := af.Close(); err != nil {
+	out, err := os.CreateTemp(container, "transcoded-")
+	if err != nil {
 		panic(err)
 	}
-
-	buf1, err := os.ReadFile(cs)
-	if err != nil {
+	if _, err := io.Copy(out, r); err != nil {
 		panic(err)
 	}
-	buf2, err := os.ReadFile(an)
-	if err != nil {
+	if _, err := out.Seek(0, io.SeekStart); err != nil {
 		panic(err)
 	}
-	return buf1, buf2
+	return out
 }
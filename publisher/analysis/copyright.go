@@ -0,0 +1,207 @@
+package analysis
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/btidor/src.codes/publisher/control"
+)
+
+const (
+	// Copyright files in machine-readable format begin with a header paragraph
+	// whose Format field points to the DEP-5 specification.
+	//
+	// https://www.debian.org/doc/packaging-manuals/copyright-format/1.0/
+	dep5FormatMarker = "copyright-format"
+
+	unknownLicense = "unknown"
+)
+
+// Formats for the Copyright.Format field.
+const (
+	CopyrightDEP5     = "dep5"      // machine-readable debian/copyright
+	CopyrightFreeForm = "free-form" // human-readable debian/copyright
+	CopyrightMissing  = "missing"   // no debian/copyright at all
+)
+
+// Common license names, used to guess the license of a package whose
+// debian/copyright is not machine-readable. The first match wins, so more
+// specific patterns should come first.
+var freeFormLicenses = []struct {
+	Pattern *regexp.Regexp
+	License string
+}{
+	{regexp.MustCompile(`(?i)GNU Lesser General Public License`), "LGPL"},
+	{regexp.MustCompile(`(?i)GNU Library General Public License`), "LGPL"},
+	{regexp.MustCompile(`(?i)GNU Affero General Public License`), "AGPL"},
+	{regexp.MustCompile(`(?i)GNU General Public License`), "GPL"},
+	{regexp.MustCompile(`(?i)Apache License`), "Apache"},
+	{regexp.MustCompile(`(?i)Mozilla Public License`), "MPL"},
+	{regexp.MustCompile(`(?i)Artistic License`), "Artistic"},
+	{regexp.MustCompile(`(?i)/usr/share/common-licenses/([A-Za-z0-9.+-]*[A-Za-z0-9+])`), "$1"},
+	{regexp.MustCompile(`(?i)Permission is hereby granted, free of charge`), "Expat"},
+	{regexp.MustCompile(`(?i)Redistribution and use in source and binary forms`), "BSD"},
+	{regexp.MustCompile(`(?i)public domain`), "public-domain"},
+}
+
+// Copyright is a parsed debian/copyright file.
+type Copyright struct {
+	Format  string
+	Stanzas []FilesStanza // for DEP-5 files, in order of appearance
+	License string        // for free-form files, our best guess
+}
+
+// A FilesStanza is a `Files:` paragraph from a DEP-5 copyright file. The
+// patterns are matched against paths relative to the root of the package.
+type FilesStanza struct {
+	Patterns []string
+	License  string
+
+	matchers []*regexp.Regexp
+}
+
+// LicenseSummary describes the licenses found in a single package. It's
+// uploaded as a per-package index and consolidated into a distro-level index.
+type LicenseSummary struct {
+	Format   string         `json:"format"`
+	Licenses map[string]int `json:"licenses"` // license -> number of files
+}
+
+// ReadCopyright reads and parses the debian/copyright file in the given
+// directory. Missing or unparseable files are not an error: they result in a
+// Copyright with a fallback format.
+func ReadCopyright(dir string) Copyright {
+	data, err := os.ReadFile(filepath.Join(dir, "debian", "copyright"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return Copyright{Format: CopyrightMissing, License: unknownLicense}
+	} else if err != nil {
+		panic(err)
+	}
+	return parseCopyright(string(data))
+}
+
+func parseCopyright(data string) Copyright {
+	docs, err := control.ParseAll(data)
+	if err != nil || len(docs) == 0 ||
+		!strings.Contains(docs[0]["Format"], dep5FormatMarker) {
+		return parseFreeFormCopyright(data)
+	}
+
+	var c = Copyright{Format: CopyrightDEP5}
+	for _, doc := range docs[1:] {
+		files, ok := doc["Files"]
+		if !ok {
+			// Standalone License paragraph or unknown stanza
+			continue
+		}
+		var stanza = FilesStanza{
+			Patterns: strings.Fields(files),
+			License:  licenseShortName(doc["License"]),
+		}
+		for _, p := range stanza.Patterns {
+			stanza.matchers = append(stanza.matchers, compileFilesPattern(p))
+		}
+		c.Stanzas = append(c.Stanzas, stanza)
+	}
+	if len(c.Stanzas) == 0 {
+		// Header paragraph but no Files stanzas: not useful for resolving
+		// per-file licenses, so treat it like a free-form file.
+		return parseFreeFormCopyright(data)
+	}
+	return c
+}
+
+func parseFreeFormCopyright(data string) Copyright {
+	for _, l := range freeFormLicenses {
+		if m := l.Pattern.FindStringSubmatchIndex(data); m != nil {
+			license := string(l.Pattern.ExpandString(nil, l.License, data, m))
+			return Copyright{Format: CopyrightFreeForm, License: license}
+		}
+	}
+	return Copyright{Format: CopyrightFreeForm, License: unknownLicense}
+}
+
+// licenseShortName extracts the license name (the first line of a License
+// field); the remaining lines, if any, are the license text.
+func licenseShortName(field string) string {
+	name, _, _ := strings.Cut(field, "\n")
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return unknownLicense
+	}
+	return name
+}
+
+// compileFilesPattern converts a DEP-5 Files pattern into a regular expression.
+// Only `*` and `?` are wildcards, and both match a slash. A backslash escapes
+// the following character.
+func compileFilesPattern(pattern string) *regexp.Regexp {
+	var sb strings.Builder
+	sb.WriteString("^")
+	pattern = strings.TrimPrefix(pattern, "./")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				sb.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+			}
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.MustCompile(sb.String())
+}
+
+// LicenseFor returns the license of the file at the given path, relative to the
+// root of the package. Per DEP-5, the last matching stanza wins.
+func (c Copyright) LicenseFor(path string) string {
+	if c.Format != CopyrightDEP5 {
+		return c.License
+	}
+	for i := len(c.Stanzas) - 1; i >= 0; i-- {
+		for _, m := range c.Stanzas[i].matchers {
+			if m.MatchString(path) {
+				return c.Stanzas[i].License
+			}
+		}
+	}
+	return unknownLicense
+}
+
+// attachLicenses resolves the license of every file in the tree and records
+// it on the File.
+func attachLicenses(dir Directory, prefix string, c Copyright) {
+	for name, node := range dir.Contents {
+		path := prefix + name
+		switch node := node.(type) {
+		case File:
+			node.License = c.LicenseFor(path)
+			dir.Contents[name] = node
+		case Directory:
+			attachLicenses(node, path+"/", c)
+		}
+	}
+}
+
+// ConstructLicenseIndex summarizes the licenses of the files in the archive.
+// Archive.Tree must already have licenses attached.
+func ConstructLicenseIndex(a Archive) LicenseSummary {
+	var summary = LicenseSummary{
+		Format:   a.Copyright.Format,
+		Licenses: make(map[string]int),
+	}
+	for _, f := range a.Tree.Files() {
+		summary.Licenses[f.License] += 1
+	}
+	return summary
+}
@@ -0,0 +1,105 @@
+package analysis
+
+import (
+	"testing"
+)
+
+const dep5Copyright = `Format: https://www.debian.org/doc/packaging-manuals/copyright-format/1.0/
+Upstream-Name: example
+Source: https://example.com/
+
+Files: *
+Copyright: 2020 Example Author
+License: GPL-2+
+ This program is free software; you can redistribute it and/or modify
+ it under the terms of the GNU General Public License.
+ .
+ See /usr/share/common-licenses/GPL-2.
+
+Files: lib/*.c
+       lib/*.h
+Copyright: 2021 Someone Else
+License: Expat
+
+Files: debian/*
+Copyright: 2022 Debian Maintainer
+License: GPL-2+
+
+Files: lib/weird\*name.c
+Copyright: 2023 Odd Person
+License: BSD-3-clause
+
+License: Expat
+ Permission is hereby granted, free of charge, ...
+`
+
+func TestParseCopyrightDEP5(t *testing.T) {
+	c := parseCopyright(dep5Copyright)
+	if c.Format != CopyrightDEP5 {
+		t.Fatalf("Wrong format: %#v", c)
+	}
+	if len(c.Stanzas) != 4 {
+		t.Fatalf("Wrong number of stanzas: %#v", c.Stanzas)
+	}
+
+	var cases = map[string]string{
+		"README":            "GPL-2+",
+		"src/main.c":        "GPL-2+",
+		"lib/foo.c":         "Expat",
+		"lib/sub/dir/foo.h": "Expat",
+		"lib/foo.py":        "GPL-2+",
+		"debian/rules":      "GPL-2+",
+		"lib/weird*name.c":  "BSD-3-clause",
+		"lib/weirdXname.c":  "Expat",
+	}
+	for path, expected := range cases {
+		if actual := c.LicenseFor(path); actual != expected {
+			t.Errorf("Wrong license for %s: got %q, expected %q", path, actual, expected)
+		}
+	}
+}
+
+func TestParseCopyrightFreeForm(t *testing.T) {
+	c := parseCopyright(`This package was debianized by Someone.
+
+It is licensed under the GNU Lesser General Public License, version 2.1.
+`)
+	if c.Format != CopyrightFreeForm {
+		t.Fatalf("Wrong format: %#v", c)
+	}
+	if actual := c.LicenseFor("any/file.c"); actual != "LGPL" {
+		t.Errorf("Wrong license: %q", actual)
+	}
+
+	c = parseCopyright("On Debian systems, see /usr/share/common-licenses/Apache-2.0.\n")
+	if actual := c.LicenseFor("any/file.c"); actual != "Apache-2.0" {
+		t.Errorf("Wrong license: %q", actual)
+	}
+
+	c = parseCopyright("Nothing to see here.\n")
+	if actual := c.LicenseFor("any/file.c"); actual != unknownLicense {
+		t.Errorf("Wrong license: %q", actual)
+	}
+}
+
+func TestAttachLicenses(t *testing.T) {
+	var tree = Directory{
+		Contents: map[string]INode{
+			"README": file,
+			"lib": Directory{
+				Contents: map[string]INode{
+					"foo.c": file,
+				},
+			},
+		},
+	}
+	attachLicenses(tree, "", parseCopyright(dep5Copyright))
+
+	if l := tree.Contents["README"].(File).License; l != "GPL-2+" {
+		t.Errorf("Wrong license for README: %q", l)
+	}
+	lib := tree.Contents["lib"].(Directory)
+	if l := lib.Contents["foo.c"].(File).License; l != "Expat" {
+		t.Errorf("Wrong license for lib/foo.c: %q", l)
+	}
+}
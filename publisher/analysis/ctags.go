@@ -2,31 +2,62 @@ package analysis
 
 import (
 	"bufio"
-	"bytes"
-	"os/exec"
+	"io"
+	"strconv"
 	"strings"
 )
 
-func ConstructCtagsIndex(a Archive) []byte {
-	cmd := exec.Command(
+// A Tag is a single definition from the ctags index.
+type Tag struct {
+	Name string
+	Path string // relative to the root of the package
+	Line int    // 1-indexed
+	Kind string // single-letter kind, language-dependent
+
+	// Scope is the name of the enclosing definition (class, struct, etc.), if
+	// any, from ctags' extension fields.
+	Scope string
+
+	// Doc is the definition's doc comment, if AttachDocs has been called.
+	Doc string
+}
+
+// Extension fields that don't describe a tag's scope.
+var nonScopeFields = map[string]bool{
+	"access": true, "file": true, "implementation": true, "inherits": true,
+	"kind": true, "language": true, "line": true, "signature": true,
+	"typeref": true,
+}
+
+// ConstructCtagsIndex runs ctags over the archive, writing the index to disk,
+// since it can be large. If ctags fails, the failure is logged and the package
+// is treated as having no tags. Call Remove on the result when done with it.
+func ConstructCtagsIndex(a Archive) Artifact {
+	f := createArtifact("ctags")
+	out := bufio.NewWriter(f)
+	err := runToolTo(ctagsLimits, a.Dir, nil, out, // paths are relative to a.Dir
 		"ctags", "-f", "-", "--recurse", "--links=no", "--excmd=number",
 		"--exclude=*.json",  // due to segfault on libcpanel-json-xs-perl test cases
 		"--exclude=*.patch", // tags are unnecessary and garbled
 		"--exclude=*.md",    // verbose + not useful (?)
 	)
-	cmd.Dir = a.Dir // paths are relative to this directory
-	out, err := cmd.Output()
 	if err != nil {
+		err.(*ToolError).Log(a.Pkg.Slug())
+		out.Reset(f)
+		if err := f.Truncate(0); err != nil {
+			panic(err)
+		}
+	}
+	if err := out.Flush(); err != nil {
 		panic(err)
 	}
-	return out
+	return closeArtifact(f)
 }
 
-func parseCtags(ctags []byte) map[string][]string {
+func parseCtags(ctags io.Reader) map[string][]string {
 	var result = make(map[string][]string)
 
-	rd := bytes.NewReader(ctags)
-	sc := bufio.NewScanner(rd)
+	sc := bufio.NewScanner(ctags)
 	for sc.Scan() {
 		parts := strings.SplitN(sc.Text(), "\t", 2)
 		tag := parts[0]
@@ -34,3 +65,47 @@ func parseCtags(ctags []byte) map[string][]string {
 	}
 	return result
 }
+
+// ParseTags parses ctags output (as produced by ConstructCtagsIndex) into a
+// list of tags, in the order they appear. Pseudo-tags and malformed lines are
+// skipped.
+func ParseTags(ctags io.Reader) []Tag {
+	var tags []Tag
+
+	sc := bufio.NewScanner(ctags)
+	sc.Buffer(nil, 1024*1024)
+	for sc.Scan() {
+		if tag, ok := parseTagLine(sc.Text()); ok {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// parseTagLine parses a line in the format `name<TAB>path<TAB>line;"<TAB>kind`,
+// possibly followed by extension fields.
+func parseTagLine(line string) (Tag, bool) {
+	if strings.HasPrefix(line, "!_") {
+		return Tag{}, false
+	}
+	parts := strings.Split(line, "\t")
+	if len(parts) < 3 {
+		return Tag{}, false
+	}
+	lineno, err := strconv.Atoi(strings.TrimSuffix(parts[2], ";\""))
+	if err != nil {
+		return Tag{}, false
+	}
+	var tag = Tag{Name: parts[0], Path: parts[1], Line: lineno}
+	for i, field := range parts[3:] {
+		key, value, found := strings.Cut(field, ":")
+		if !found && i == 0 {
+			tag.Kind = field
+		} else if found && key == "kind" {
+			tag.Kind = value
+		} else if found && !nonScopeFields[key] && tag.Scope == "" {
+			tag.Scope = value
+		}
+	}
+	return tag, true
+}
@@ -0,0 +1,122 @@
+package analysis
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"path"
+	"sort"
+)
+
+// A Diff lists the files that changed between two versions of a package.
+type Diff struct {
+	Package     string         `json:"package"`
+	FromVersion string         `json:"from_version"`
+	ToVersion   string         `json:"to_version"`
+	Added       []DiffEntry    `json:"added"`
+	Removed     []DiffEntry    `json:"removed"`
+	Modified    []ModifiedFile `json:"modified"`
+}
+
+type DiffEntry struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+}
+
+type ModifiedFile struct {
+	Path      string `json:"path"`
+	OldSHA256 string `json:"old_sha256"`
+	NewSHA256 string `json:"new_sha256"`
+}
+
+// treeNode is the subset of the tree JSON format needed to read back a
+// previously-uploaded tree. It's compatible with every schema version.
+type treeNode struct {
+	Type     string              `json:"type"`
+	SHA256   string              `json:"sha256"`
+	Contents map[string]treeNode `json:"contents"`
+}
+
+// ParseTreeHashes reads a tree in JSON format (as produced by MarshalTree) and
+// returns a map from each file's path to its hex-encoded SHA-256 hash.
+func ParseTreeHashes(data []byte) (map[string]string, error) {
+	var root treeNode
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	var hashes = make(map[string]string)
+	var visit func(n treeNode, prefix string)
+	visit = func(n treeNode, prefix string) {
+		for name, child := range n.Contents {
+			switch child.Type {
+			case "file":
+				hashes[path.Join(prefix, name)] = child.SHA256
+			case "directory":
+				visit(child, path.Join(prefix, name))
+			}
+		}
+	}
+	visit(root, "")
+	return hashes, nil
+}
+
+// TreeHashes returns a map from each file's path to its hex-encoded SHA-256
+// hash, in the same format as ParseTreeHashes.
+func TreeHashes(d Directory) map[string]string {
+	var hashes = make(map[string]string)
+	var visit func(d Directory, prefix string)
+	visit = func(d Directory, prefix string) {
+		for name, node := range d.Contents {
+			switch node := node.(type) {
+			case File:
+				hashes[path.Join(prefix, name)] = hex.EncodeToString(node.SHA256[:])
+			case Directory:
+				visit(node, path.Join(prefix, name))
+			}
+		}
+	}
+	visit(d, "")
+	return hashes
+}
+
+// ConstructDiffIndex compares the archive against the file hashes of the
+// previous version of the package. Entries are sorted by path.
+func ConstructDiffIndex(a Archive, fromVersion string, previous map[string]string) Diff {
+	return DiffTreeHashes(a.Pkg.Name, fromVersion, a.Pkg.Version, previous, TreeHashes(a.Tree))
+}
+
+// DiffTreeHashes compares the file hashes of two versions of a package, as
+// returned by TreeHashes or ParseTreeHashes. Entries are sorted by path.
+func DiffTreeHashes(name, fromVersion, toVersion string, previous, current map[string]string) Diff {
+	var diff = Diff{
+		Package:     name,
+		FromVersion: fromVersion,
+		ToVersion:   toVersion,
+		Added:       []DiffEntry{},
+		Removed:     []DiffEntry{},
+		Modified:    []ModifiedFile{},
+	}
+
+	for p, hash := range current {
+		if old, found := previous[p]; !found {
+			diff.Added = append(diff.Added, DiffEntry{p, hash})
+		} else if old != hash {
+			diff.Modified = append(diff.Modified, ModifiedFile{p, old, hash})
+		}
+	}
+	for p, hash := range previous {
+		if _, found := current[p]; !found {
+			diff.Removed = append(diff.Removed, DiffEntry{p, hash})
+		}
+	}
+
+	sort.Slice(diff.Added, func(i, j int) bool {
+		return diff.Added[i].Path < diff.Added[j].Path
+	})
+	sort.Slice(diff.Removed, func(i, j int) bool {
+		return diff.Removed[i].Path < diff.Removed[j].Path
+	})
+	sort.Slice(diff.Modified, func(i, j int) bool {
+		return diff.Modified[i].Path < diff.Modified[j].Path
+	})
+	return diff
+}
@@ -0,0 +1,56 @@
+package analysis
+
+import (
+	"testing"
+
+	"github.com/btidor/src.codes/publisher/apt"
+)
+
+func TestConstructDiffIndex(t *testing.T) {
+	var other = file
+	other.SHA256[0] = 0xff
+
+	var old = Directory{
+		Contents: map[string]INode{
+			"same.txt":    file,
+			"changed.txt": file,
+			"gone": Directory{
+				Contents: map[string]INode{"old.txt": file},
+			},
+		},
+	}
+	data, err := MarshalTree(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous, err := ParseTreeHashes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var a = Archive{
+		Pkg: &apt.Package{Name: "example", Version: "2.0"},
+		Tree: Directory{
+			Contents: map[string]INode{
+				"same.txt":    file,
+				"changed.txt": other,
+				"new.txt":     file,
+			},
+		},
+	}
+	diff := ConstructDiffIndex(a, "1.0", previous)
+
+	if len(diff.Added) != 1 || diff.Added[0].Path != "new.txt" {
+		t.Errorf("Wrong added files: %#v", diff.Added)
+	}
+	if len(diff.Removed) != 1 || diff.Removed[0].Path != "gone/old.txt" {
+		t.Errorf("Wrong removed files: %#v", diff.Removed)
+	}
+	if len(diff.Modified) != 1 || diff.Modified[0].Path != "changed.txt" ||
+		diff.Modified[0].OldSHA256 == diff.Modified[0].NewSHA256 {
+		t.Errorf("Wrong modified files: %#v", diff.Modified)
+	}
+	if diff.FromVersion != "1.0" || diff.ToVersion != "2.0" {
+		t.Errorf("Wrong versions: %#v", diff)
+	}
+}
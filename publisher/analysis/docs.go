@@ -0,0 +1,240 @@
+package analysis
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// Doc comments are truncated to about this many bytes, preferably at a
+	// paragraph break.
+	maxDocLength = 1024
+
+	// How far to look for the start of a block comment or the end of a
+	// docstring, in lines.
+	maxDocLines = 200
+)
+
+// Languages whose doc comments use C-style `//` and `/* */` syntax.
+var slashCommentLanguages = map[*language]bool{
+	langC: true, langCPlusPlus: true, langCSharp: true, langD: true,
+	langGo: true, langJava: true, langJavaScript: true, langKotlin: true,
+	langObjC: true, langPHP: true, langRust: true, langScala: true,
+	langSwift: true, langTypeScript: true, langVala: true,
+}
+
+// Languages whose doc comments are `#` comments.
+var hashCommentLanguages = map[*language]bool{
+	langPerl: true, langPython: true, langRuby: true, langShell: true,
+	langTcl: true,
+}
+
+// AttachDocs fills in the Doc field of each tag with the documentation for the
+// definition, read from the source files in the given directory. Tags are
+// grouped by file, so that each file is read once and only one file is held in
+// memory at a time.
+func AttachDocs(dir string, tags []Tag) {
+	var byPath = make(map[string][]int)
+	for i, tag := range tags {
+		if detectLanguage(tag.Path) != nil {
+			byPath[tag.Path] = append(byPath[tag.Path], i)
+		}
+	}
+
+	var paths []string
+	for p := range byPath {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	for _, p := range paths {
+		lines := readLines(filepath.Join(dir, p))
+		for _, i := range byPath[p] {
+			tags[i].Doc = extractDoc(tags[i], lines)
+		}
+	}
+}
+
+// extractDoc finds the documentation for a tag: the comment immediately
+// preceding the definition, or for Python, the docstring following it.
+func extractDoc(tag Tag, lines []string) string {
+	var lang = detectLanguage(tag.Path)
+	var idx = tag.Line - 1 // 0-indexed line of the definition
+	if lang == nil || idx < 0 || idx >= len(lines) {
+		return ""
+	}
+
+	var doc []string
+	if lang == langPython {
+		doc = pythonDocstring(lines, idx)
+	}
+	if doc == nil && slashCommentLanguages[lang] {
+		doc = precedingBlockComment(lines, idx)
+	}
+	if doc != nil {
+		return truncateDoc(normalizeDoc(doc))
+	}
+
+	// Line comments are taken as-is, after the space following the comment
+	// marker, so that indentation within the comment is preserved.
+	if slashCommentLanguages[lang] {
+		doc = precedingLineComments(lines, idx, "//")
+	} else if hashCommentLanguages[lang] {
+		doc = precedingLineComments(lines, idx, "#")
+	}
+	for i, line := range doc {
+		doc[i] = strings.TrimPrefix(line, " ")
+	}
+	return truncateDoc(strings.Trim(strings.Join(doc, "\n"), "\n"))
+}
+
+// precedingLineComments collects the run of line comments directly above the
+// definition, e.g. `// ...` or `/// ...`.
+func precedingLineComments(lines []string, idx int, prefix string) []string {
+	var doc []string
+	for i := idx - 1; i >= 0 && i >= idx-maxDocLines; i-- {
+		trimmed := strings.TrimSpace(lines[i])
+		if !strings.HasPrefix(trimmed, prefix) {
+			break
+		}
+		text := strings.TrimLeft(trimmed, prefix[:1])
+		text = strings.TrimPrefix(text, "!") // Rust `//!`, shell `#!`
+		doc = append([]string{text}, doc...)
+	}
+	if len(doc) > 0 && strings.HasPrefix(strings.TrimSpace(lines[idx-len(doc)]), "#!") {
+		doc = doc[1:] // shebang
+	}
+	if len(doc) == 0 {
+		return nil
+	}
+	return doc
+}
+
+// precedingBlockComment collects a `/* ... */` comment that ends on the line
+// directly above the definition.
+func precedingBlockComment(lines []string, idx int) []string {
+	if idx == 0 || !strings.HasSuffix(strings.TrimSpace(lines[idx-1]), "*/") {
+		return nil
+	}
+	for i := idx - 1; i >= 0 && i >= idx-maxDocLines; i-- {
+		start := strings.Index(lines[i], "/*")
+		if start < 0 {
+			continue
+		}
+		var doc []string
+		for j := i; j < idx; j++ {
+			text := lines[j]
+			if j == i {
+				text = strings.TrimLeft(text[start+2:], "*!")
+			}
+			if j == idx-1 {
+				text = strings.TrimSuffix(strings.TrimSpace(text), "*/")
+				text = strings.TrimRight(text, "*")
+			}
+			// Strip the leading ` * ` decoration, if any
+			if trimmed := strings.TrimLeft(text, " \t"); j > i && strings.HasPrefix(trimmed, "*") {
+				text = strings.TrimPrefix(trimmed, "*")
+			}
+			doc = append(doc, text)
+		}
+		return doc
+	}
+	return nil
+}
+
+// pythonDocstring collects the docstring following a `def` or `class`
+// statement, which may span several lines.
+func pythonDocstring(lines []string, idx int) []string {
+	// Find the end of the statement, i.e. the colon at the end of a line
+	var i = idx
+	for ; i < len(lines) && i < idx+maxDocLines; i++ {
+		if strings.HasSuffix(strings.TrimSpace(stripPythonComment(lines[i])), ":") {
+			break
+		}
+	}
+	// Find the first non-blank line of the body
+	for i++; i < len(lines) && strings.TrimSpace(lines[i]) == ""; i++ {
+	}
+	if i >= len(lines) {
+		return nil
+	}
+
+	var first = strings.TrimSpace(lines[i])
+	first = strings.TrimLeft(first, "rRuUbB")
+	var quote string
+	for _, q := range []string{`"""`, `'''`} {
+		if strings.HasPrefix(first, q) {
+			quote = q
+		}
+	}
+	if quote == "" {
+		return nil
+	}
+
+	var doc []string
+	var text = first[len(quote):]
+	for j := i; j < len(lines) && j < i+maxDocLines; j++ {
+		if j > i {
+			text = lines[j]
+		}
+		if end := strings.Index(text, quote); end >= 0 {
+			return append(doc, text[:end])
+		}
+		doc = append(doc, text)
+	}
+	return doc // unterminated, or too long
+}
+
+func stripPythonComment(line string) string {
+	if i := strings.Index(line, "#"); i >= 0 {
+		return line[:i]
+	}
+	return line
+}
+
+// normalizeDoc removes common indentation and leading and trailing blank
+// lines. As with Python docstrings, the first line doesn't count towards the
+// indentation, since it usually follows the opening quotes or comment marker.
+func normalizeDoc(doc []string) string {
+	var indent = -1
+	for i, line := range doc {
+		doc[i] = strings.TrimRight(line, " \t\r")
+		if i == 0 {
+			doc[i] = strings.TrimLeft(doc[i], " \t")
+			continue
+		} else if doc[i] == "" {
+			continue
+		}
+		n := len(doc[i]) - len(strings.TrimLeft(doc[i], " \t"))
+		if indent < 0 || n < indent {
+			indent = n
+		}
+	}
+	for i, line := range doc {
+		if i > 0 && len(line) >= indent && indent > 0 {
+			doc[i] = line[indent:]
+		}
+	}
+	return strings.Trim(strings.Join(doc, "\n"), "\n")
+}
+
+// truncateDoc shortens long documentation, at a paragraph break if there's one
+// in the second half of the allowed length, otherwise at a line or word break.
+func truncateDoc(doc string) string {
+	if len(doc) <= maxDocLength {
+		return doc
+	}
+	var cut = doc[:maxDocLength]
+	for !utf8.ValidString(cut) {
+		cut = cut[:len(cut)-1]
+	}
+	for _, sep := range []string{"\n\n", "\n", " "} {
+		if i := strings.LastIndex(cut, sep); i > maxDocLength/2 {
+			cut = cut[:i]
+			break
+		}
+	}
+	return strings.TrimRight(cut, " \n") + " …"
+}
@@ -0,0 +1,74 @@
+package analysis
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestExtractDoc(t *testing.T) {
+	for _, tc := range []struct {
+		path, source string
+		line         int
+		expected     string
+	}{
+		{"a.c", "#include <x.h>\n\n/**\n * Frobnicate the widget.\n *\n * Returns zero.\n */\nint frob(void);\n", 8,
+			"Frobnicate the widget.\n\nReturns zero."},
+		{"a.c", "/* Single line. */\nint x;\n", 2, "Single line."},
+		{"a.c", "int y;\n\nint x;\n", 3, ""},
+		{"a.cc", "/// Adds things.\n/// Carefully.\nint add(int a, int b);\n", 3, "Adds things.\nCarefully."},
+		{"a.go", "package a\n\n// Foo does foo.\n//\n//\tindented\nfunc Foo() {}\n", 6, "Foo does foo.\n\n\tindented"},
+		{"a.rs", "/// Makes a thing.\npub fn make() {}\n", 2, "Makes a thing."},
+		{"a.py", "def f(a,\n      b):  # comment\n    \"\"\"Does f.\n\n    More detail.\n    \"\"\"\n    pass\n", 1,
+			"Does f.\n\nMore detail."},
+		{"a.py", "class C:\n    r'''One line.'''\n", 1, "One line."},
+		{"a.py", "# Helper.\ndef g():\n    return 1\n", 2, "Helper."},
+		{"a.sh", "#!/bin/sh\n# Prints hi.\nhi() {\n", 3, "Prints hi."},
+		{"a.txt", "// not code\nfoo\n", 2, ""},
+	} {
+		lines := strings.Split(tc.source, "\n")
+		doc := extractDoc(Tag{Path: tc.path, Line: tc.line}, lines)
+		if doc != tc.expected {
+			t.Errorf("Wrong doc for %s line %d: got %q, want %q", tc.path, tc.line, doc, tc.expected)
+		}
+	}
+}
+
+func TestAttachDocs(t *testing.T) {
+	dir := t.TempDir()
+	for name, source := range map[string]string{
+		"a.go": "package a\n\n// A does a.\nfunc A() {}\n\n// C does c.\nfunc C() {}\n",
+		"b.go": "package b\n\n// B does b.\nfunc B() {}\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Sorted by name, as ctags outputs them, so files are interleaved
+	tags := []Tag{
+		{Name: "A", Path: "a.go", Line: 4},
+		{Name: "B", Path: "b.go", Line: 4},
+		{Name: "C", Path: "a.go", Line: 7},
+		{Name: "README", Path: "README", Line: 1},
+	}
+	AttachDocs(dir, tags)
+	for i, expected := range []string{"A does a.", "B does b.", "C does c.", ""} {
+		if tags[i].Doc != expected {
+			t.Errorf("Wrong doc for %s: got %q, want %q", tags[i].Name, tags[i].Doc, expected)
+		}
+	}
+}
+
+func TestTruncateDoc(t *testing.T) {
+	long := strings.Repeat("word ", 150) + "\n\n" + strings.Repeat("é", 600)
+	doc := truncateDoc(long)
+	if len(doc) > maxDocLength+len(" …") || !utf8.ValidString(doc) {
+		t.Errorf("Bad truncation: %q", doc)
+	}
+	if !strings.HasSuffix(doc, "word …") {
+		t.Errorf("Expected truncation at paragraph break, got %q", doc[len(doc)-20:])
+	}
+}
@@ -0,0 +1,369 @@
+package analysis
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// Values for File.Encoding. UTF-8 (including plain ASCII) is represented by the
+// empty string, since it's by far the most common.
+const (
+	EncodingUTF8        = ""
+	EncodingBinary      = "binary"
+	EncodingUTF16LE     = "utf-16le"
+	EncodingUTF16BE     = "utf-16be"
+	EncodingISO8859_1   = "iso-8859-1"
+	EncodingWindows1252 = "windows-1252"
+	EncodingShiftJIS    = "shift_jis"
+	EncodingEUCJP       = "euc-jp"
+	EncodingGBK         = "gbk"
+
+	// Not UTF-8, and not recognizably in any other encoding. These files are
+	// indexed and served as-is.
+	EncodingUnknown = "unknown"
+)
+
+// For UTF-16 text without a byte-order mark, at least this fraction of the
+// bytes in the sniffing window must be NULs, all in the high-order position.
+// (ASCII text encoded as UTF-16 is half NULs.)
+const utf16NulThreshold = 0.3
+
+// Text in a single-byte encoding is mostly ASCII, with the occasional accented
+// letter. If more than this fraction of the bytes are non-ASCII, it's more
+// likely to be binary or in an encoding we don't detect (e.g. KOI8-R).
+const singleByteHighThreshold = 0.3
+
+// For a file to be detected as Shift-JIS or EUC-JP over GBK, at least this
+// fraction of its multibyte characters must be kana, as in most Japanese text.
+const kanaThreshold = 0.2
+
+var decoders = map[string]encoding.Encoding{
+	EncodingUTF16LE:     unicode.UTF16(unicode.LittleEndian, unicode.UseBOM),
+	EncodingUTF16BE:     unicode.UTF16(unicode.BigEndian, unicode.UseBOM),
+	EncodingISO8859_1:   charmap.ISO8859_1,
+	EncodingWindows1252: charmap.Windows1252,
+	EncodingShiftJIS:    japanese.ShiftJIS,
+	EncodingEUCJP:       japanese.EUCJP,
+	EncodingGBK:         simplifiedchinese.GBK,
+}
+
+// An encodingSniffer guesses the character encoding of a file. It's an
+// io.Writer, so that it can be fed the file's contents while they're being
+// hashed.
+type encodingSniffer struct {
+	head    []byte // first binarySniffingWindow bytes
+	partial []byte // incomplete UTF-8 sequence at the end of the last write
+	invalid bool   // contents are not valid UTF-8
+
+	// Statistics for the single-byte encodings
+	total     int  // bytes
+	high      int  // bytes in the range 0x80-0xFF
+	c1        bool // contents include bytes in the range 0x80-0x9F
+	undefined bool // contents include bytes that aren't used in Windows-1252
+	control   bool // contents include control characters, other than whitespace
+
+	multibyte []*multibyteSniffer
+}
+
+func (s *encodingSniffer) Write(p []byte) (int, error) {
+	if n := binarySniffingWindow - len(s.head); n > 0 {
+		s.head = append(s.head, p[:min(n, len(p))]...)
+	}
+
+	s.total += len(p)
+	for _, b := range p {
+		switch {
+		case b < 0x20:
+			if b != '\t' && b != '\n' && b != '\v' && b != '\f' && b != '\r' && b != 0x1b {
+				s.control = true
+			}
+		case b == 0x7f:
+			s.control = true
+		case b >= 0x80:
+			s.high++
+			if b <= 0x9f {
+				s.c1 = true
+				if b == 0x81 || b == 0x8d || b == 0x8f || b == 0x90 || b == 0x9d {
+					s.undefined = true
+				}
+			}
+		}
+	}
+
+	if s.multibyte == nil {
+		s.multibyte = newMultibyteSniffers()
+	}
+	for _, m := range s.multibyte {
+		m.Write(p)
+	}
+
+	if !s.invalid {
+		// Set aside any incomplete rune at the end of the buffer, since the
+		// rest of it will arrive in the next write.
+		buf := p
+		if len(s.partial) > 0 {
+			buf = append(s.partial, p...)
+		}
+		cut := len(buf)
+		for i := len(buf) - 1; i >= 0 && i >= len(buf)-utf8.UTFMax; i-- {
+			if utf8.RuneStart(buf[i]) {
+				if !utf8.FullRune(buf[i:]) {
+					cut = i
+				}
+				break
+			}
+		}
+		s.invalid = !utf8.Valid(buf[:cut])
+		s.partial = append([]byte(nil), buf[cut:]...)
+	}
+	return len(p), nil
+}
+
+// Encoding returns the detected encoding, once the entire file has been
+// written.
+func (s *encodingSniffer) Encoding() string {
+	switch {
+	case bytes.HasPrefix(s.head, []byte{0xef, 0xbb, 0xbf}):
+		return EncodingUTF8
+	case bytes.HasPrefix(s.head, []byte{0xff, 0xfe}):
+		return EncodingUTF16LE
+	case bytes.HasPrefix(s.head, []byte{0xfe, 0xff}):
+		return EncodingUTF16BE
+	}
+
+	if bytes.IndexByte(s.head, 0) >= 0 {
+		return sniffUTF16(s.head)
+	}
+
+	if !s.invalid && len(s.partial) == 0 {
+		return EncodingUTF8
+	}
+
+	if enc := sniffMultibyte(s.multibyte); enc != "" {
+		return enc
+	}
+
+	// Otherwise it may be in one of the single-byte encodings, but only if it
+	// looks like text: any of them will decode arbitrary bytes, so guessing
+	// wrong turns binary data or CJK text into mojibake.
+	if s.control || float64(s.high) > singleByteHighThreshold*float64(s.total) {
+		return EncodingUnknown
+	}
+
+	// In ISO-8859-1 the range 0x80-0x9F is reserved for control characters,
+	// so if those bytes are present it's more likely to be Windows-1252, which
+	// uses them for printable characters like curly quotes.
+	if s.undefined {
+		return EncodingUnknown
+	} else if s.c1 {
+		return EncodingWindows1252
+	}
+	return EncodingISO8859_1
+}
+
+// sniffMultibyte picks the CJK encoding a file is in, if any. The candidates'
+// byte structures overlap, so several may fit; a file is only assigned an
+// encoding if it's well-formed and looks like text in that encoding. If it
+// could be in more than one, Japanese text is recognized by its kana, and
+// Chinese text is assumed otherwise. Returns EncodingUnknown if the candidates
+// can't be told apart, or "" if there are none.
+func sniffMultibyte(sniffers []*multibyteSniffer) string {
+	var candidates, japanese []*multibyteSniffer
+	for _, m := range sniffers {
+		if !m.plausible() {
+			continue
+		}
+		candidates = append(candidates, m)
+		if m.isKana != nil && float64(m.kana) >= kanaThreshold*float64(m.chars) {
+			japanese = append(japanese, m)
+		}
+	}
+
+	switch {
+	case len(candidates) == 0:
+		return ""
+	case len(candidates) == 1:
+		return candidates[0].encoding
+	case len(japanese) == 1:
+		return japanese[0].encoding
+	case len(japanese) == 0:
+		for _, m := range candidates {
+			if m.encoding == EncodingGBK {
+				return EncodingGBK
+			}
+		}
+	}
+	return EncodingUnknown
+}
+
+// A multibyteSniffer checks whether a file is well-formed in one of the
+// multibyte CJK encodings, and counts its multibyte characters.
+type multibyteSniffer struct {
+	encoding string
+
+	// next checks the bytes of a character read so far, and reports whether
+	// they're valid and whether the character is complete.
+	next func(char []byte) (ok, complete bool)
+
+	// isKana reports whether a complete character is hiragana or katakana,
+	// for the Japanese encodings.
+	isKana func(char []byte) bool
+
+	char    []byte // incomplete character at the end of the last write
+	invalid bool
+
+	chars     int // complete multibyte characters
+	highTrail int // ...whose last byte is non-ASCII
+	kana      int // ...which are kana
+}
+
+func newMultibyteSniffers() []*multibyteSniffer {
+	return []*multibyteSniffer{
+		{encoding: EncodingShiftJIS, next: nextShiftJIS, isKana: isShiftJISKana},
+		{encoding: EncodingEUCJP, next: nextEUCJP, isKana: isEUCJPKana},
+		{encoding: EncodingGBK, next: nextGBK},
+	}
+}
+
+func (m *multibyteSniffer) Write(p []byte) {
+	for _, b := range p {
+		if m.invalid {
+			return
+		} else if b < 0x80 && len(m.char) == 0 {
+			continue // ASCII
+		}
+		m.char = append(m.char, b)
+		ok, complete := m.next(m.char)
+		if !ok {
+			m.invalid = true
+		} else if complete {
+			if len(m.char) > 1 {
+				m.chars++
+				if m.char[len(m.char)-1] >= 0x80 {
+					m.highTrail++
+				}
+				if m.isKana != nil && m.isKana(m.char) {
+					m.kana++
+				}
+			}
+			m.char = m.char[:0]
+		}
+	}
+}
+
+// plausible reports whether the file is well-formed in this encoding, and
+// looks like text in it. Every valid multibyte character in these encodings
+// can end in an ASCII byte, but most characters in real text don't: that's
+// more likely to be an accented letter in a single-byte encoding, followed by
+// ASCII.
+func (m *multibyteSniffer) plausible() bool {
+	return !m.invalid && len(m.char) == 0 && m.chars > 0 && m.highTrail*2 > m.chars
+}
+
+func nextShiftJIS(char []byte) (ok, complete bool) {
+	lead := char[0]
+	if len(char) == 1 {
+		switch {
+		case lead >= 0xa1 && lead <= 0xdf:
+			return true, true // half-width katakana
+		case lead >= 0x81 && lead <= 0x9f, lead >= 0xe0 && lead <= 0xef:
+			return true, false
+		default:
+			return false, false // includes the rarely-used user-defined range
+		}
+	}
+	trail := char[1]
+	return trail >= 0x40 && trail <= 0xfc && trail != 0x7f, true
+}
+
+func isShiftJISKana(char []byte) bool {
+	return len(char) == 2 && (char[0] == 0x82 && char[1] >= 0x9f || // hiragana
+		char[0] == 0x83 && char[1] <= 0x96) // katakana
+}
+
+func nextEUCJP(char []byte) (ok, complete bool) {
+	var inRange = func(b byte) bool { return b >= 0xa1 && b <= 0xfe }
+	lead := char[0]
+	switch len(char) {
+	case 1:
+		return inRange(lead) || lead == 0x8e || lead == 0x8f, false
+	case 2:
+		switch lead {
+		case 0x8e:
+			return char[1] >= 0xa1 && char[1] <= 0xdf, true // half-width katakana
+		case 0x8f:
+			return inRange(char[1]), false // JIS X 0212
+		default:
+			return inRange(char[1]), true
+		}
+	default:
+		return inRange(char[2]), true
+	}
+}
+
+func isEUCJPKana(char []byte) bool {
+	return len(char) == 2 && (char[0] == 0xa4 || char[0] == 0xa5)
+}
+
+func nextGBK(char []byte) (ok, complete bool) {
+	lead := char[0]
+	if len(char) == 1 {
+		switch {
+		case lead == 0x80:
+			return true, true // euro sign, in Windows' version
+		case lead == 0xff:
+			return false, false
+		default:
+			return true, false
+		}
+	}
+	trail := char[1]
+	return trail >= 0x40 && trail <= 0xfe && trail != 0x7f, true
+}
+
+// sniffUTF16 decides whether a window containing NUL bytes is UTF-16 text
+// without a byte-order mark, or binary data. Mostly-ASCII text encoded as
+// UTF-16 has NULs in every other byte, either odd (little-endian) or even
+// (big-endian) positions.
+func sniffUTF16(head []byte) string {
+	var even, odd int
+	for i, b := range head {
+		if b != 0 {
+			continue
+		} else if i%2 == 0 {
+			even++
+		} else {
+			odd++
+		}
+	}
+	var threshold = int(utf16NulThreshold * float64(len(head)))
+	switch {
+	case odd > threshold && even == 0:
+		return EncodingUTF16LE
+	case even > threshold && odd == 0:
+		return EncodingUTF16BE
+	default:
+		return EncodingBinary
+	}
+}
+
+// NewUTF8Reader returns a reader that transcodes a file from the given
+// encoding to UTF-8. UTF-16 byte-order marks are removed.
+func NewUTF8Reader(r io.Reader, enc string) (io.Reader, error) {
+	if enc == EncodingUTF8 {
+		return r, nil
+	}
+	decoder, ok := decoders[enc]
+	if !ok {
+		return nil, fmt.Errorf("cannot transcode from encoding %q", enc)
+	}
+	return decoder.NewDecoder().Reader(r), nil
+}
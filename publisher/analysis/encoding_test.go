@@ -0,0 +1,83 @@
+package analysis
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func sniff(data []byte, chunk int) string {
+	var s encodingSniffer
+	for len(data) > 0 {
+		n := min(chunk, len(data))
+		s.Write(data[:n])
+		data = data[n:]
+	}
+	return s.Encoding()
+}
+
+func TestSniffEncoding(t *testing.T) {
+	var cases = []struct {
+		name     string
+		data     []byte
+		expected string
+	}{
+		{"ascii", []byte("hello, world\n"), EncodingUTF8},
+		{"utf-8", []byte("héllo, wörld ☃\n"), EncodingUTF8},
+		{"utf-8 bom", []byte("\xef\xbb\xbfhello\n"), EncodingUTF8},
+		{"utf-16le bom", []byte("\xff\xfeh\x00i\x00"), EncodingUTF16LE},
+		{"utf-16be bom", []byte("\xfe\xff\x00h\x00i"), EncodingUTF16BE},
+		{"utf-16le", []byte("h\x00e\x00l\x00l\x00o\x00"), EncodingUTF16LE},
+		{"utf-16be", []byte("\x00h\x00e\x00l\x00l\x00o"), EncodingUTF16BE},
+		{"binary", []byte("\x7fELF\x02\x01\x01\x00\x00\x00\x00\x00"), EncodingBinary},
+		{"latin-1", []byte("h\xe9llo w\xf6rld\n"), EncodingISO8859_1},
+		{"windows-1252", []byte("\x93quoted\x94\n"), EncodingWindows1252},
+		{"truncated", []byte("hello \xe2"), EncodingISO8859_1},
+		{"shift_jis", []byte("\x82\xb1\x82\xf1\x82\xc9\x82\xbf\x82\xcd\x81A\x90\xa2\x8aE\x81B" +
+			"\x82\xb1\x82\xea\x82\xcd\x83e\x83X\x83g\x82\xc5\x82\xb7\x81B\n"), EncodingShiftJIS},
+		{"euc-jp", []byte("\xa4\xb3\xa4\xf3\xa4\xcb\xa4\xc1\xa4\xcf\xa1\xa2\xc0\xa4\xb3\xa6\xa1\xa3" +
+			"\xa4\xb3\xa4\xec\xa4\xcf\xa5\xc6\xa5\xb9\xa5\xc8\xa4\xc7\xa4\xb9\xa1\xa3\n"), EncodingEUCJP},
+		{"gbk", []byte("\xc4\xe3\xba\xc3\xa3\xac\xca\xc0\xbd\xe7\xa1\xa3" +
+			"\xd5\xe2\xca\xc7\xd2\xbb\xb8\xf6\xb2\xe2\xca\xd4\xa1\xa3\n"), EncodingGBK},
+		{"binary without nul", []byte("\x7fELF\x02\x01\x01\x03\xa8\x9c\xff\xd2\x81\x04"), EncodingUnknown},
+		{"mostly non-ascii", []byte("\xc1\xd2\xd7\xc5\xd4 \xcd\xc9\xd2\n"), EncodingUnknown},
+	}
+	for _, c := range cases {
+		// Feed the data one byte at a time, too, to check that runes split
+		// across writes are handled correctly.
+		for _, chunk := range []int{1, 1024} {
+			if actual := sniff(c.data, chunk); actual != c.expected {
+				t.Errorf("Wrong encoding for %s (chunk %d): got %q, expected %q",
+					c.name, chunk, actual, c.expected)
+			}
+		}
+	}
+}
+
+func TestTranscode(t *testing.T) {
+	r, err := NewUTF8Reader(bytes.NewReader([]byte("\xff\xfeh\x00\xe9\x00")), EncodingUTF16LE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "hé" {
+		t.Errorf("Wrong transcoding: %#v", string(out))
+	}
+}
+
+func TestTranscodeShiftJIS(t *testing.T) {
+	r, err := NewUTF8Reader(bytes.NewReader([]byte("\x82\xb1\x82\xf1\x82\xc9\x82\xbf\x82\xcd\n")), EncodingShiftJIS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "こんにちは\n" {
+		t.Errorf("Wrong transcoding: %#v", string(out))
+	}
+}
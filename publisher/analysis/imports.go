@@ -0,0 +1,617 @@
+package analysis
+
+import (
+	"bufio"
+	"go/parser"
+	"go/token"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Languages supported by ConstructImportIndex, used as keys in ModuleSummary.
+const (
+	ImportPython = "python"
+	ImportGo     = "go"
+	ImportRust   = "rust"
+)
+
+// An Import is a single import statement (or dependency declaration).
+type Import struct {
+	Line     int    `json:"line"`
+	Language string `json:"language"`
+	Module   string `json:"module"` // as written, e.g. `os.path` or `crate::foo`
+
+	// Resolved is the file or directory within the package that provides the
+	// module, if any. Otherwise, clients should look up the module in the
+	// distro-wide module index.
+	Resolved string `json:"resolved,omitempty"`
+}
+
+// A ModuleSummary lists the modules a package provides to others, and the
+// external modules it depends on, by language.
+type ModuleSummary struct {
+	Provides map[string][]string `json:"provides"`
+	Requires map[string][]string `json:"requires"`
+}
+
+var (
+	pythonImport     = regexp.MustCompile(`^\s*import\s+([A-Za-z0-9_.,\s]+?)\s*(?:#.*)?$`)
+	pythonFromImport = regexp.MustCompile(`^\s*from\s+(\.*[A-Za-z0-9_.]*)\s+import\b`)
+	rustUse          = regexp.MustCompile(`^\s*(?:pub(?:\([^)]*\))?\s+)?use\s+(?:::)?([A-Za-z_][A-Za-z0-9_]*)`)
+	rustExternCrate  = regexp.MustCompile(`^\s*(?:pub\s+)?extern\s+crate\s+([A-Za-z_][A-Za-z0-9_]*)`)
+	cargoSection     = regexp.MustCompile(`^\s*\[([^\]]+)\]\s*(?:#.*)?$`)
+	cargoKey         = regexp.MustCompile(`^\s*([A-Za-z0-9_-]+|"[^"]+")\s*=\s*(.*)$`)
+	cargoPackageKey  = regexp.MustCompile(`package\s*=\s*"([^"]+)"`)
+	goModule         = regexp.MustCompile(`^\s*module\s+"?([^"\s]+)"?`)
+)
+
+// Rust crates that are part of the standard distribution.
+var rustBuiltinCrates = map[string]bool{
+	"std": true, "core": true, "alloc": true, "proc_macro": true, "test": true,
+}
+
+// Top-level modules in the Python standard library, as of Python 3.11
+// (sys.stdlib_module_names), plus some that were renamed or removed after
+// Python 2.
+var pythonStdlibModules = map[string]bool{
+	"__builtin__": true, "__future__": true, "_abc": true,
+	"_aix_support": true, "_ast": true, "_asyncio": true, "_bisect": true,
+	"_blake2": true, "_bootsubprocess": true, "_bz2": true, "_codecs": true,
+	"_codecs_cn": true, "_codecs_hk": true, "_codecs_iso2022": true,
+	"_codecs_jp": true, "_codecs_kr": true, "_codecs_tw": true,
+	"_collections": true, "_collections_abc": true, "_compat_pickle": true,
+	"_compression": true, "_contextvars": true, "_crypt": true,
+	"_csv": true, "_ctypes": true, "_curses": true, "_curses_panel": true,
+	"_datetime": true, "_dbm": true, "_decimal": true, "_elementtree": true,
+	"_frozen_importlib": true, "_frozen_importlib_external": true,
+	"_functools": true, "_gdbm": true, "_hashlib": true, "_heapq": true,
+	"_imp": true, "_io": true, "_json": true, "_locale": true,
+	"_lsprof": true, "_lzma": true, "_markupbase": true, "_md5": true,
+	"_msi": true, "_multibytecodec": true, "_multiprocessing": true,
+	"_opcode": true, "_operator": true, "_osx_support": true,
+	"_overlapped": true, "_pickle": true, "_posixshmem": true,
+	"_posixsubprocess": true, "_py_abc": true, "_pydecimal": true,
+	"_pyio": true, "_queue": true, "_random": true, "_scproxy": true,
+	"_sha1": true, "_sha256": true, "_sha3": true, "_sha512": true,
+	"_signal": true, "_sitebuiltins": true, "_socket": true,
+	"_sqlite3": true, "_sre": true, "_ssl": true, "_stat": true,
+	"_statistics": true, "_string": true, "_strptime": true,
+	"_struct": true, "_symtable": true, "_thread": true,
+	"_threading_local": true, "_tkinter": true, "_tokenize": true,
+	"_tracemalloc": true, "_typing": true, "_uuid": true, "_warnings": true,
+	"_weakref": true, "_weakrefset": true, "_winapi": true,
+	"_zoneinfo": true, "abc": true, "aifc": true, "antigravity": true,
+	"argparse": true, "array": true, "ast": true, "asynchat": true,
+	"asyncio": true, "asyncore": true, "atexit": true, "audioop": true,
+	"base64": true, "bdb": true, "binascii": true, "bisect": true,
+	"builtins": true, "bz2": true, "calendar": true, "cgi": true,
+	"cgitb": true, "chunk": true, "cmath": true, "cmd": true, "code": true,
+	"codecs": true, "codeop": true, "collections": true, "colorsys": true,
+	"commands": true, "compileall": true, "concurrent": true,
+	"configparser": true, "ConfigParser": true, "contextlib": true,
+	"contextvars": true, "copy": true, "copyreg": true, "cPickle": true,
+	"cProfile": true, "crypt": true, "cStringIO": true, "csv": true,
+	"ctypes": true, "curses": true, "dataclasses": true, "datetime": true,
+	"dbm": true, "decimal": true, "difflib": true, "dis": true,
+	"distutils": true, "doctest": true, "email": true, "encodings": true,
+	"ensurepip": true, "enum": true, "errno": true, "exceptions": true,
+	"faulthandler": true, "fcntl": true, "filecmp": true, "fileinput": true,
+	"fnmatch": true, "fractions": true, "ftplib": true, "functools": true,
+	"gc": true, "genericpath": true, "getopt": true, "getpass": true,
+	"gettext": true, "glob": true, "graphlib": true, "grp": true,
+	"gzip": true, "hashlib": true, "heapq": true, "hmac": true,
+	"html": true, "HTMLParser": true, "http": true, "httplib": true,
+	"idlelib": true, "imaplib": true, "imghdr": true, "imp": true,
+	"importlib": true, "inspect": true, "io": true, "ipaddress": true,
+	"itertools": true, "json": true, "keyword": true, "lib2to3": true,
+	"linecache": true, "locale": true, "logging": true, "lzma": true,
+	"mailbox": true, "mailcap": true, "marshal": true, "math": true,
+	"mimetypes": true, "mmap": true, "modulefinder": true, "msilib": true,
+	"msvcrt": true, "multiprocessing": true, "netrc": true, "nis": true,
+	"nntplib": true, "nt": true, "ntpath": true, "nturl2path": true,
+	"numbers": true, "opcode": true, "operator": true, "optparse": true,
+	"os": true, "ossaudiodev": true, "pathlib": true, "pdb": true,
+	"pickle": true, "pickletools": true, "pipes": true, "pkgutil": true,
+	"platform": true, "plistlib": true, "poplib": true, "posix": true,
+	"posixpath": true, "pprint": true, "profile": true, "pstats": true,
+	"pty": true, "pwd": true, "py_compile": true, "pyclbr": true,
+	"pydoc": true, "pydoc_data": true, "pyexpat": true, "queue": true,
+	"Queue": true, "quopri": true, "random": true, "re": true,
+	"readline": true, "reprlib": true, "resource": true,
+	"rlcompleter": true, "runpy": true, "sched": true, "secrets": true,
+	"select": true, "selectors": true, "sets": true, "shelve": true,
+	"shlex": true, "shutil": true, "signal": true, "site": true,
+	"smtpd": true, "smtplib": true, "sndhdr": true, "socket": true,
+	"socketserver": true, "SocketServer": true, "spwd": true,
+	"sqlite3": true, "sre_compile": true, "sre_constants": true,
+	"sre_parse": true, "ssl": true, "stat": true, "statistics": true,
+	"string": true, "StringIO": true, "stringprep": true, "struct": true,
+	"subprocess": true, "sunau": true, "symtable": true, "sys": true,
+	"sysconfig": true, "syslog": true, "tabnanny": true, "tarfile": true,
+	"telnetlib": true, "tempfile": true, "termios": true, "textwrap": true,
+	"this": true, "thread": true, "threading": true, "time": true,
+	"timeit": true, "tkinter": true, "Tkinter": true, "token": true,
+	"tokenize": true, "tomllib": true, "trace": true, "traceback": true,
+	"tracemalloc": true, "tty": true, "turtle": true, "turtledemo": true,
+	"types": true, "typing": true, "unicodedata": true, "unittest": true,
+	"urllib": true, "urllib2": true, "urlparse": true, "uu": true,
+	"uuid": true, "venv": true, "warnings": true, "wave": true,
+	"weakref": true, "webbrowser": true, "winreg": true, "winsound": true,
+	"wsgiref": true, "xdrlib": true, "xml": true, "xmlrpc": true,
+	"zipapp": true, "zipfile": true, "zipimport": true, "zlib": true,
+	"zoneinfo": true,
+}
+
+// Python files are resolved relative to these directories (and to any
+// directory containing a setup.py, setup.cfg or pyproject.toml).
+var pythonRootNames = []string{".", "src", "lib"}
+
+// importContext records what's in the package, for resolving imports.
+type importContext struct {
+	files       map[string]bool
+	dirs        map[string]bool
+	pythonRoots []string
+	setupRoots  map[string]bool   // Python roots with a setup.py, etc.
+	goModules   map[string]string // module path -> directory
+	rustCrates  map[string]string // crate name -> directory
+}
+
+// ConstructImportIndex extracts import statements from the archive's Python,
+// Go and Rust sources (and Cargo.toml dependencies), resolving them against the
+// package's own tree where possible. It also summarizes the modules provided
+// and required by the package, for building a distro-wide dependency graph.
+func ConstructImportIndex(a Archive) (map[string][]Import, ModuleSummary) {
+	var ctx = importContext{
+		files:      make(map[string]bool),
+		dirs:       map[string]bool{".": true},
+		setupRoots: make(map[string]bool),
+		goModules:  make(map[string]string),
+		rustCrates: make(map[string]string),
+	}
+	var sources = make(map[string]File)
+	var pythonRoots = make(map[string]bool)
+	for _, name := range pythonRootNames {
+		pythonRoots[name] = true
+	}
+
+	a.Tree.WalkFiles(func(p string, f File) {
+		ctx.files[p] = true
+		for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+			ctx.dirs[dir] = true
+		}
+		if f.Encoding == EncodingBinary {
+			return
+		}
+		switch base := path.Base(p); {
+		case base == "go.mod":
+			if module := readGoModule(f.LocalPath); module != "" {
+				ctx.goModules[module] = path.Dir(p)
+			}
+		case base == "Cargo.toml":
+			if crate := readCargoPackageName(f.LocalPath); crate != "" {
+				ctx.rustCrates[normalizeCrate(crate)] = path.Dir(p)
+			}
+			sources[p] = f
+		case base == "setup.py" || base == "setup.cfg" || base == "pyproject.toml":
+			pythonRoots[path.Dir(p)] = true
+			ctx.setupRoots[path.Dir(p)] = true
+			if base == "setup.py" {
+				sources[p] = f
+			}
+		case strings.HasSuffix(p, ".py") || strings.HasSuffix(p, ".go") || strings.HasSuffix(p, ".rs"):
+			sources[p] = f
+		}
+	})
+	for root := range pythonRoots {
+		ctx.pythonRoots = append(ctx.pythonRoots, root)
+	}
+	sort.Strings(ctx.pythonRoots)
+
+	var result = make(map[string][]Import)
+	var requires = make(map[string]map[string]bool)
+	for p, f := range sources {
+		var imports []Import
+		switch {
+		case strings.HasSuffix(p, ".py"):
+			imports = scanPythonImports(f.LocalPath)
+		case strings.HasSuffix(p, ".go"):
+			imports = scanGoImports(f.LocalPath)
+		case strings.HasSuffix(p, ".rs"):
+			imports = scanRustImports(f.LocalPath)
+		case path.Base(p) == "Cargo.toml":
+			imports = scanCargoDependencies(f.LocalPath)
+		}
+		for i := range imports {
+			imports[i].Resolved = ctx.resolve(p, imports[i])
+			if imports[i].Resolved == "" {
+				if name := externalModule(imports[i]); name != "" {
+					if requires[imports[i].Language] == nil {
+						requires[imports[i].Language] = make(map[string]bool)
+					}
+					requires[imports[i].Language][name] = true
+				}
+			}
+		}
+		if len(imports) > 0 {
+			result[p] = imports
+		}
+	}
+
+	var summary = ModuleSummary{
+		Provides: ctx.provides(),
+		Requires: make(map[string][]string),
+	}
+	for lang, names := range requires {
+		summary.Requires[lang] = sortedKeys(names)
+	}
+	return result, summary
+}
+
+func (ctx importContext) resolve(from string, imp Import) string {
+	switch imp.Language {
+	case ImportPython:
+		return ctx.resolvePython(from, imp.Module)
+	case ImportGo:
+		return ctx.resolveGo(imp.Module)
+	case ImportRust:
+		name, _, _ := strings.Cut(imp.Module, "::")
+		switch name {
+		case "crate", "self", "super":
+			// Refers to the current crate
+			for dir := path.Dir(from); ; dir = path.Dir(dir) {
+				if ctx.files[path.Join(dir, "Cargo.toml")] {
+					return dir
+				}
+				if dir == "." {
+					return ""
+				}
+			}
+		}
+		return ctx.rustCrates[normalizeCrate(name)]
+	}
+	return ""
+}
+
+func (ctx importContext) resolvePython(from, module string) string {
+	var bases []string
+	if strings.HasPrefix(module, ".") {
+		// Relative import: one dot is the current package, each additional
+		// dot goes up one level
+		base := path.Dir(from)
+		trimmed := strings.TrimLeft(module, ".")
+		for i := 1; i < len(module)-len(trimmed); i++ {
+			base = path.Dir(base)
+		}
+		bases = []string{base}
+		module = trimmed
+	} else {
+		// Scripts can also import modules from their own directory
+		bases = append(append([]string{}, ctx.pythonRoots...), path.Dir(from))
+	}
+	for _, base := range bases {
+		p := path.Join(base, strings.ReplaceAll(module, ".", "/"))
+		if strings.HasPrefix(p, "../") {
+			continue
+		}
+		for _, candidate := range []string{p + ".py", path.Join(p, "__init__.py")} {
+			if ctx.files[candidate] {
+				return candidate
+			}
+		}
+		if module != "" && ctx.dirs[p] {
+			return p // namespace package
+		}
+	}
+	return ""
+}
+
+func (ctx importContext) resolveGo(importPath string) string {
+	// Vendored dependencies. If several modules vendor the same package, pick
+	// one consistently.
+	var vendored []string
+	for _, dir := range ctx.goModules {
+		if candidate := path.Join(dir, "vendor", importPath); ctx.dirs[candidate] {
+			vendored = append(vendored, candidate)
+		}
+	}
+	if len(vendored) > 0 {
+		sort.Strings(vendored)
+		return vendored[0]
+	}
+
+	var best string
+	for module := range ctx.goModules {
+		if (importPath == module || strings.HasPrefix(importPath, module+"/")) && len(module) > len(best) {
+			best = module
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	dir := path.Join(ctx.goModules[best], strings.TrimPrefix(importPath, best))
+	if ctx.dirs[dir] {
+		return dir
+	}
+	return ""
+}
+
+// provides lists the modules in the package that other packages could import:
+// top-level Python packages and modules, Go modules and Rust crates.
+func (ctx importContext) provides() map[string][]string {
+	var python = make(map[string]bool)
+	for p := range ctx.files {
+		for _, root := range ctx.pythonRoots {
+			rel := p
+			if root != "." {
+				if !strings.HasPrefix(p, root+"/") {
+					continue
+				}
+				rel = strings.TrimPrefix(p, root+"/")
+			}
+			parts := strings.Split(rel, "/")
+			if len(parts) == 1 && strings.HasSuffix(rel, ".py") && rel != "setup.py" && ctx.setupRoots[root] {
+				// Only count single-file modules from projects that look
+				// installable, otherwise every stray script is a "module"
+				python[strings.TrimSuffix(rel, ".py")] = true
+			} else if len(parts) == 2 && parts[1] == "__init__.py" {
+				python[parts[0]] = true
+			}
+		}
+	}
+
+	var provides = make(map[string][]string)
+	if len(python) > 0 {
+		provides[ImportPython] = sortedKeys(python)
+	}
+	if len(ctx.goModules) > 0 {
+		provides[ImportGo] = sortedKeys(ctx.goModules)
+	}
+	if len(ctx.rustCrates) > 0 {
+		provides[ImportRust] = sortedKeys(ctx.rustCrates)
+	}
+	return provides
+}
+
+// externalModule returns the name to look up in other packages for an import
+// that couldn't be resolved locally, or "" if it's part of the language's
+// standard library.
+func externalModule(imp Import) string {
+	switch imp.Language {
+	case ImportPython:
+		if strings.HasPrefix(imp.Module, ".") {
+			return ""
+		}
+		name, _, _ := strings.Cut(imp.Module, ".")
+		if pythonStdlibModules[name] {
+			return ""
+		}
+		return name
+	case ImportGo:
+		first, _, _ := strings.Cut(imp.Module, "/")
+		if !strings.Contains(first, ".") {
+			return "" // standard library
+		}
+		return imp.Module
+	case ImportRust:
+		name, _, _ := strings.Cut(imp.Module, "::")
+		switch name {
+		case "crate", "self", "super":
+			return ""
+		}
+		if rustBuiltinCrates[name] {
+			return ""
+		}
+		return normalizeCrate(name)
+	}
+	return ""
+}
+
+// Cargo treats dashes and underscores in crate names as equivalent.
+func normalizeCrate(name string) string {
+	return strings.ReplaceAll(name, "-", "_")
+}
+
+// scanLines calls fn for each line of a file, with 1-indexed line numbers.
+// Scanning stops at lines that are too long.
+func scanLines(filename string, fn func(line int, text string)) {
+	f, err := os.Open(filename)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		fn(line, sc.Text())
+	}
+}
+
+func scanPythonImports(filename string) []Import {
+	var imports []Import
+	scanLines(filename, func(line int, text string) {
+		if m := pythonFromImport.FindStringSubmatch(text); m != nil {
+			imports = append(imports, Import{Line: line, Language: ImportPython, Module: m[1]})
+		} else if m := pythonImport.FindStringSubmatch(text); m != nil {
+			for _, clause := range strings.Split(m[1], ",") {
+				// `import a.b as c`
+				fields := strings.Fields(clause)
+				if len(fields) > 0 {
+					imports = append(imports, Import{Line: line, Language: ImportPython, Module: fields[0]})
+				}
+			}
+		}
+	})
+	return imports
+}
+
+func scanGoImports(filename string) []Import {
+	fset := token.NewFileSet()
+	// On syntax errors, the parser still returns whatever it could parse.
+	f, _ := parser.ParseFile(fset, filename, nil, parser.ImportsOnly)
+	if f == nil {
+		return nil
+	}
+	var imports []Import
+	for _, spec := range f.Imports {
+		importPath, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		imports = append(imports, Import{
+			Line:     fset.Position(spec.Pos()).Line,
+			Language: ImportGo,
+			Module:   importPath,
+		})
+	}
+	return imports
+}
+
+func scanRustImports(filename string) []Import {
+	var imports []Import
+	scanLines(filename, func(line int, text string) {
+		var m []string
+		if m = rustExternCrate.FindStringSubmatch(text); m == nil {
+			m = rustUse.FindStringSubmatch(text)
+		}
+		if m != nil {
+			imports = append(imports, Import{Line: line, Language: ImportRust, Module: m[1]})
+		}
+	})
+	return imports
+}
+
+// scanCargoDependencies lists the crates in the dependency sections of a
+// Cargo.toml file, e.g. `[dependencies]`, `[dev-dependencies]` and
+// `[target.'cfg(unix)'.dependencies]`, plus `[dependencies.foo]` tables.
+func scanCargoDependencies(filename string) []Import {
+	var imports []Import
+	var inDeps bool
+	scanLines(filename, func(line int, text string) {
+		if m := cargoSection.FindStringSubmatch(text); m != nil {
+			section := strings.TrimSpace(m[1])
+			inDeps = isCargoDependencySection(section)
+			if !inDeps {
+				// `[dependencies.foo]`
+				if i := strings.LastIndex(section, "."); i > 0 && isCargoDependencySection(section[:i]) {
+					imports = append(imports, Import{
+						Line: line, Language: ImportRust, Module: strings.Trim(section[i+1:], `"`),
+					})
+				}
+			}
+			return
+		}
+		if !inDeps {
+			return
+		}
+		if m := cargoKey.FindStringSubmatch(text); m != nil {
+			name := strings.Trim(m[1], `"`)
+			if p := cargoPackageKey.FindStringSubmatch(m[2]); p != nil {
+				name = p[1] // renamed dependency
+			}
+			imports = append(imports, Import{Line: line, Language: ImportRust, Module: name})
+		}
+	})
+	return imports
+}
+
+func isCargoDependencySection(section string) bool {
+	switch {
+	case section == "dependencies", section == "dev-dependencies",
+		section == "build-dependencies", section == "workspace.dependencies":
+		return true
+	case strings.HasPrefix(section, "target."):
+		return strings.HasSuffix(section, ".dependencies") ||
+			strings.HasSuffix(section, ".dev-dependencies") ||
+			strings.HasSuffix(section, ".build-dependencies")
+	}
+	return false
+}
+
+func readGoModule(filename string) string {
+	var module string
+	scanLines(filename, func(line int, text string) {
+		if m := goModule.FindStringSubmatch(text); m != nil && module == "" {
+			module = m[1]
+		}
+	})
+	return module
+}
+
+// readCargoPackageName returns the `name` from the `[package]` section of a
+// Cargo.toml file.
+func readCargoPackageName(filename string) string {
+	var name, section string
+	scanLines(filename, func(line int, text string) {
+		if m := cargoSection.FindStringSubmatch(text); m != nil {
+			section = strings.TrimSpace(m[1])
+		} else if m := cargoKey.FindStringSubmatch(text); m != nil && section == "package" && m[1] == "name" && name == "" {
+			value := strings.TrimSpace(m[2])
+			if unquoted, err := strconv.Unquote(value); err == nil {
+				name = unquoted
+			}
+		}
+	})
+	return name
+}
+
+// A ModuleIndex is the distro-wide view of ModuleSummary: which packages provide
+// each module, and which packages each package depends on.
+type ModuleIndex struct {
+	Modules      map[string]map[string][]string `json:"modules"`      // language -> module -> packages
+	Dependencies map[string][]string            `json:"dependencies"` // package -> packages
+}
+
+// ConstructModuleIndex combines the packages' module summaries into a
+// distro-wide index and dependency graph.
+func ConstructModuleIndex(summaries map[string]ModuleSummary) ModuleIndex {
+	var index = ModuleIndex{
+		Modules:      make(map[string]map[string][]string),
+		Dependencies: make(map[string][]string),
+	}
+	for _, pkg := range sortedKeys(summaries) {
+		for lang, modules := range summaries[pkg].Provides {
+			if index.Modules[lang] == nil {
+				index.Modules[lang] = make(map[string][]string)
+			}
+			for _, module := range modules {
+				index.Modules[lang][module] = append(index.Modules[lang][module], pkg)
+			}
+		}
+	}
+
+	for _, pkg := range sortedKeys(summaries) {
+		var deps = make(map[string]bool)
+		for lang, modules := range summaries[pkg].Requires {
+			for _, module := range modules {
+				for _, provider := range index.lookup(lang, module) {
+					if provider != pkg {
+						deps[provider] = true
+					}
+				}
+			}
+		}
+		if len(deps) > 0 {
+			index.Dependencies[pkg] = sortedKeys(deps)
+		}
+	}
+	return index
+}
+
+// lookup finds the packages providing a module. Go imports match the longest
+// module path that's a prefix of the import path.
+func (index ModuleIndex) lookup(lang, module string) []string {
+	if lang != ImportGo {
+		return index.Modules[lang][module]
+	}
+	for p := module; p != "." && p != "/"; p = path.Dir(p) {
+		if pkgs, found := index.Modules[lang][p]; found {
+			return pkgs
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package analysis
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestImportIndex(t *testing.T) {
+	tempdir := t.TempDir()
+	for name, content := range map[string]string{
+		"setup.py":           "from setuptools import setup\n",
+		"mypkg/__init__.py":  "",
+		"mypkg/util.py":      "import os, mypkg.core as core\nfrom . import helpers\nfrom requests.adapters import HTTPAdapter\n",
+		"mypkg/core.py":      "",
+		"mypkg/helpers.py":   "",
+		"go/go.mod":          "module example.com/tool\n\ngo 1.22\n",
+		"go/main.go":         "package main\n\nimport (\n\t\"fmt\"\n\t\"example.com/tool/internal\"\n\t\"golang.org/x/text/encoding\"\n)\n",
+		"go/internal/x.go":   "package internal\n",
+		"rs/Cargo.toml":      "[package]\nname = \"my-crate\"\n\n[dependencies]\nserde = \"1\"\nrand_core = { version = \"0.6\", package = \"rand-core\" }\n\n[dependencies.log]\nversion = \"0.4\"\n",
+		"rs/src/lib.rs":      "use std::fmt;\nuse crate::foo;\npub use serde::Serialize;\nextern crate my_crate;\n",
+		"scripts/helper.py":  "",
+		"scripts/helper2.py": "import helper\n",
+	} {
+		local := filepath.Join(tempdir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(local, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var tree, _ = constructTree(tempdir, 2)
+	imports, summary := ConstructImportIndex(Archive{Dir: tempdir, Tree: tree})
+
+	expected := map[string][]Import{
+		"setup.py": {
+			{Line: 1, Language: ImportPython, Module: "setuptools"},
+		},
+		"mypkg/util.py": {
+			{Line: 1, Language: ImportPython, Module: "os"},
+			{Line: 1, Language: ImportPython, Module: "mypkg.core", Resolved: "mypkg/core.py"},
+			{Line: 2, Language: ImportPython, Module: ".", Resolved: "mypkg/__init__.py"},
+			{Line: 3, Language: ImportPython, Module: "requests.adapters"},
+		},
+		"go/main.go": {
+			{Line: 4, Language: ImportGo, Module: "fmt"},
+			{Line: 5, Language: ImportGo, Module: "example.com/tool/internal", Resolved: "go/internal"},
+			{Line: 6, Language: ImportGo, Module: "golang.org/x/text/encoding"},
+		},
+		"rs/Cargo.toml": {
+			{Line: 5, Language: ImportRust, Module: "serde"},
+			{Line: 6, Language: ImportRust, Module: "rand-core"},
+			{Line: 8, Language: ImportRust, Module: "log"},
+		},
+		"rs/src/lib.rs": {
+			{Line: 1, Language: ImportRust, Module: "std"},
+			{Line: 2, Language: ImportRust, Module: "crate", Resolved: "rs"},
+			{Line: 3, Language: ImportRust, Module: "serde"},
+			{Line: 4, Language: ImportRust, Module: "my_crate", Resolved: "rs"},
+		},
+		"scripts/helper2.py": {
+			{Line: 1, Language: ImportPython, Module: "helper", Resolved: "scripts/helper.py"},
+		},
+	}
+	if !reflect.DeepEqual(imports, expected) {
+		t.Errorf("Unexpected imports:\n%#v", imports)
+	}
+
+	expectedSummary := ModuleSummary{
+		Provides: map[string][]string{
+			ImportPython: {"mypkg"},
+			ImportGo:     {"example.com/tool"},
+			ImportRust:   {"my_crate"},
+		},
+		Requires: map[string][]string{
+			ImportPython: {"requests", "setuptools"},
+			ImportGo:     {"golang.org/x/text/encoding"},
+			ImportRust:   {"log", "rand_core", "serde"},
+		},
+	}
+	if !reflect.DeepEqual(summary, expectedSummary) {
+		t.Errorf("Unexpected summary:\n%#v", summary)
+	}
+
+	index := ConstructModuleIndex(map[string]ModuleSummary{
+		"mine":          summary,
+		"python-reqs":   {Provides: map[string][]string{ImportPython: {"requests"}}},
+		"golang-x-text": {Provides: map[string][]string{ImportGo: {"golang.org/x/text"}}},
+	})
+	if deps := index.Dependencies["mine"]; !reflect.DeepEqual(deps, []string{"golang-x-text", "python-reqs"}) {
+		t.Errorf("Unexpected dependencies: %#v", index.Dependencies)
+	}
+}
+
+func TestResolveGoVendored(t *testing.T) {
+	var ctx = importContext{
+		dirs: map[string]bool{
+			"a/vendor/example.com/lib": true,
+			"b/vendor/example.com/lib": true,
+			"c/vendor/example.com/lib": true,
+		},
+		goModules: map[string]string{
+			"example.com/c": "c", "example.com/a": "a", "example.com/b": "b",
+		},
+	}
+	for range 10 {
+		if dir := ctx.resolveGo("example.com/lib"); dir != "a/vendor/example.com/lib" {
+			t.Fatalf("Expected the first vendored copy, got %q", dir)
+		}
+	}
+}
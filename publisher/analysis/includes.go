@@ -0,0 +1,164 @@
+package analysis
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var includeDirective = regexp.MustCompile(`^\s*#\s*(?:include|include_next|import)\s*([<"])([^>"]+)[>"]`)
+
+// Directories with this name are treated as include roots, in addition to the
+// root of the package.
+const includeRootName = "include"
+
+// Header file extensions. Files without an extension under an include root are
+// headers too (e.g. C++ standard library headers).
+var headerExtensions = map[string]bool{
+	".h": true, ".hh": true, ".hpp": true, ".hxx": true, ".h++": true, ".inc": true,
+}
+
+// An Include is a single #include directive.
+type Include struct {
+	Line   int    `json:"line"`
+	Target string `json:"target"` // as written
+	System bool   `json:"system"` // <angle brackets> rather than "quotes"
+
+	// Resolved is the path of the included file within the package, or empty
+	// if it isn't found. In that case, clients should look up Target in the
+	// distro-wide header index (see ConstructHeaderIndex).
+	Resolved string `json:"resolved,omitempty"`
+}
+
+// ConstructIncludeIndex extracts the #include directives from the archive's
+// C and C++ sources and resolves them against the package's own tree. Quoted
+// includes are looked up relative to the including file first; then both kinds
+// are looked up relative to the root and any `include/` directories.
+func ConstructIncludeIndex(a Archive) map[string][]Include {
+	var files = make(map[string]bool)
+	var sources = make(map[string]File)
+	a.Tree.WalkFiles(func(p string, f File) {
+		files[p] = true
+		switch detectLanguage(p) {
+		case langC, langCPlusPlus, langObjC:
+			if f.Encoding != EncodingBinary {
+				sources[p] = f
+			}
+		}
+	})
+	roots := includeRoots(files)
+
+	var result = make(map[string][]Include)
+	for p, f := range sources {
+		var includes = scanIncludes(f.LocalPath)
+		for i := range includes {
+			includes[i].Resolved = resolveInclude(p, includes[i], roots, files)
+		}
+		if len(includes) > 0 {
+			result[p] = includes
+		}
+	}
+	return result
+}
+
+// includeRoots lists the package's include roots, shallowest first, followed
+// by the root of the package itself.
+func includeRoots(files map[string]bool) []string {
+	var seen = make(map[string]bool)
+	for p := range files {
+		for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+			if path.Base(dir) == includeRootName {
+				seen[dir] = true
+			}
+		}
+	}
+	var roots []string
+	for dir := range seen {
+		roots = append(roots, dir)
+	}
+	sort.Slice(roots, func(i, j int) bool {
+		if len(roots[i]) != len(roots[j]) {
+			return len(roots[i]) < len(roots[j])
+		}
+		return roots[i] < roots[j]
+	})
+	return append(roots, ".")
+}
+
+func scanIncludes(filename string) []Include {
+	f, err := os.Open(filename)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	var includes []Include
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		if m := includeDirective.FindSubmatch(sc.Bytes()); m != nil {
+			includes = append(includes, Include{
+				Line:   line,
+				Target: strings.TrimSpace(string(m[2])),
+				System: string(m[1]) == "<",
+			})
+		}
+	}
+	// If the file has extremely long lines, just keep whatever we found
+	// before them.
+	return includes
+}
+
+func resolveInclude(from string, inc Include, roots []string, files map[string]bool) string {
+	var candidates []string
+	if !inc.System {
+		candidates = append(candidates, path.Join(path.Dir(from), inc.Target))
+	}
+	for _, root := range roots {
+		candidates = append(candidates, path.Join(root, inc.Target))
+	}
+	for _, c := range candidates {
+		if !strings.HasPrefix(c, "../") && files[c] {
+			return c
+		}
+	}
+	return ""
+}
+
+// A HeaderLocation is a header file shipped by a package.
+type HeaderLocation struct {
+	Package string `json:"package"`
+	Path    string `json:"path"`
+}
+
+// ConstructHeaderIndex lists the headers that other packages could include
+// from this one, keyed by the name they'd be included as (e.g. `zlib.h` or
+// `openssl/ssl.h`). Only headers in the root of the package or under an
+// `include/` directory are considered public.
+func ConstructHeaderIndex(a Archive) map[string]string {
+	var headers = make(map[string]string)
+	a.Tree.WalkFiles(func(p string, f File) {
+		var ext = path.Ext(p)
+		if !strings.Contains(p, "/") {
+			if headerExtensions[ext] {
+				headers[p] = p
+			}
+			return
+		}
+		for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+			if path.Base(dir) != includeRootName {
+				continue
+			}
+			if headerExtensions[ext] || (ext == "" && detectLanguage(p) == nil) {
+				name := strings.TrimPrefix(p, dir+"/")
+				if existing, found := headers[name]; !found || len(p) < len(existing) {
+					headers[name] = p
+				}
+			}
+		}
+	})
+	return headers
+}
@@ -0,0 +1,53 @@
+package analysis
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestIncludeIndex(t *testing.T) {
+	tempdir := t.TempDir()
+	for name, content := range map[string]string{
+		"include/foo/bar.h": "#pragma once\n",
+		"src/util.h":        "int util(void);\n",
+		"src/main.c":        "#include <stdio.h>\n# include \"util.h\"\n#include <foo/bar.h>\n\nint main() {}\n",
+		"zconf.h":           "\n",
+		"Makefile":          "all:\n",
+		"include/Makefile":  "all:\n",
+		"include/cstdthing": "\n",
+	} {
+		local := filepath.Join(tempdir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(local, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var tree, _ = constructTree(tempdir, 2)
+	var a = Archive{Dir: tempdir, Tree: tree}
+
+	includes := ConstructIncludeIndex(a)
+	expected := map[string][]Include{
+		"src/main.c": {
+			{Line: 1, Target: "stdio.h", System: true},
+			{Line: 2, Target: "util.h", Resolved: "src/util.h"},
+			{Line: 3, Target: "foo/bar.h", System: true, Resolved: "include/foo/bar.h"},
+		},
+	}
+	if !reflect.DeepEqual(includes, expected) {
+		t.Errorf("Unexpected includes: %#v", includes)
+	}
+
+	headers := ConstructHeaderIndex(a)
+	expectedHeaders := map[string]string{
+		"foo/bar.h": "include/foo/bar.h",
+		"cstdthing": "include/cstdthing",
+		"zconf.h":   "zconf.h",
+	}
+	if !reflect.DeepEqual(headers, expectedHeaders) {
+		t.Errorf("Unexpected headers: %#v", headers)
+	}
+}
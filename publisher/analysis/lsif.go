@@ -0,0 +1,268 @@
+package analysis
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+// The LSIF export is a newline-delimited JSON graph of vertices and edges, as
+// described in the LSIF specification. Each package is exported as a separate
+// project. Only definitions are included, since ctags doesn't find references;
+// definitions are also tagged with monikers, qualified by package, file and
+// scope, and with hover results if they have doc comments.
+//
+// https://microsoft.github.io/language-server-protocol/specifications/lsif/0.6.0/specification/
+
+const (
+	lsifVersion = "0.6.0"
+	lsifScheme  = "src.codes"
+)
+
+// Maps common ctags kinds to LSP SymbolKinds. Kinds are language-dependent,
+// but these letters are used consistently by the most common parsers.
+var lsifSymbolKinds = map[string]int{
+	"n": 3,  // namespace
+	"p": 12, // prototype -> function
+	"c": 5,  // class
+	"m": 8,  // member -> field
+	"f": 12, // function
+	"v": 13, // variable
+	"d": 14, // macro -> constant
+	"g": 10, // enum
+	"e": 22, // enumerator -> enum member
+	"s": 23, // struct
+	"u": 23, // union -> struct
+	"t": 26, // typedef -> type parameter
+}
+
+// Maps file extensions to LSIF language identifiers.
+var lsifLanguages = map[string]string{
+	".c":    "c",
+	".h":    "c",
+	".cc":   "cpp",
+	".cpp":  "cpp",
+	".cxx":  "cpp",
+	".hh":   "cpp",
+	".hpp":  "cpp",
+	".go":   "go",
+	".py":   "python",
+	".rs":   "rust",
+	".js":   "javascript",
+	".ts":   "typescript",
+	".java": "java",
+	".pl":   "perl",
+	".pm":   "perl",
+	".rb":   "ruby",
+	".sh":   "shellscript",
+}
+
+type lsifPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lsifEmitter struct {
+	enc *json.Encoder
+	id  int
+}
+
+// vertex emits a vertex with the given label and properties, and returns its
+// ID.
+func (e *lsifEmitter) vertex(label string, props map[string]any) int {
+	e.id++
+	var v = map[string]any{"id": e.id, "type": "vertex", "label": label}
+	for k, p := range props {
+		v[k] = p
+	}
+	if err := e.enc.Encode(v); err != nil {
+		panic(err)
+	}
+	return e.id
+}
+
+// edge emits an edge with the given label. `in` must be either an int or an
+// []int, depending on the edge type.
+func (e *lsifEmitter) edge(label string, out int, in any, props map[string]any) {
+	e.id++
+	var v = map[string]any{"id": e.id, "type": "edge", "label": label, "outV": out}
+	if vs, ok := in.([]int); ok {
+		v["inVs"] = vs
+	} else {
+		v["inV"] = in
+	}
+	for k, p := range props {
+		v[k] = p
+	}
+	if err := e.enc.Encode(v); err != nil {
+		panic(err)
+	}
+}
+
+// ConstructLSIFIndex exports the package's tree and ctags definitions as an
+// LSIF dump. The dump is written to disk; call Remove on the result when done
+// with it.
+func ConstructLSIFIndex(a Archive, ctags Artifact) Artifact {
+	f := createArtifact("lsif")
+	out := bufio.NewWriter(f)
+	var e = lsifEmitter{enc: json.NewEncoder(out)}
+
+	var root = "file:///" + a.Pkg.Name
+	e.vertex("metaData", map[string]any{
+		"version":          lsifVersion,
+		"projectRoot":      root,
+		"positionEncoding": "utf-16",
+		"toolInfo":         map[string]any{"name": lsifScheme},
+	})
+	project := e.vertex("project", map[string]any{
+		"kind": "src.codes",
+		"name": a.Pkg.Name,
+	})
+
+	// Group tags by file
+	var tagsByPath = make(map[string][]Tag)
+	in := ctags.Open()
+	defer in.Close()
+	for _, tag := range ParseTags(in) {
+		tagsByPath[tag.Path] = append(tagsByPath[tag.Path], tag)
+	}
+
+	var paths []string
+	for p := range TreeHashes(a.Tree) {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	var documents []int
+	for _, p := range paths {
+		document := e.vertex("document", map[string]any{
+			"uri":        root + "/" + p,
+			"languageId": lsifLanguageFor(p),
+		})
+		documents = append(documents, document)
+
+		tags := tagsByPath[p]
+		if len(tags) == 0 {
+			continue
+		}
+
+		var lines = readLines(filepath.Join(a.Dir, p))
+		var ranges []int
+		for _, tag := range tags {
+			start, end := tagPosition(tag, lines)
+			rng := e.vertex("range", map[string]any{
+				"start": start,
+				"end":   end,
+				"tag": map[string]any{
+					"type": "definition",
+					"text": tag.Name,
+					"kind": lsifSymbolKinds[tag.Kind],
+					"fullRange": map[string]any{
+						"start": start,
+						"end":   end,
+					},
+				},
+			})
+			ranges = append(ranges, rng)
+
+			resultSet := e.vertex("resultSet", nil)
+			e.edge("next", rng, resultSet, nil)
+
+			moniker := e.vertex("moniker", map[string]any{
+				"scheme":     lsifScheme,
+				"identifier": lsifIdentifier(a.Pkg.Name, tag),
+				"kind":       "export",
+				"unique":     "project",
+			})
+			e.edge("moniker", resultSet, moniker, nil)
+
+			if doc := extractDoc(tag, lines); doc != "" {
+				hover := e.vertex("hoverResult", map[string]any{
+					"result": map[string]any{
+						"contents": map[string]any{
+							"kind":  "plaintext",
+							"value": doc,
+						},
+					},
+				})
+				e.edge("textDocument/hover", resultSet, hover, nil)
+			}
+
+			defResult := e.vertex("definitionResult", nil)
+			e.edge("textDocument/definition", resultSet, defResult, nil)
+			e.edge("item", defResult, []int{rng}, map[string]any{
+				"document": document,
+			})
+		}
+		e.edge("contains", document, ranges, nil)
+	}
+	if len(documents) > 0 {
+		e.edge("contains", project, documents, nil)
+	}
+	if err := out.Flush(); err != nil {
+		panic(err)
+	}
+	return closeArtifact(f)
+}
+
+// lsifIdentifier names a definition for its moniker. Names alone are far from
+// unique (every C program has a `main`), so they're qualified with the package,
+// file and enclosing scope.
+func lsifIdentifier(pkg string, tag Tag) string {
+	var name = tag.Name
+	if tag.Scope != "" {
+		name = tag.Scope + "." + name
+	}
+	return pkg + ":" + tag.Path + ":" + name
+}
+
+func lsifLanguageFor(p string) string {
+	if lang, ok := lsifLanguages[strings.ToLower(path.Ext(p))]; ok {
+		return lang
+	}
+	return "plaintext"
+}
+
+// readLines reads a text file and splits it into lines. Errors are ignored,
+// since the only consequence is less precise positions.
+func readLines(filename string) []string {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var lines []string
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1024*1024)
+	for sc.Scan() {
+		lines = append(lines, sc.Text())
+	}
+	return lines
+}
+
+// tagPosition locates a tag's name on its line. Positions are 0-indexed and
+// measured in UTF-16 code units, per LSIF. If the name can't be found, the tag
+// covers the whole line.
+func tagPosition(tag Tag, lines []string) (lsifPosition, lsifPosition) {
+	var line = tag.Line - 1
+	if line < 0 || line >= len(lines) {
+		return lsifPosition{max(line, 0), 0}, lsifPosition{max(line, 0), 0}
+	}
+	var text = lines[line]
+	var col = strings.Index(text, tag.Name)
+	if col < 0 {
+		return lsifPosition{line, 0}, lsifPosition{line, utf16Len(text)}
+	}
+	var start = utf16Len(text[:col])
+	return lsifPosition{line, start}, lsifPosition{line, start + utf16Len(tag.Name)}
+}
+
+func utf16Len(s string) int {
+	return len(utf16.Encode([]rune(s)))
+}
@@ -0,0 +1,187 @@
+package analysis
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/btidor/src.codes/publisher/apt"
+)
+
+func TestLSIFIdentifier(t *testing.T) {
+	for _, tc := range []struct {
+		tag      Tag
+		expected string
+	}{
+		{Tag{Name: "main", Path: "src/main.c"}, "hello:src/main.c:main"},
+		{Tag{Name: "greet", Path: "src/greeter.cc", Scope: "Greeter"}, "hello:src/greeter.cc:Greeter.greet"},
+	} {
+		if actual := lsifIdentifier("hello", tc.tag); actual != tc.expected {
+			t.Errorf("Wrong identifier for %#v: got %q, want %q", tc.tag, actual, tc.expected)
+		}
+	}
+}
+
+func TestConstructLSIFIndex(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "src"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	var source = "// Greet says hello.\n" +
+		"void greet(void) {}\n" +
+		"const char *g = \"☃𝄞\"; int counter;\n"
+	if err := os.WriteFile(filepath.Join(dir, "src/main.c"), []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var ctags = "greet\tsrc/main.c\t2;\"\tf\n" +
+		"counter\tsrc/main.c\t3;\"\tv\n"
+	if err := os.WriteFile(filepath.Join(dir, "tags"), []byte(ctags), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var a = Archive{
+		Pkg: &apt.Package{Name: "hello"},
+		Dir: dir,
+		Tree: Directory{Contents: map[string]INode{
+			"README": File{},
+			"src":    Directory{Contents: map[string]INode{"main.c": File{}}},
+		}},
+	}
+	artifact := ConstructLSIFIndex(a, newArtifact(filepath.Join(dir, "tags")))
+	defer artifact.Remove()
+
+	in := artifact.Open()
+	defer in.Close()
+	var elements []map[string]any
+	var labels = make(map[int]string) // vertex ID -> label
+	sc := bufio.NewScanner(in)
+	for sc.Scan() {
+		var element map[string]any
+		if err := json.Unmarshal(sc.Bytes(), &element); err != nil {
+			t.Fatal(err)
+		}
+		id := int(element["id"].(float64))
+		if id != len(elements)+1 {
+			t.Fatalf("Expected IDs to be sequential, got %d after %d", id, len(elements))
+		}
+		elements = append(elements, element)
+
+		if element["type"] == "vertex" {
+			labels[id] = element["label"].(string)
+			continue
+		}
+		// Edges may only refer to vertices that have already been emitted
+		var refs = []any{element["outV"]}
+		if inV, ok := element["inV"]; ok {
+			refs = append(refs, inV)
+		} else {
+			refs = append(refs, element["inVs"].([]any)...)
+		}
+		for _, ref := range refs {
+			if _, ok := labels[int(ref.(float64))]; !ok {
+				t.Errorf("Edge %d refers to %v, which isn't an earlier vertex", id, ref)
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Follows the edge with the given label out of a vertex
+	var follow = func(out int, label string) []int {
+		var result []int
+		for _, e := range elements {
+			if e["type"] != "edge" || e["label"] != label || int(e["outV"].(float64)) != out {
+				continue
+			}
+			if inV, ok := e["inV"]; ok {
+				result = append(result, int(inV.(float64)))
+				continue
+			}
+			for _, inV := range e["inVs"].([]any) {
+				result = append(result, int(inV.(float64)))
+			}
+		}
+		return result
+	}
+	var find = func(label, key string, value any) map[string]any {
+		for _, e := range elements {
+			if e["label"] == label && reflect.DeepEqual(e[key], value) {
+				return e
+			}
+		}
+		t.Fatalf("No %s with %s = %v", label, key, value)
+		return nil
+	}
+	var id = func(e map[string]any) int {
+		return int(e["id"].(float64))
+	}
+
+	project := find("project", "name", "hello")
+	readme := find("document", "uri", "file:///hello/README")
+	main := find("document", "uri", "file:///hello/src/main.c")
+	if actual := follow(id(project), "contains"); !reflect.DeepEqual(actual, []int{id(readme), id(main)}) {
+		t.Errorf("Expected project to contain both documents, got %v", actual)
+	}
+	if actual := follow(id(readme), "contains"); actual != nil {
+		t.Errorf("Expected README to have no ranges, got %v", actual)
+	}
+	ranges := follow(id(main), "contains")
+	if len(ranges) != 2 {
+		t.Fatalf("Expected main.c to contain 2 ranges, got %v", ranges)
+	}
+
+	// Positions are in UTF-16 code units: the snowman is one, and the clef,
+	// which is outside the BMP, is two.
+	var position = func(line, character int) map[string]any {
+		return map[string]any{"line": float64(line), "character": float64(character)}
+	}
+	greet := elements[ranges[0]-1]
+	if !reflect.DeepEqual(greet["start"], position(1, 5)) ||
+		!reflect.DeepEqual(greet["end"], position(1, 10)) {
+		t.Errorf("Unexpected range for greet: %v", greet)
+	}
+	counter := elements[ranges[1]-1]
+	if !reflect.DeepEqual(counter["start"], position(2, 27)) ||
+		!reflect.DeepEqual(counter["end"], position(2, 34)) {
+		t.Errorf("Unexpected range for counter: %v", counter)
+	}
+
+	// Each range has a result set with a hover (if documented) and a
+	// definition result that points back to it
+	for i, rng := range ranges {
+		resultSets := follow(rng, "next")
+		if len(resultSets) != 1 || labels[resultSets[0]] != "resultSet" {
+			t.Fatalf("Expected range %d to have a result set, got %v", rng, resultSets)
+		}
+		hovers := follow(resultSets[0], "textDocument/hover")
+		if i == 0 {
+			if len(hovers) != 1 {
+				t.Fatalf("Expected a hover for greet, got %v", hovers)
+			}
+			hover := elements[hovers[0]-1]["result"].(map[string]any)["contents"].(map[string]any)
+			if hover["value"] != "Greet says hello." {
+				t.Errorf("Unexpected hover for greet: %v", hover)
+			}
+		} else if len(hovers) != 0 {
+			t.Errorf("Expected no hover for counter, got %v", hovers)
+		}
+
+		defResults := follow(resultSets[0], "textDocument/definition")
+		if len(defResults) != 1 || labels[defResults[0]] != "definitionResult" {
+			t.Fatalf("Expected range %d to have a definition result, got %v", rng, defResults)
+		}
+		if items := follow(defResults[0], "item"); !reflect.DeepEqual(items, []int{rng}) {
+			t.Errorf("Expected definition result to point to range %d, got %v", rng, items)
+		}
+		for _, e := range elements {
+			if e["label"] == "item" && int(e["outV"].(float64)) == defResults[0] &&
+				int(e["document"].(float64)) != id(main) {
+				t.Errorf("Expected item edge to belong to main.c: %v", e)
+			}
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package analysis
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// The contents of a nested archive appear in the tree as a virtual directory
+// alongside the archive itself, named with this suffix. For example, the
+// contents of `upstream.tar.gz` appear under `upstream.tar.gz!/`.
+const archiveMemberSuffix = "!"
+
+// NestedArchiveLimits bounds the work done by ExpandNestedArchives.
+type NestedArchiveLimits struct {
+	MaxDepth int   // how many levels of archives-within-archives to expand
+	MaxSize  int64 // total uncompressed bytes per archive
+	MaxFiles int   // total members per archive
+}
+
+var DefaultNestedArchiveLimits = NestedArchiveLimits{
+	MaxDepth: 2,
+	MaxSize:  256 * 1024 * 1024,
+	MaxFiles: 10000,
+}
+
+var errArchiveTooLarge = errors.New("archive exceeds size limits")
+
+// Recognized archive types, by file extension. Longer extensions must come
+// first so that e.g. `.tar.gz` isn't mistaken for `.gz`.
+var archiveExtensions = []struct {
+	ext  string
+	kind string
+}{
+	{".tar.gz", "tar.gz"},
+	{".tgz", "tar.gz"},
+	{".tar.bz2", "tar.bz2"},
+	{".tbz2", "tar.bz2"},
+	{".tar.xz", "tar.xz"},
+	{".txz", "tar.xz"},
+	{".tar.zst", "tar.zst"},
+	{".tar", "tar"},
+	{".zip", "zip"},
+	{".jar", "zip"},
+	{".gz", "gz"},
+}
+
+func archiveKind(name string) string {
+	lower := strings.ToLower(name)
+	for _, a := range archiveExtensions {
+		if strings.HasSuffix(lower, a.ext) && len(lower) > len(a.ext) {
+			return a.kind
+		}
+	}
+	return ""
+}
+
+// ExpandNestedArchives finds tar, zip and gzip files in the archive's tree,
+// extracts them to disk, and adds their contents to the tree as virtual
+// directories. Members inherit the license of the archive that contains them.
+// Archives that fail to extract or exceed the limits are left as opaque files.
+func ExpandNestedArchives(a *Archive, limits NestedArchiveLimits, hashThreads int) {
+	var dest = filepath.Join(a.parent, "nested")
+	expandArchivesIn(a.Tree, dest, a.Pkg.Slug(), limits, hashThreads, 1)
+}
+
+func expandArchivesIn(dir Directory, dest, slug string, limits NestedArchiveLimits, hashThreads, depth int) {
+	// Take a snapshot of the keys, since we'll be adding to the map
+	var names []string
+	for name := range dir.Contents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		switch node := dir.Contents[name].(type) {
+		case Directory:
+			expandArchivesIn(node, filepath.Join(dest, name), slug, limits, hashThreads, depth)
+		case File:
+			kind := archiveKind(name)
+			if kind == "" || depth > limits.MaxDepth {
+				continue
+			}
+			if _, found := dir.Contents[name+archiveMemberSuffix]; found {
+				continue // name collision, leave it alone
+			}
+
+			out := filepath.Join(dest, name+archiveMemberSuffix)
+			if err := extractArchive(node.LocalPath, kind, out, limits); err != nil {
+				log.Printf("[%s] Not expanding nested archive %#v: %s\n", slug, name, err)
+				if err := os.RemoveAll(out); err != nil {
+					panic(err)
+				}
+				continue
+			}
+
+			members, _ := constructTree(out, hashThreads)
+			members.Archive = true
+			members.WalkFiles(func(p string, f File) {
+				f.License = node.License
+				setFile(members, p, f)
+			})
+			expandArchivesIn(members, out, slug, limits, hashThreads, depth+1)
+			dir.Contents[name+archiveMemberSuffix] = members
+		}
+	}
+}
+
+// setFile replaces the file at the given path in the tree.
+func setFile(root Directory, p string, f File) {
+	var d = root
+	parts := strings.Split(p, "/")
+	for _, part := range parts[:len(parts)-1] {
+		d = d.Contents[part].(Directory)
+	}
+	d.Contents[parts[len(parts)-1]] = f
+}
+
+// extractArchive extracts the regular files and directories in an archive to
+// the `out` directory. Links, devices, etc. are skipped.
+func extractArchive(filename, kind, out string, limits NestedArchiveLimits) error {
+	if err := os.MkdirAll(out, 0755); err != nil {
+		return err
+	}
+	var budget = extractionBudget{size: limits.MaxSize, files: limits.MaxFiles}
+
+	if kind == "zip" {
+		return extractZip(filename, out, &budget)
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader
+	switch kind {
+	case "tar":
+		r = f
+	case "tar.gz", "gz":
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	case "tar.bz2":
+		r = bzip2.NewReader(f)
+	case "tar.xz":
+		r, err = xz.NewReader(f)
+		if err != nil {
+			return err
+		}
+	case "tar.zst":
+		zr, err := zstd.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		return fmt.Errorf("unknown archive type %q", kind)
+	}
+
+	if kind == "gz" {
+		// A single compressed file, e.g. a man page
+		name := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+		return budget.writeFile(filepath.Join(out, name), r, 0644)
+	}
+
+	ar := tar.NewReader(r)
+	for {
+		hdr, err := ar.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		local, ok := memberPath(out, hdr.Name)
+		if !ok {
+			continue
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(local, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := budget.writeFile(local, ar, os.FileMode(hdr.Mode)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func extractZip(filename, out string, budget *extractionBudget) error {
+	zr, err := zip.OpenReader(filename)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, zf := range zr.File {
+		local, ok := memberPath(out, zf.Name)
+		if !ok {
+			continue
+		}
+		if zf.FileInfo().IsDir() {
+			if err := os.MkdirAll(local, 0755); err != nil {
+				return err
+			}
+		} else if zf.Mode().IsRegular() {
+			r, err := zf.Open()
+			if err != nil {
+				return err
+			}
+			err = budget.writeFile(local, r, zf.Mode())
+			r.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// memberPath converts the name of an archive member into a path under `out`,
+// refusing names that would escape it.
+func memberPath(out, name string) (string, bool) {
+	clean := strings.TrimPrefix(path.Clean("/"+name), "/")
+	if clean == "" {
+		return "", false
+	}
+	return filepath.Join(out, filepath.FromSlash(clean)), true
+}
+
+// An extractionBudget tracks how much more data we're willing to extract from
+// an archive.
+type extractionBudget struct {
+	size  int64
+	files int
+}
+
+func (b *extractionBudget) writeFile(local string, r io.Reader, mode os.FileMode) error {
+	b.files--
+	if b.files < 0 {
+		return errArchiveTooLarge
+	}
+	if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+		return err
+	}
+	// Make sure we can read the file back, regardless of its recorded mode
+	f, err := os.OpenFile(local, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm()|0600)
+	if err != nil {
+		return err
+	}
+	n, err := io.CopyN(f, r, b.size+1)
+	b.size -= n
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if b.size < 0 {
+		return errArchiveTooLarge
+	} else if err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
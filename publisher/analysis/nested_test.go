@@ -0,0 +1,108 @@
+package analysis
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/btidor/src.codes/publisher/apt"
+)
+
+func writeTarGz(t *testing.T, filename string, members map[string]string) {
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	ar := tar.NewWriter(gz)
+	for name, contents := range members {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(contents))}
+		if err := ar.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ar.Write([]byte(contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ar.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExpandNestedArchives(t *testing.T) {
+	parent, err := os.MkdirTemp("", "sctest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(parent)
+	dir := filepath.Join(parent, "source")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	writeTarGz(t, filepath.Join(dir, "upstream.tar.gz"), map[string]string{
+		"upstream/README":  "Hello\n",
+		"../../escape.txt": "Gotcha\n",
+	})
+
+	zf, err := os.Create(filepath.Join(dir, "fixture.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(zf)
+	w, err := zw.Create("data/test.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("{}\n"))
+	zw.Close()
+	zf.Close()
+
+	tree, _ := constructTree(dir, 2)
+	var a = Archive{
+		Pkg:    &apt.Package{Name: "example"},
+		Dir:    dir,
+		Tree:   tree,
+		parent: parent,
+	}
+	ExpandNestedArchives(&a, DefaultNestedArchiveLimits, 2)
+
+	var paths = make(map[string]bool)
+	a.Tree.WalkFiles(func(p string, f File) {
+		paths[p] = true
+	})
+	for _, p := range []string{
+		"upstream.tar.gz",
+		"upstream.tar.gz!/upstream/README",
+		"upstream.tar.gz!/escape.txt",
+		"fixture.zip!/data/test.json",
+	} {
+		if !paths[p] {
+			t.Errorf("Missing %s, got %#v", p, paths)
+		}
+	}
+	if len(paths) != 5 {
+		t.Errorf("Wrong number of files: %#v", paths)
+	}
+	if !a.Tree.Contents["upstream.tar.gz!"].(Directory).Archive {
+		t.Errorf("Virtual directory not marked as archive")
+	}
+	if _, err := os.Stat(filepath.Join(parent, "escape.txt")); err == nil {
+		t.Errorf("Archive member escaped extraction directory")
+	}
+
+	// Exceeding the limits leaves the archive opaque
+	tree, _ = constructTree(dir, 2)
+	a.Tree = tree
+	ExpandNestedArchives(&a, NestedArchiveLimits{MaxDepth: 1, MaxSize: 3, MaxFiles: 10}, 2)
+	if _, found := a.Tree.Contents["upstream.tar.gz!"]; found {
+		t.Errorf("Archive over size limit was expanded")
+	}
+}
@@ -0,0 +1,184 @@
+package analysis
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// The outline index lets a client fetch the symbols defined in a single file
+// without downloading the package's entire ctags index. It's laid out so that
+// it can be read with HTTP range requests:
+//
+//	header    outlineMagic, then the table size as a big-endian uint32
+//	table     msgpack array of OutlineEntry, sorted by path
+//	blocks    one msgpack array of OutlineSymbol per file
+//
+// A client fetches the header and table (which are small, and can be cached),
+// binary-searches the table for the path it wants, then fetches the block at
+// the given offset.
+const outlineMagic = "srcoutl1"
+
+const outlineHeaderSize = len(outlineMagic) + 4
+
+// An OutlineEntry locates a file's block in the outline index. Offsets are
+// relative to the start of the index.
+type OutlineEntry struct {
+	//lint:ignore U1000 msgpack config options
+	_msgpack struct{} `msgpack:",as_array"`
+
+	Path   string
+	Offset uint64
+	Length uint32
+}
+
+// An OutlineSymbol is a single definition in a file's outline.
+type OutlineSymbol struct {
+	//lint:ignore U1000 msgpack config options
+	_msgpack struct{} `msgpack:",as_array"`
+
+	Name  string
+	Kind  string
+	Line  int
+	Scope string
+	Doc   string // doc comment, for hover cards
+}
+
+// ConstructOutlineIndex converts ctags output into an outline index, including
+// each symbol's doc comment. Within a file, symbols are sorted by line number.
+// The index is written to disk; call Remove on the result when done with it.
+func ConstructOutlineIndex(a Archive, ctags Artifact) Artifact {
+	in := ctags.Open()
+	var tags = ParseTags(in)
+	in.Close()
+	AttachDocs(a.Dir, tags)
+
+	var files = make(map[string][]OutlineSymbol)
+	for _, tag := range tags {
+		files[tag.Path] = append(files[tag.Path], OutlineSymbol{
+			Name:  tag.Name,
+			Kind:  tag.Kind,
+			Line:  tag.Line,
+			Scope: tag.Scope,
+			Doc:   tag.Doc,
+		})
+	}
+
+	var paths []string
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	// The blocks follow the table, whose size isn't known yet, so spool them
+	// to a separate file until it is.
+	blocks := createArtifact("outline-blocks")
+	defer os.Remove(blocks.Name())
+	defer blocks.Close()
+	bw := bufio.NewWriter(blocks)
+
+	var table []OutlineEntry
+	var offset uint64
+	for _, path := range paths {
+		symbols := files[path]
+		sort.SliceStable(symbols, func(i, j int) bool {
+			return symbols[i].Line < symbols[j].Line
+		})
+		block, err := msgpack.Marshal(symbols)
+		if err != nil {
+			panic(err)
+		}
+		if _, err := bw.Write(block); err != nil {
+			panic(err)
+		}
+		table = append(table, OutlineEntry{
+			Path:   path,
+			Offset: offset, // fixed up below, once the table size is known
+			Length: uint32(len(block)),
+		})
+		offset += uint64(len(block))
+	}
+	if err := bw.Flush(); err != nil {
+		panic(err)
+	}
+
+	// The table's size depends on the offsets it contains, so encode it once to
+	// measure it, then again with the final offsets. Since msgpack encodes
+	// larger integers in more bytes, repeat until the size stabilizes.
+	var encoded []byte
+	var base uint64
+	for {
+		for i := range table {
+			table[i].Offset += base
+		}
+		var err error
+		encoded, err = msgpack.Marshal(table)
+		if err != nil {
+			panic(err)
+		}
+		next := uint64(outlineHeaderSize + len(encoded))
+		if next == base {
+			break
+		}
+		for i := range table {
+			table[i].Offset -= base
+		}
+		base = next
+	}
+
+	f := createArtifact("outline")
+	out := bufio.NewWriter(f)
+	out.WriteString(outlineMagic)
+	if err := binary.Write(out, binary.BigEndian, uint32(len(encoded))); err != nil {
+		panic(err)
+	}
+	out.Write(encoded)
+	if _, err := blocks.Seek(0, io.SeekStart); err != nil {
+		panic(err)
+	}
+	if _, err := io.Copy(out, blocks); err != nil {
+		panic(err)
+	}
+	if err := out.Flush(); err != nil {
+		panic(err)
+	}
+	return closeArtifact(f)
+}
+
+// LookupOutline reads the outline for a single file from an outline index, the
+// same way a client would. It returns nil if the file has no symbols.
+func LookupOutline(index []byte, path string) ([]OutlineSymbol, error) {
+	if len(index) < outlineHeaderSize || string(index[:len(outlineMagic)]) != outlineMagic {
+		return nil, errors.New("not an outline index")
+	}
+	size := int(binary.BigEndian.Uint32(index[len(outlineMagic):outlineHeaderSize]))
+	if outlineHeaderSize+size > len(index) {
+		return nil, errors.New("outline index is truncated")
+	}
+
+	var table []OutlineEntry
+	if err := msgpack.Unmarshal(index[outlineHeaderSize:outlineHeaderSize+size], &table); err != nil {
+		return nil, err
+	}
+	i := sort.Search(len(table), func(i int) bool { return table[i].Path >= path })
+	if i == len(table) || table[i].Path != path {
+		return nil, nil
+	}
+
+	entry := table[i]
+	end := entry.Offset + uint64(entry.Length)
+	if end > uint64(len(index)) {
+		return nil, fmt.Errorf("outline block for %q is out of range", path)
+	}
+	var symbols []OutlineSymbol
+	if err := msgpack.Unmarshal(index[entry.Offset:end], &symbols); err != nil {
+		return nil, err
+	}
+	return symbols, nil
+}
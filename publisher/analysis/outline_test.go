@@ -0,0 +1,57 @@
+package analysis
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const sampleCtags = "!_TAG_FILE_FORMAT\t2\t/extended format/\n" +
+	"main\tsrc/main.c\t40;\"\tf\n" +
+	"Point\tsrc/geom.h\t3;\"\ts\n" +
+	"x\tsrc/geom.h\t4;\"\tm\tstruct:Point\n" +
+	"usage\tsrc/main.c\t12;\"\tf\tfile:\n"
+
+func TestOutlineIndex(t *testing.T) {
+	dir := t.TempDir()
+	ctags := filepath.Join(dir, "tags")
+	if err := os.WriteFile(ctags, []byte(sampleCtags), 0644); err != nil {
+		t.Fatal(err)
+	}
+	artifact := ConstructOutlineIndex(Archive{Dir: dir}, newArtifact(ctags))
+	defer artifact.Remove()
+	index, err := os.ReadFile(artifact.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	symbols, err := LookupOutline(index, "src/main.c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []OutlineSymbol{
+		{Name: "usage", Kind: "f", Line: 12},
+		{Name: "main", Kind: "f", Line: 40},
+	}
+	if !reflect.DeepEqual(symbols, expected) {
+		t.Errorf("Unexpected outline for main.c: %#v", symbols)
+	}
+
+	symbols, err = LookupOutline(index, "src/geom.h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []OutlineSymbol{
+		{Name: "Point", Kind: "s", Line: 3},
+		{Name: "x", Kind: "m", Line: 4, Scope: "Point"},
+	}
+	if !reflect.DeepEqual(symbols, expected) {
+		t.Errorf("Unexpected outline for geom.h: %#v", symbols)
+	}
+
+	symbols, err = LookupOutline(index, "src/missing.c")
+	if err != nil || symbols != nil {
+		t.Errorf("Expected no outline for missing file, got %#v, %v", symbols, err)
+	}
+}
@@ -0,0 +1,239 @@
+package analysis
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Limits bounds the resources an external tool may consume. Zero values mean
+// "no limit".
+type Limits struct {
+	Timeout      time.Duration // wall-clock time
+	CPUTime      time.Duration // RLIMIT_CPU
+	AddressSpace uint64        // RLIMIT_AS, in bytes
+	FileSize     uint64        // RLIMIT_FSIZE, in bytes
+	Output       int           // maximum bytes of stdout to capture
+}
+
+// Per-tool limits. These are generous: they're meant to catch pathological
+// packages, not to constrain normal ones.
+var (
+	dpkgSourceLimits = Limits{
+		Timeout:  30 * time.Minute,
+		CPUTime:  20 * time.Minute,
+		FileSize: 8 << 30,
+	}
+	ctagsLimits = Limits{
+		Timeout:      20 * time.Minute,
+		CPUTime:      15 * time.Minute,
+		AddressSpace: 8 << 30,
+		FileSize:     1 << 30,
+		Output:       1 << 30,
+	}
+	cxxfiltLimits = Limits{
+		Timeout:      5 * time.Minute,
+		CPUTime:      5 * time.Minute,
+		AddressSpace: 2 << 30,
+		FileSize:     1 << 20,
+		Output:       256 << 20,
+	}
+)
+
+// Only this much of a tool's stderr is kept for the failure report.
+const stderrLimit = 64 * 1024
+
+// Reasons for ToolError.Reason.
+const (
+	ToolTimeout      = "timeout"
+	ToolOutputLimit  = "output limit exceeded"
+	ToolSignaled     = "killed by signal"
+	ToolExited       = "non-zero exit status"
+	ToolFailedToRun  = "failed to start"
+	ToolResourceKill = "resource limit exceeded"
+)
+
+// A ToolError is a structured report of a failed tool invocation. It
+// serializes to JSON for logging.
+type ToolError struct {
+	Tool     string        `json:"tool"`
+	Args     []string      `json:"args"`
+	Dir      string        `json:"dir,omitempty"`
+	Reason   string        `json:"reason"`
+	ExitCode int           `json:"exit_code,omitempty"`
+	Signal   string        `json:"signal,omitempty"`
+	Stderr   string        `json:"stderr,omitempty"`
+	Elapsed  time.Duration `json:"elapsed"`
+	Err      string        `json:"error,omitempty"`
+}
+
+func (e *ToolError) Error() string {
+	var detail string
+	switch {
+	case e.Signal != "":
+		detail = ": " + e.Signal
+	case e.ExitCode != 0:
+		detail = fmt.Sprintf(": exit status %d", e.ExitCode)
+	case e.Err != "":
+		detail = ": " + e.Err
+	}
+	return fmt.Sprintf("%s: %s%s\nstderr: %s", e.Tool, e.Reason, detail, e.Stderr)
+}
+
+// Log prints the report as a single line of JSON.
+func (e *ToolError) Log(slug string) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		panic(err)
+	}
+	log.Printf("[%s] Tool failure: %s\n", slug, data)
+}
+
+// runTool runs an external tool under the given limits and returns its stdout.
+// Resource limits are applied with prlimit(1), if it's installed; timeouts and
+// output limits are always enforced. On failure, the error is a *ToolError.
+func runTool(limits Limits, dir string, stdin io.Reader, name string, args ...string) ([]byte, error) {
+	var stdout bytes.Buffer
+	if err := runToolTo(limits, dir, stdin, &stdout, name, args...); err != nil {
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+// runToolTo is like runTool, but writes the tool's stdout to `w` as it runs,
+// for tools whose output is too big to hold in memory. If the tool fails, `w`
+// may have been partially written.
+func runToolTo(limits Limits, dir string, stdin io.Reader, w io.Writer, name string, args ...string) error {
+	var report = &ToolError{Tool: name, Args: args, Dir: dir}
+	var start = time.Now()
+
+	ctx := context.Background()
+	var cancel context.CancelFunc = func() {}
+	if limits.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, limits.Timeout)
+	}
+	defer cancel()
+	ctx, kill := context.WithCancel(ctx)
+	defer kill()
+
+	var argv = append([]string{name}, args...)
+	if prlimit != "" {
+		argv = append(limits.prlimitArgs(), argv...)
+	}
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Dir = dir
+	cmd.Stdin = stdin
+	cmd.WaitDelay = 10 * time.Second
+
+	var stderrBuf bytes.Buffer
+	var stdout = &limitedWriter{w: w, limit: limits.Output, exceeded: kill}
+	var stderr = &limitedWriter{w: &stderrBuf, limit: stderrLimit}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err := cmd.Run()
+	report.Elapsed = time.Since(start)
+	stderr.mu.Lock()
+	report.Stderr = stderrBuf.String()
+	stderr.mu.Unlock()
+	if err == nil {
+		return nil
+	}
+
+	var exitErr *exec.ExitError
+	switch {
+	case stdout.overflowed:
+		report.Reason = ToolOutputLimit
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		report.Reason = ToolTimeout
+	case errors.As(err, &exitErr):
+		status, ok := exitErr.Sys().(syscall.WaitStatus)
+		if ok && status.Signaled() {
+			report.Signal = status.Signal().String()
+			switch status.Signal() {
+			case syscall.SIGXCPU, syscall.SIGXFSZ:
+				report.Reason = ToolResourceKill
+			default:
+				report.Reason = ToolSignaled
+			}
+		} else {
+			report.Reason = ToolExited
+			report.ExitCode = exitErr.ExitCode()
+		}
+	default:
+		report.Reason = ToolFailedToRun
+		report.Err = err.Error()
+	}
+	return report
+}
+
+// prlimit is the path to the prlimit binary, or empty if it's not installed.
+var prlimit = func() string {
+	path, err := exec.LookPath("prlimit")
+	if err != nil {
+		log.Printf("WARNING: prlimit not found, tools will run without rlimits\n")
+		return ""
+	}
+	return path
+}()
+
+func (l Limits) prlimitArgs() []string {
+	var args = []string{prlimit}
+	if l.CPUTime > 0 {
+		// Leave some headroom between the soft and hard limits so the process
+		// gets SIGXCPU (and we can report it) before it's SIGKILLed.
+		soft := int(l.CPUTime.Seconds())
+		args = append(args, fmt.Sprintf("--cpu=%d:%d", soft, soft+5))
+	}
+	if l.AddressSpace > 0 {
+		args = append(args, "--as="+strconv.FormatUint(l.AddressSpace, 10))
+	}
+	if l.FileSize > 0 {
+		args = append(args, "--fsize="+strconv.FormatUint(l.FileSize, 10))
+	}
+	return append(args, "--")
+}
+
+// A limitedWriter passes up to `limit` bytes of output through to `w`. Once the
+// limit is reached, further output is discarded and `exceeded` (if set) is
+// called to stop the process.
+//
+// Note: `w` is deliberately not embedded, since io.Copy would then use its
+// ReadFrom method and bypass the limit.
+type limitedWriter struct {
+	w          io.Writer
+	written    int
+	limit      int
+	exceeded   func()
+	overflowed bool
+	mu         sync.Mutex
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.limit > 0 && l.written+len(p) > l.limit {
+		n, err := l.w.Write(p[:l.limit-l.written])
+		l.written += n
+		if err != nil {
+			return n, err
+		}
+		if !l.overflowed && l.exceeded != nil {
+			l.exceeded()
+		}
+		l.overflowed = true
+		return len(p), nil
+	}
+	n, err := l.w.Write(p)
+	l.written += n
+	return n, err
+}
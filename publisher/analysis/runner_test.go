@@ -0,0 +1,42 @@
+package analysis
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunToolSuccess(t *testing.T) {
+	out, err := runTool(Limits{Timeout: 10 * time.Second}, "", strings.NewReader("hello"), "cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "hello" {
+		t.Errorf("Wrong output: %#v", string(out))
+	}
+}
+
+func TestRunToolFailures(t *testing.T) {
+	var cases = []struct {
+		limits Limits
+		argv   []string
+		reason string
+	}{
+		{Limits{Timeout: 100 * time.Millisecond}, []string{"sleep", "10"}, ToolTimeout},
+		{Limits{Timeout: 10 * time.Second, Output: 1024}, []string{"yes"}, ToolOutputLimit},
+		{Limits{Timeout: 10 * time.Second}, []string{"false"}, ToolExited},
+	}
+	for _, c := range cases {
+		out, err := runTool(c.limits, "", nil, c.argv[0], c.argv[1:]...)
+		if err == nil {
+			t.Errorf("Expected %s to fail", c.argv[0])
+			continue
+		}
+		if report := err.(*ToolError); report.Reason != c.reason {
+			t.Errorf("Wrong failure reason for %s: %#v", c.argv[0], report)
+		}
+		if out != nil {
+			t.Errorf("Expected no output from %s, got %d bytes", c.argv[0], len(out))
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package analysis
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+)
+
+// Lines longer than this are counted as code without being examined, since
+// they're usually minified or generated.
+const maxCountedLineLength = 64 * 1024
+
+// A language describes how to recognize comments in a programming language.
+type language struct {
+	name       string
+	line       []string // line comment prefixes
+	blockStart string
+	blockEnd   string
+}
+
+var (
+	langC          = &language{"C", []string{"//"}, "/*", "*/"}
+	langCPlusPlus  = &language{"C++", []string{"//"}, "/*", "*/"}
+	langCSharp     = &language{"C#", []string{"//"}, "/*", "*/"}
+	langCSS        = &language{"CSS", nil, "/*", "*/"}
+	langCMake      = &language{"CMake", []string{"#"}, "", ""}
+	langD          = &language{"D", []string{"//"}, "/*", "*/"}
+	langElisp      = &language{"Emacs Lisp", []string{";"}, "", ""}
+	langGo         = &language{"Go", []string{"//"}, "/*", "*/"}
+	langHaskell    = &language{"Haskell", []string{"--"}, "{-", "-}"}
+	langHTML       = &language{"HTML", nil, "<!--", "-->"}
+	langJava       = &language{"Java", []string{"//"}, "/*", "*/"}
+	langJavaScript = &language{"JavaScript", []string{"//"}, "/*", "*/"}
+	langKotlin     = &language{"Kotlin", []string{"//"}, "/*", "*/"}
+	langLua        = &language{"Lua", []string{"--"}, "--[[", "]]"}
+	langM4         = &language{"M4", []string{"dnl", "#"}, "", ""}
+	langMake       = &language{"Makefile", []string{"#"}, "", ""}
+	langObjC       = &language{"Objective-C", []string{"//"}, "/*", "*/"}
+	langOCaml      = &language{"OCaml", nil, "(*", "*)"}
+	langPerl       = &language{"Perl", []string{"#"}, "", ""}
+	langPHP        = &language{"PHP", []string{"//", "#"}, "/*", "*/"}
+	langPython     = &language{"Python", []string{"#"}, "", ""}
+	langRuby       = &language{"Ruby", []string{"#"}, "", ""}
+	langRust       = &language{"Rust", []string{"//"}, "/*", "*/"}
+	langScala      = &language{"Scala", []string{"//"}, "/*", "*/"}
+	langShell      = &language{"Shell", []string{"#"}, "", ""}
+	langSQL        = &language{"SQL", []string{"--"}, "/*", "*/"}
+	langSwift      = &language{"Swift", []string{"//"}, "/*", "*/"}
+	langTcl        = &language{"Tcl", []string{"#"}, "", ""}
+	langTypeScript = &language{"TypeScript", []string{"//"}, "/*", "*/"}
+	langVala       = &language{"Vala", []string{"//"}, "/*", "*/"}
+	langXML        = &language{"XML", nil, "<!--", "-->"}
+	langYAML       = &language{"YAML", []string{"#"}, "", ""}
+)
+
+// Languages by (lowercased) file extension.
+var languageExtensions = map[string]*language{
+	".c": langC, ".h": langC,
+	".cc": langCPlusPlus, ".cpp": langCPlusPlus, ".cxx": langCPlusPlus,
+	".hh": langCPlusPlus, ".hpp": langCPlusPlus, ".hxx": langCPlusPlus,
+	".cs":    langCSharp,
+	".css":   langCSS,
+	".cmake": langCMake,
+	".d":     langD,
+	".el":    langElisp,
+	".go":    langGo,
+	".hs":    langHaskell,
+	".html":  langHTML, ".htm": langHTML,
+	".java": langJava,
+	".js":   langJavaScript, ".mjs": langJavaScript, ".cjs": langJavaScript,
+	".kt":  langKotlin,
+	".lua": langLua,
+	".m4":  langM4, ".ac": langM4,
+	".mk": langMake, ".am": langMake,
+	".m":  langObjC,
+	".ml": langOCaml, ".mli": langOCaml,
+	".pl": langPerl, ".pm": langPerl, ".t": langPerl,
+	".php":   langPHP,
+	".py":    langPython,
+	".rb":    langRuby,
+	".rs":    langRust,
+	".scala": langScala,
+	".sh":    langShell, ".bash": langShell,
+	".sql":   langSQL,
+	".swift": langSwift,
+	".tcl":   langTcl,
+	".ts":    langTypeScript, ".tsx": langTypeScript,
+	".vala": langVala,
+	".xml":  langXML,
+	".yaml": langYAML, ".yml": langYAML,
+}
+
+// Languages by exact filename, for files without a useful extension.
+var languageFilenames = map[string]*language{
+	"CMakeLists.txt": langCMake,
+	"GNUmakefile":    langMake,
+	"Makefile":       langMake,
+	"makefile":       langMake,
+	"configure.ac":   langM4,
+	"Rakefile":       langRuby,
+}
+
+func detectLanguage(path string) *language {
+	base := filepath.Base(path)
+	if lang, ok := languageFilenames[base]; ok {
+		return lang
+	}
+	return languageExtensions[strings.ToLower(filepath.Ext(base))]
+}
+
+// LineCounts is the number of code, comment and blank lines in a file or a set
+// of files.
+type LineCounts struct {
+	Files   int64 `json:"files"`
+	Code    int64 `json:"code"`
+	Comment int64 `json:"comment"`
+	Blank   int64 `json:"blank"`
+}
+
+func (c *LineCounts) Add(o LineCounts) {
+	c.Files += o.Files
+	c.Code += o.Code
+	c.Comment += o.Comment
+	c.Blank += o.Blank
+}
+
+// A lineCounter counts the code, comment and blank lines in a file, cloc-style.
+// Like encodingSniffer, it's an io.Writer so that it can be fed the file's
+// contents while they're being hashed.
+//
+// Lines that contain any code are counted as code, even if they also contain
+// a comment. Comment markers inside string literals aren't recognized.
+type lineCounter struct {
+	lang    *language
+	counts  LineCounts
+	partial []byte // incomplete line at the end of the last write
+	long    bool   // the current line is too long to examine
+	inBlock bool   // inside a block comment
+}
+
+func newLineCounter(path string) *lineCounter {
+	lang := detectLanguage(path)
+	if lang == nil {
+		return nil
+	}
+	return &lineCounter{lang: lang, counts: LineCounts{Files: 1}}
+}
+
+func (c *lineCounter) Write(p []byte) (int, error) {
+	var n = len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			c.buffer(p)
+			break
+		}
+		c.buffer(p[:i])
+		c.finishLine()
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+func (c *lineCounter) buffer(p []byte) {
+	if c.long {
+		return
+	} else if len(c.partial)+len(p) > maxCountedLineLength {
+		c.long = true
+		c.partial = c.partial[:0]
+		return
+	}
+	c.partial = append(c.partial, p...)
+}
+
+func (c *lineCounter) finishLine() {
+	if c.long {
+		c.counts.Code++
+	} else {
+		c.classify(bytes.TrimSpace(c.partial))
+	}
+	c.partial = c.partial[:0]
+	c.long = false
+}
+
+func (c *lineCounter) classify(line []byte) {
+	if len(line) == 0 {
+		c.counts.Blank++
+		return
+	}
+	var code, comment bool
+	var start, end = []byte(c.lang.blockStart), []byte(c.lang.blockEnd)
+scan:
+	for i := 0; i < len(line); {
+		if c.inBlock {
+			comment = true
+			j := bytes.Index(line[i:], end)
+			if j < 0 {
+				break
+			}
+			i += j + len(end)
+			c.inBlock = false
+			continue
+		}
+		rest := line[i:]
+		if rest[0] == ' ' || rest[0] == '\t' {
+			i++
+			continue
+		}
+		if len(start) > 0 && bytes.HasPrefix(rest, start) {
+			comment = true
+			c.inBlock = true
+			i += len(start)
+			continue
+		}
+		for _, prefix := range c.lang.line {
+			if len(rest) >= len(prefix) && string(rest[:len(prefix)]) == prefix {
+				comment = true
+				break scan
+			}
+		}
+		code = true
+		i++
+	}
+	if code {
+		c.counts.Code++
+	} else if comment {
+		c.counts.Comment++
+	} else {
+		c.counts.Blank++
+	}
+}
+
+// Counts returns the totals once the entire file has been written.
+func (c *lineCounter) Counts() LineCounts {
+	if len(c.partial) > 0 || c.long {
+		c.finishLine() // no trailing newline
+	}
+	return c.counts
+}
+
+// SLOCStats summarizes the lines of code in a package, by language.
+type SLOCStats struct {
+	Languages map[string]LineCounts `json:"languages"`
+	Total     LineCounts            `json:"total"`
+}
+
+// ConstructSLOCIndex totals up the line counts computed for each file while the
+// tree was walked.
+func ConstructSLOCIndex(a Archive) SLOCStats {
+	var stats = SLOCStats{Languages: make(map[string]LineCounts)}
+	a.Tree.WalkFiles(func(path string, f File) {
+		if f.Language == "" {
+			return
+		}
+		counts := stats.Languages[f.Language]
+		counts.Add(f.Lines)
+		stats.Languages[f.Language] = counts
+		stats.Total.Add(f.Lines)
+	})
+	return stats
+}
@@ -0,0 +1,51 @@
+package analysis
+
+import (
+	"testing"
+)
+
+const sampleC = `/*
+ * Copyright (c) 2024 Example
+ */
+
+#include <stdio.h>
+
+// Entry point
+int main(void) {
+	printf("hi\n"); /* trailing comment */
+	/* a */ return 0; /* b */
+
+	return 1;
+}`
+
+func TestLineCounter(t *testing.T) {
+	lc := newLineCounter("src/main.c")
+	if lc == nil || lc.lang != langC {
+		t.Fatalf("Expected C, got %#v", lc)
+	}
+	// Write in small chunks to exercise lines split across writes
+	for i := 0; i < len(sampleC); i += 7 {
+		if _, err := lc.Write([]byte(sampleC[i:min(i+7, len(sampleC))])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := LineCounts{Files: 1, Code: 6, Comment: 4, Blank: 3}
+	if counts := lc.Counts(); counts != expected {
+		t.Errorf("Wrong line counts: got %#v, want %#v", counts, expected)
+	}
+}
+
+func TestDetectLanguage(t *testing.T) {
+	for path, expected := range map[string]*language{
+		"a/Makefile":      langMake,
+		"setup.py":        langPython,
+		"lib/Foo.PM":      langPerl,
+		"README":          nil,
+		"CMakeLists.txt":  langCMake,
+		"notes/cmake.txt": nil,
+	} {
+		if lang := detectLanguage(path); lang != expected {
+			t.Errorf("Wrong language for %s: %#v", path, lang)
+		}
+	}
+}
@@ -4,9 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"fmt"
-	"io"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
 	"regexp"
@@ -16,23 +14,30 @@ import (
 // for examples
 var symbolExtractor = regexp.MustCompile(`^ ([^@]+::)?([A-Za-z0-9_~]+)(<[^@]+>)?@.*$`)
 
-func ConstructSymbolsIndex(a Archive, ctags []byte) []byte {
+func ConstructSymbolsIndex(a Archive, ctags Artifact) []byte {
 	pattern := path.Join(a.Dir, "debian/*symbols")
 	matches, err := filepath.Glob(pattern)
 	if err != nil {
 		panic(err)
 	}
 
-	tagIndex := parseCtags(ctags)
+	in := ctags.Open()
+	tagIndex := parseCtags(in)
+	in.Close()
 
 	var result []byte
 	for _, filename := range matches {
+		demangled, err := processSymbols(filename)
+		if err != nil {
+			// Skip this symbols file, but keep going with the others
+			err.(*ToolError).Log(a.Pkg.Slug())
+			continue
+		}
+
 		header := fmt.Sprintf("### %s %s\n", a.Pkg.Name, filepath.Base(filename))
 		result = append(result, []byte(header)...)
 
-		sym := bytes.NewReader(
-			processSymbols(filename),
-		)
+		sym := bytes.NewReader(demangled)
 		sc := bufio.NewScanner(sym)
 		for sc.Scan() {
 			line := sc.Text()
@@ -53,30 +58,14 @@ func ConstructSymbolsIndex(a Archive, ctags []byte) []byte {
 	return result
 }
 
-func processSymbols(filename string) []byte {
-	// Exclude params for now, since they make it more difficult to extract the
-	// function name and match it to the ctags index.
-	cmd := exec.Command("c++filt", "--no-params")
-	stdin, err := cmd.StdinPipe()
+func processSymbols(filename string) ([]byte, error) {
+	f, err := os.Open(filename)
 	if err != nil {
 		panic(err)
 	}
+	defer f.Close()
 
-	go func() {
-		defer stdin.Close()
-
-		f, err := os.Open(filename)
-		if err != nil {
-			panic(err)
-		}
-		defer f.Close()
-
-		io.Copy(stdin, f)
-	}()
-
-	out, err := cmd.Output()
-	if err != nil {
-		panic(err)
-	}
-	return out
+	// Exclude params for now, since they make it more difficult to extract the
+	// function name and match it to the ctags index.
+	return runTool(cxxfiltLimits, "", f, "c++filt", "--no-params")
 }
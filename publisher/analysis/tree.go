@@ -1,6 +1,8 @@
 package analysis
 
 import (
+	"bufio"
+	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
@@ -9,17 +11,33 @@ import (
 	"io/fs"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
+	"time"
 )
 
+// TreeSchemaVersion identifies the format of the JSON tree uploaded for each
+// package. Bump it whenever fields are added or their meaning changes, so that
+// clients can detect which format they've received.
+const TreeSchemaVersion = 4
+
+// Symbolic links are resolved by walking the tree. This limit prevents infinite
+// loops when links point to each other (it matches Linux's MAXSYMLINKS).
+const maxLinkDepth = 40
+
 // An INode represents anything that can be contained in a directory: a file,
 // another directory or a symbolic link.
 type INode interface{ isAnINode() }
 
 type Directory struct {
 	Contents map[string]INode
+
+	// Archive is set on virtual directories that hold the contents of a
+	// nested archive (see ExpandNestedArchives).
+	Archive bool
 }
 
 func (d Directory) isAnINode() {}
@@ -28,7 +46,20 @@ func (d Directory) isAnINode() {}
 // its contents. Nodes are added in sorted order by name.
 func (d Directory) Files() []File {
 	var files []File
+	d.WalkFiles(func(_ string, f File) {
+		files = append(files, f)
+	})
+	return files
+}
+
+// WalkFiles recursively enumerates the directory and calls fn for each file,
+// along with its path relative to the directory. Nodes are visited in sorted
+// order by name.
+func (d Directory) WalkFiles(fn func(path string, f File)) {
+	d.walkFiles("", fn)
+}
 
+func (d Directory) walkFiles(prefix string, fn func(path string, f File)) {
 	// Enumerate files in sorted order
 	var keys []string
 	for name := range d.Contents {
@@ -39,51 +70,172 @@ func (d Directory) Files() []File {
 	for _, k := range keys {
 		switch node := d.Contents[k].(type) {
 		case Directory:
-			files = append(files, node.Files()...)
+			node.walkFiles(prefix+k+"/", fn)
 		case File:
-			files = append(files, node)
+			fn(prefix+k, node)
 		case SymbolicLink:
 		default:
 			err := fmt.Errorf("inode of unknown type: %#v", node)
 			panic(err)
 		}
 	}
-	return files
+}
+
+// MarshalTree serializes the root of a tree. It's the same as MarshalJSON,
+// except that the root object is tagged with the schema version.
+func MarshalTree(root Directory) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := WriteTree(&buf, root); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteTree serializes the root of a tree to the given writer, in the same
+// format as MarshalTree. Directories are written out one entry at a time, so
+// the serialized tree is never held in memory all at once, which matters for
+// very large packages.
+func WriteTree(w io.Writer, root Directory) error {
+	var bw = bufio.NewWriter(w)
+	fmt.Fprintf(bw, "{\n  \"schema\": %d,\n  \"type\": \"directory\",\n  \"contents\": ", TreeSchemaVersion)
+	if err := writeContents(bw, root.Contents, 1); err != nil {
+		return err
+	}
+	bw.WriteString("\n}")
+	return bw.Flush()
+}
+
+// writeContents writes a directory's contents as an indented JSON object,
+// matching the output of json.MarshalIndent with two-space indentation.
+func writeContents(bw *bufio.Writer, contents map[string]INode, depth int) error {
+	if contents == nil {
+		bw.WriteString("null")
+		return nil
+	} else if len(contents) == 0 {
+		bw.WriteString("{}")
+		return nil
+	}
+
+	var names []string
+	for name := range contents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var indent = strings.Repeat("  ", depth)
+	bw.WriteString("{")
+	for i, name := range names {
+		if i > 0 {
+			bw.WriteString(",")
+		}
+		key, err := json.Marshal(name)
+		if err != nil {
+			return err
+		}
+		bw.WriteString("\n" + indent + "  ")
+		bw.Write(key)
+		bw.WriteString(": ")
+		if err := writeINode(bw, contents[name], depth+1); err != nil {
+			return err
+		}
+	}
+	bw.WriteString("\n" + indent + "}")
+	return nil
+}
+
+func writeINode(bw *bufio.Writer, node INode, depth int) error {
+	var indent = strings.Repeat("  ", depth)
+	if dir, ok := node.(Directory); ok {
+		bw.WriteString("{\n" + indent + "  \"type\": \"directory\",\n" + indent + "  \"contents\": ")
+		if err := writeContents(bw, dir.Contents, depth+1); err != nil {
+			return err
+		}
+		if dir.Archive {
+			bw.WriteString(",\n" + indent + "  \"archive\": true")
+		}
+		bw.WriteString("\n" + indent + "}")
+		return nil
+	}
+
+	// Files and symlinks are small, so marshal them in one go
+	data, err := json.Marshal(node)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, data, indent, "  "); err != nil {
+		return err
+	}
+	_, err = buf.WriteTo(bw)
+	return err
 }
 
 func (d Directory) MarshalJSON() ([]byte, error) {
 	return json.Marshal(&struct {
 		Type     string           `json:"type"`
 		Contents map[string]INode `json:"contents"`
+		Archive  bool             `json:"archive,omitempty"`
 	}{
 		Type:     "directory",
 		Contents: d.Contents,
+		Archive:  d.Archive,
 	})
 }
 
 type File struct {
 	Size      int64
 	SHA256    [32]byte
+	Mode      fs.FileMode // permission bits only
+	Encoding  string      // character encoding, see EncodingUTF8 etc.
+	License   string      // from debian/copyright, if known
 	LocalPath string
+
+	Language string     // programming language, if recognized
+	Lines    LineCounts // if Language is set
 }
 
 func (f File) isAnINode() {}
 
 func (f File) MarshalJSON() ([]byte, error) {
 	return json.Marshal(&struct {
-		Type   string `json:"type"`
-		Size   int64  `json:"size"`
-		SHA256 string `json:"sha256"`
+		Type       string `json:"type"`
+		Size       int64  `json:"size"`
+		SHA256     string `json:"sha256"`
+		Mode       string `json:"mode,omitempty"`
+		Executable bool   `json:"executable,omitempty"`
+		Encoding   string `json:"encoding,omitempty"`
+		License    string `json:"license,omitempty"`
 	}{
-		Type:   "file",
-		Size:   f.Size,
-		SHA256: hex.EncodeToString(f.SHA256[:]),
+		Type:       "file",
+		Size:       f.Size,
+		SHA256:     hex.EncodeToString(f.SHA256[:]),
+		Mode:       f.formatMode(),
+		Executable: f.Mode&0111 != 0,
+		Encoding:   f.Encoding,
+		License:    f.License,
 	})
 }
 
+func (f File) formatMode() string {
+	if f.Mode == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%04o", f.Mode.Perm())
+}
+
+// Resolution statuses for SymbolicLink.Status.
+const (
+	LinkResolved = ""         // target is a file or directory in the tree
+	LinkDangling = "dangling" // target does not exist (or is a loop)
+	LinkExternal = "external" // target is outside of the archive
+)
+
 type SymbolicLink struct {
-	SymlinkTo string
+	SymlinkTo string // raw destination, as stored on disk
 	IsDir     bool
+
+	Target string // canonical path of the destination, relative to the root
+	Status string
 }
 
 func (s SymbolicLink) isAnINode() {}
@@ -93,20 +245,49 @@ func (s SymbolicLink) MarshalJSON() ([]byte, error) {
 		Type      string `json:"type"`
 		SymlinkTo string `json:"symlink_to"`
 		IsDir     bool   `json:"is_directory"`
+		Target    string `json:"target,omitempty"`
+		Status    string `json:"status,omitempty"`
 	}{
 		Type:      "symlink",
 		SymlinkTo: s.SymlinkTo,
 		IsDir:     s.IsDir,
+		Target:    s.Target,
+		Status:    s.Status,
 	})
 }
 
+// TreeStats records what happened while walking and hashing a package.
+type TreeStats struct {
+	Files   int           // number of regular files hashed
+	Bytes   int64         // total size of regular files
+	Skipped []SkippedFile // special files omitted from the tree
+	Elapsed time.Duration
+}
+
+// A SkippedFile is a special file (pipe, socket, device, etc.) which can't be
+// represented in the tree.
+type SkippedFile struct {
+	Path   string // relative to the root
+	Reason string
+}
+
+// A pendingFile is a regular file that's waiting to be hashed. Once hashing is
+// complete, it's inserted into its parent directory.
+type pendingFile struct {
+	parent Directory
+	name   string
+	file   File
+}
+
 // constructTree walks a directory and produces a Directory object (with linked
 // Files, Directories and SymbolicLinks) that represents the state of the
-// filesystem.
+// filesystem. Files are hashed in parallel by the given number of workers.
 //
 // Note: this function computes the hash of every file it encounters which may
 // cause churn if the filesystem is on a hard disk.
-func constructTree(dir string) Directory {
+func constructTree(dir string, workers int) (Directory, TreeStats) {
+	var start = time.Now()
+	var stats TreeStats
 	var root = Directory{
 		Contents: make(map[string]INode),
 	}
@@ -116,6 +297,26 @@ func constructTree(dir string) Directory {
 	var parents = make(map[string]*Directory)
 	parents[dir] = &root
 
+	// Start hashing workers
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var hashErr error // protected by `mu`
+	jobs := make(chan *pendingFile)
+	for w := 0; w < max(workers, 1); w++ {
+		wg.Add(1)
+		go func(w int, jobs <-chan *pendingFile, wg *sync.WaitGroup) {
+			defer wg.Done()
+			for p := range jobs {
+				if err := hashFile(&p.file); err != nil {
+					mu.Lock()
+					hashErr = err
+					mu.Unlock()
+				}
+			}
+		}(w, jobs, &wg)
+	}
+
+	var pending []*pendingFile
 	err := filepath.Walk(dir, func(path string, info fs.FileInfo, err error) error {
 		if err != nil {
 			return err
@@ -128,20 +329,20 @@ func constructTree(dir string) Directory {
 
 		var node INode
 		var parentdir = strings.TrimSuffix(filepath.Dir(path), "/")
+		parent, found := parents[parentdir]
+		if !found {
+			return fmt.Errorf("parent not found: %s", parentdir)
+		}
+
 		if info.Mode().Type()&fs.ModeSymlink != 0 {
 			dst, err := os.Readlink(path)
 			if err != nil {
 				return err
 			}
-			// We ignore errors here, since some packages contain invalid links.
-			// We'll treat these as file symlinks, not that it matters much.
-			var isDir bool
-			if dstInfo, err := os.Stat(path); err == nil {
-				isDir = dstInfo.IsDir()
-			}
+			// The destination is resolved once the whole tree has been
+			// walked, see resolveLinks.
 			node = SymbolicLink{
-				SymlinkTo: strings.TrimPrefix(dst, dir),
-				IsDir:     isDir,
+				SymlinkTo: dst,
 			}
 		} else if info.IsDir() {
 			var obj = Directory{
@@ -150,44 +351,200 @@ func constructTree(dir string) Directory {
 			parents[path] = &obj
 			node = obj
 		} else if info.Mode().IsRegular() {
-			var obj = File{
-				LocalPath: path,
-				Size:      info.Size(),
-			}
-			h := sha256.New()
-			f, err := os.Open(path)
-			if err != nil {
-				return err
-			}
-			if _, err = io.Copy(h, f); err != nil {
-				f.Close()
-				return err
+			// Hash in the background; the file will be added to its parent
+			// once it's done.
+			var p = &pendingFile{
+				parent: *parent,
+				name:   info.Name(),
+				file: File{
+					LocalPath: path,
+					Size:      info.Size(),
+					Mode:      info.Mode().Perm(),
+				},
 			}
-			if err = f.Close(); err != nil {
-				return err
-			}
-			copy(obj.SHA256[:], h.Sum(nil))
-			node = obj
+			pending = append(pending, p)
+			jobs <- p
+			stats.Files += 1
+			stats.Bytes += info.Size()
+			return nil
 		} else {
-			if info.Mode()&fs.ModeNamedPipe != 0 {
-				log.Printf("Skipping named pipe %#v\n", path)
-			} else {
-				err := fmt.Errorf("unknown special file at %#v", path)
-				panic(err)
-			}
+			var reason = specialFileReason(info.Mode())
+			log.Printf("Skipping %s %#v\n", reason, path)
+			stats.Skipped = append(stats.Skipped, SkippedFile{
+				Path:   strings.TrimPrefix(path, dir+"/"),
+				Reason: reason,
+			})
 			// To skip, don't add node to parent.Contents
 			return nil
 		}
 
-		parent, found := parents[parentdir]
-		if !found {
-			return fmt.Errorf("parent not found: %s", parentdir)
-		}
 		parent.Contents[info.Name()] = node
 		return nil
 	})
+	close(jobs)
+	wg.Wait()
 	if err != nil {
 		panic(err)
+	} else if hashErr != nil {
+		panic(hashErr)
+	}
+
+	for _, p := range pending {
+		p.parent.Contents[p.name] = p.file
+	}
+
+	resolveLinks(root, dir)
+	stats.Elapsed = time.Since(start)
+	return root, stats
+}
+
+// hashFile computes the SHA-256 hash of a regular file on disk, and detects
+// its character encoding and counts lines of code along the way.
+func hashFile(f *File) error {
+	h := sha256.New()
+	sn := &encodingSniffer{}
+	var w = []io.Writer{h, sn}
+	lc := newLineCounter(f.LocalPath)
+	if lc != nil {
+		w = append(w, lc)
+	}
+	r, err := os.Open(f.LocalPath)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(io.MultiWriter(w...), r); err != nil {
+		r.Close()
+		return err
+	}
+	if err = r.Close(); err != nil {
+		return err
+	}
+	copy(f.SHA256[:], h.Sum(nil))
+	f.Encoding = sn.Encoding()
+	switch f.Encoding {
+	case EncodingBinary, EncodingUnknown, EncodingUTF16LE, EncodingUTF16BE:
+		// Line counts would be meaningless
+	default:
+		if lc != nil {
+			f.Language = lc.lang.name
+			f.Lines = lc.Counts()
+		}
+	}
+	return nil
+}
+
+// specialFileReason describes why a non-regular file can't be included in the
+// tree.
+func specialFileReason(mode fs.FileMode) string {
+	switch {
+	case mode&fs.ModeNamedPipe != 0:
+		return "named pipe"
+	case mode&fs.ModeSocket != 0:
+		return "socket"
+	case mode&fs.ModeCharDevice != 0:
+		return "character device"
+	case mode&fs.ModeDevice != 0:
+		return "block device"
+	default:
+		return "irregular file"
+	}
+}
+
+// resolveLinks finds the destination of every symbolic link in the tree. Links
+// are resolved within the archive: absolute links are treated as pointing
+// outside of it, unless they happen to point into the archive's own directory
+// on disk.
+func resolveLinks(root Directory, dir string) {
+	var r = linkResolver{root, dir}
+	var visit func(d Directory, prefix string)
+	visit = func(d Directory, prefix string) {
+		for name, node := range d.Contents {
+			switch node := node.(type) {
+			case Directory:
+				visit(node, path.Join(prefix, name))
+			case SymbolicLink:
+				target, dst, status := r.resolveLink(prefix, node.SymlinkTo, 0)
+				if status == LinkResolved {
+					_, node.IsDir = dst.(Directory)
+					node.Target = target
+				}
+				node.Status = status
+				d.Contents[name] = node
+			}
+		}
+	}
+	visit(root, "")
+}
+
+type linkResolver struct {
+	root Directory
+	dir  string // local directory, to detect absolute links into the archive
+}
+
+// resolveLink resolves a link with the given destination, located in the
+// directory `parent` (a canonical path relative to the root).
+func (r linkResolver) resolveLink(parent, dst string, depth int) (string, INode, string) {
+	if depth >= maxLinkDepth {
+		return "", nil, LinkDangling
+	}
+	if path.IsAbs(dst) {
+		if !strings.HasPrefix(dst, r.dir+"/") {
+			return "", nil, LinkExternal
+		}
+		return r.resolvePath(strings.TrimPrefix(dst, r.dir+"/"), depth)
+	}
+	return r.resolvePath(parent+"/"+dst, depth)
+}
+
+// resolvePath walks a path relative to the root, following any symbolic links
+// along the way, and returns the canonical path and node it points to.
+func (r linkResolver) resolvePath(p string, depth int) (string, INode, string) {
+	var stack = []Directory{r.root}
+	var names []string
+	var parts = strings.Split(p, "/")
+	for i, part := range parts {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if len(names) == 0 {
+				return "", nil, LinkExternal
+			}
+			stack = stack[:len(stack)-1]
+			names = names[:len(names)-1]
+			continue
+		}
+
+		child, found := stack[len(stack)-1].Contents[part]
+		if !found {
+			return "", nil, LinkDangling
+		}
+		switch child := child.(type) {
+		case Directory:
+			stack = append(stack, child)
+			names = append(names, part)
+		case File:
+			for _, rest := range parts[i+1:] {
+				if rest != "" && rest != "." {
+					return "", nil, LinkDangling
+				}
+			}
+			return path.Join(append(names, part)...), child, LinkResolved
+		case SymbolicLink:
+			// Splice the link's destination into the remaining path
+			target, _, status := r.resolveLink(
+				path.Join(names...), child.SymlinkTo, depth+1,
+			)
+			if status != LinkResolved {
+				return "", nil, status
+			}
+			rest := append([]string{target}, parts[i+1:]...)
+			return r.resolvePath(strings.Join(rest, "/"), depth+1)
+		}
+	}
+	var target = path.Join(names...)
+	if target == "" {
+		target = "."
 	}
-	return root
+	return target, stack[len(stack)-1], LinkResolved
 }
@@ -3,6 +3,7 @@ package analysis
 import (
 	"encoding/hex"
 	"encoding/json"
+	"net"
 	"os"
 	"path/filepath"
 	"strings"
@@ -203,7 +204,8 @@ func TestFiles(t *testing.T) {
 	}
 	defer os.RemoveAll(tempdir)
 
-	var files = constructTree(tempdir).Files()
+	var tree, _ = constructTree(tempdir, 2)
+	var files = tree.Files()
 	if len(files) != 2 {
 		t.Errorf("Wrong number of files: %#v", files)
 	}
@@ -223,7 +225,7 @@ func TestConstructTreeBasic(t *testing.T) {
 	}
 	defer os.RemoveAll(tempdir)
 
-	var tree = constructTree(tempdir)
+	var tree, _ = constructTree(tempdir, 2)
 	if len(tree.Contents) != 2 {
 		t.Errorf("Wrong number of elements in root: %#v", tree)
 	}
@@ -286,7 +288,13 @@ func TestConstructTreeInvalid(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	var tree = constructTree(tempdir)
+	sock, err := net.Listen("unix", filepath.Join(tempdir, "socket"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sock.Close()
+
+	var tree, stats = constructTree(tempdir, 2)
 
 	sym := tree.Contents["invalid"].(SymbolicLink)
 	if sym.SymlinkTo != "nosuchthing" {
@@ -300,4 +308,169 @@ func TestConstructTreeInvalid(t *testing.T) {
 	if found {
 		t.Errorf("Pipes should be ignored, found %#v", p)
 	}
+
+	k, found := tree.Contents["socket"]
+	if found {
+		t.Errorf("Sockets should be ignored, found %#v", k)
+	}
+
+	var reasons = make(map[string]string)
+	for _, s := range stats.Skipped {
+		reasons[s.Path] = s.Reason
+	}
+	if reasons["luigi"] != "named pipe" || reasons["socket"] != "socket" {
+		t.Errorf("Skipped files recorded incorrectly: %#v", stats.Skipped)
+	}
+	if stats.Files != 2 || stats.Bytes != 19 {
+		t.Errorf("Wrong stats: %#v", stats)
+	}
+}
+
+func TestSerializeMetadata(t *testing.T) {
+	var exe = file
+	exe.Mode = 0755
+	var root = Directory{
+		Contents: map[string]INode{
+			"run.sh": exe,
+			"alias": SymbolicLink{
+				SymlinkTo: "./run.sh",
+				Target:    "run.sh",
+			},
+			"broken": SymbolicLink{
+				SymlinkTo: "/etc/passwd",
+				Status:    LinkExternal,
+			},
+		},
+	}
+	actual, err := MarshalTree(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{
+  "schema": 4,
+  "type": "directory",
+  "contents": {
+    "alias": {
+      "type": "symlink",
+      "symlink_to": "./run.sh",
+      "is_directory": false,
+      "target": "run.sh"
+    },
+    "broken": {
+      "type": "symlink",
+      "symlink_to": "/etc/passwd",
+      "is_directory": false,
+      "status": "external"
+    },
+    "run.sh": {
+      "type": "file",
+      "size": 123,
+      "sha256": "52fdfc072182654f163f5f0f487f69999a621d729566c74d10037c4d7bbb0407",
+      "mode": "0755",
+      "executable": true
+    }
+  }
+}`
+	if string(actual) != expected {
+		t.Errorf("Incorrect JSON serialization\nGot %#v\nExp %#v", string(actual), expected)
+	}
+}
+
+func TestConstructTreeLinks(t *testing.T) {
+	tempdir, err := setUpDummyTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempdir)
+
+	var links = map[string]string{
+		"chain":    "somedir/self/somelink",
+		"escape":   "../outside",
+		"absolute": "/usr/share/common-licenses/GPL",
+		"internal": filepath.Join(tempdir, "somedir", "foo.bar"),
+		"loop":     "loop",
+	}
+	for name, dst := range links {
+		if err := os.Symlink(dst, filepath.Join(tempdir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chmod(filepath.Join(tempdir, "hello.txt"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var tree, _ = constructTree(tempdir, 2)
+
+	if f := tree.Contents["hello.txt"].(File); f.Mode != 0755 {
+		t.Errorf("File has wrong mode: %#v", f)
+	}
+
+	s1 := tree.Contents["somedir"].(Directory).Contents["self"].(SymbolicLink)
+	if s1.Target != "somedir" || s1.Status != LinkResolved || !s1.IsDir {
+		t.Errorf("Directory link resolved incorrectly: %#v", s1)
+	}
+
+	s2 := tree.Contents["chain"].(SymbolicLink)
+	if s2.Target != "hello.txt" || s2.Status != LinkResolved || s2.IsDir {
+		t.Errorf("Chained link resolved incorrectly: %#v", s2)
+	}
+
+	s3 := tree.Contents["escape"].(SymbolicLink)
+	if s3.Target != "" || s3.Status != LinkExternal {
+		t.Errorf("Escaping link resolved incorrectly: %#v", s3)
+	}
+
+	s4 := tree.Contents["absolute"].(SymbolicLink)
+	if s4.SymlinkTo != "/usr/share/common-licenses/GPL" || s4.Status != LinkExternal {
+		t.Errorf("Absolute link resolved incorrectly: %#v", s4)
+	}
+
+	s5 := tree.Contents["internal"].(SymbolicLink)
+	if s5.Target != "somedir/foo.bar" || s5.Status != LinkResolved {
+		t.Errorf("Absolute link into archive resolved incorrectly: %#v", s5)
+	}
+
+	s6 := tree.Contents["loop"].(SymbolicLink)
+	if s6.Status != LinkDangling {
+		t.Errorf("Looping link resolved incorrectly: %#v", s6)
+	}
+}
+
+func TestWriteTreeMatchesMarshalIndent(t *testing.T) {
+	var root = Directory{
+		Contents: map[string]INode{
+			"a<b>&c": file,
+			"empty":  Directory{Contents: map[string]INode{}},
+			"src": Directory{
+				Contents: map[string]INode{
+					"bundle.tar.gz": Directory{
+						Contents: map[string]INode{"inner.c": file},
+						Archive:  true,
+					},
+					"link": SymbolicLink{SymlinkTo: "../a<b>&c"},
+				},
+			},
+		},
+	}
+	actual, err := MarshalTree(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := json.MarshalIndent(&struct {
+		Schema   int              `json:"schema"`
+		Type     string           `json:"type"`
+		Contents map[string]INode `json:"contents"`
+	}{
+		Schema:   TreeSchemaVersion,
+		Type:     "directory",
+		Contents: root.Contents,
+	}, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(actual) != string(expected) {
+		t.Errorf("Streamed tree differs from json.MarshalIndent\nGot %s\nExp %s", actual, expected)
+	}
 }
@@ -0,0 +1,195 @@
+package analysis
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/btidor/src.codes/publisher/control"
+	"gopkg.in/yaml.v2"
+)
+
+// Upstream describes where a package comes from, as recorded by its
+// maintainers in debian/control, debian/upstream/metadata (DEP-12) and
+// debian/watch.
+//
+// https://dep-team.pages.debian.net/deps/dep12/
+type Upstream struct {
+	Homepage         string   `json:"homepage,omitempty"`
+	Repository       string   `json:"repository,omitempty"`
+	RepositoryBrowse string   `json:"repository_browse,omitempty"`
+	BugDatabase      string   `json:"bug_database,omitempty"`
+	BugSubmit        string   `json:"bug_submit,omitempty"`
+	Documentation    string   `json:"documentation,omitempty"`
+	Changelog        string   `json:"changelog,omitempty"`
+	Watch            []string `json:"watch,omitempty"` // release URL patterns
+
+	// MovedFrom is the previous version's repository (or homepage), if it was
+	// different. It's filled in by the publisher, not by ReadUpstream.
+	MovedFrom string `json:"moved_from,omitempty"`
+}
+
+// The DEP-12 fields we keep. Values may be strings or (rarely) lists, so
+// they're decoded loosely.
+var upstreamFields = map[string]func(u *Upstream) *string{
+	"Repository":        func(u *Upstream) *string { return &u.Repository },
+	"Repository-Browse": func(u *Upstream) *string { return &u.RepositoryBrowse },
+	"Bug-Database":      func(u *Upstream) *string { return &u.BugDatabase },
+	"Bug-Submit":        func(u *Upstream) *string { return &u.BugSubmit },
+	"Documentation":     func(u *Upstream) *string { return &u.Documentation },
+	"Changelog":         func(u *Upstream) *string { return &u.Changelog },
+}
+
+var watchVersion = regexp.MustCompile(`^version\s*=\s*(\d+)`)
+
+// ReadUpstream gathers a package's upstream metadata. Missing or malformed
+// files are skipped.
+func ReadUpstream(a Archive) Upstream {
+	var u Upstream
+	var debian = filepath.Join(a.Dir, "debian")
+
+	if data, ok := readOptionalFile(filepath.Join(debian, "control")); ok {
+		if docs, err := control.ParseAll(data); err == nil && len(docs) > 0 {
+			u.Homepage = strings.TrimSpace(docs[0]["Homepage"])
+		}
+	}
+	if data, ok := readOptionalFile(filepath.Join(debian, "upstream", "metadata")); ok {
+		if err := parseUpstreamMetadata(data, &u); err != nil {
+			log.Printf("[%s] Could not parse debian/upstream/metadata: %s\n", a.Pkg.Slug(), err)
+		}
+	}
+	if data, ok := readOptionalFile(filepath.Join(debian, "watch")); ok {
+		u.Watch = parseWatchFile(data)
+	}
+	return u
+}
+
+func readOptionalFile(filename string) (string, bool) {
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", false
+	} else if err != nil {
+		panic(err)
+	}
+	return string(data), true
+}
+
+func parseUpstreamMetadata(data string, u *Upstream) error {
+	var raw map[string]any
+	if err := yaml.Unmarshal([]byte(data), &raw); err != nil {
+		return err
+	}
+	for key, value := range raw {
+		for field, ptr := range upstreamFields {
+			if !strings.EqualFold(key, field) {
+				continue
+			}
+			switch v := value.(type) {
+			case string:
+				*ptr(u) = strings.TrimSpace(v)
+			case []any:
+				if len(v) > 0 {
+					*ptr(u) = strings.TrimSpace(fmt.Sprint(v[0]))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// parseWatchFile extracts the URL patterns from a debian/watch file. Both the
+// line-based format (versions 1-4) and the deb822 format (version 5) are
+// supported.
+//
+// https://manpages.debian.org/unstable/devscripts/uscan.1.en.html
+func parseWatchFile(data string) []string {
+	var lines []string
+	var joined strings.Builder
+	for _, line := range strings.Split(data, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if strings.HasSuffix(trimmed, `\`) {
+			joined.WriteString(strings.TrimSuffix(trimmed, `\`))
+			continue
+		}
+		joined.WriteString(trimmed)
+		if joined.Len() > 0 {
+			lines = append(lines, joined.String())
+		}
+		joined.Reset()
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	if strings.HasPrefix(strings.ToLower(lines[0]), "version:") {
+		return parseWatchFileV5(data)
+	}
+
+	var patterns []string
+	for i, line := range lines {
+		if i == 0 && watchVersion.MatchString(line) {
+			continue
+		}
+		// Skip the options, which may contain spaces if quoted
+		if strings.HasPrefix(line, "opts=") {
+			rest := strings.TrimPrefix(line, "opts=")
+			if strings.HasPrefix(rest, `"`) {
+				if end := strings.Index(rest[1:], `"`); end >= 0 {
+					line = rest[end+2:]
+				}
+			} else if _, after, found := strings.Cut(rest, " "); found {
+				line = after
+			} else {
+				line = ""
+			}
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		// The URL may be followed by a separate filename pattern
+		var pattern = fields[0]
+		if len(fields) > 1 && !strings.Contains(fields[1], "://") && fields[1] != "debian" &&
+			!strings.HasPrefix(fields[1], "uupdate") && !strings.HasPrefix(fields[1], "same") {
+			pattern = strings.TrimSuffix(pattern, "/") + "/" + fields[1]
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns
+}
+
+func parseWatchFileV5(data string) []string {
+	docs, err := control.ParseAll(data)
+	if err != nil {
+		return nil
+	}
+	var patterns []string
+	for _, doc := range docs {
+		source := strings.TrimSpace(doc["Source"])
+		if source == "" {
+			continue
+		}
+		if matching := strings.TrimSpace(doc["Matching-Pattern"]); matching != "" {
+			source = strings.TrimSuffix(source, "/") + "/" + matching
+		}
+		patterns = append(patterns, source)
+	}
+	return patterns
+}
+
+// Canonical returns the URL that best identifies the upstream project, for
+// detecting when it moves.
+func (u Upstream) Canonical() string {
+	if u.Repository != "" {
+		return u.Repository
+	}
+	return u.Homepage
+}
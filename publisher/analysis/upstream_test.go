@@ -0,0 +1,57 @@
+package analysis
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseUpstreamMetadata(t *testing.T) {
+	var u Upstream
+	err := parseUpstreamMetadata(`---
+Name: example
+Repository: https://github.com/example/example.git
+Repository-Browse: https://github.com/example/example
+Bug-Database: https://github.com/example/example/issues
+Documentation:
+  - https://example.readthedocs.io/
+Reference:
+  Author: Someone
+`, &u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Upstream{
+		Repository:       "https://github.com/example/example.git",
+		RepositoryBrowse: "https://github.com/example/example",
+		BugDatabase:      "https://github.com/example/example/issues",
+		Documentation:    "https://example.readthedocs.io/",
+	}
+	if !reflect.DeepEqual(u, expected) {
+		t.Errorf("Unexpected metadata: %#v", u)
+	}
+}
+
+func TestParseWatchFile(t *testing.T) {
+	for _, tc := range []struct {
+		data     string
+		expected []string
+	}{
+		{
+			"version=4\n# comment\nopts=\"filenamemangle=s/.+\\/v?(\\d\\S+)\\.tar\\.gz/foo-$1\\.tar\\.gz/\" \\\n  https://github.com/example/foo/tags .*/v?(\\d\\S+)\\.tar\\.gz\n",
+			[]string{`https://github.com/example/foo/tags/.*/v?(\d\S+)\.tar\.gz`},
+		},
+		{
+			"version=3\nhttps://ftp.gnu.org/gnu/hello/hello-(.*)\\.tar\\.gz debian uupdate\n",
+			[]string{`https://ftp.gnu.org/gnu/hello/hello-(.*)\.tar\.gz`},
+		},
+		{
+			"Version: 5\n\nSource: https://example.org/releases/\nMatching-Pattern: example-(\\d+)\\.tar\\.xz\n",
+			[]string{`https://example.org/releases/example-(\d+)\.tar\.xz`},
+		},
+		{"# no upstream releases\n", nil},
+	} {
+		if patterns := parseWatchFile(tc.data); !reflect.DeepEqual(patterns, tc.expected) {
+			t.Errorf("Unexpected patterns: %#v, want %#v", patterns, tc.expected)
+		}
+	}
+}
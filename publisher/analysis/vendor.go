@@ -0,0 +1,338 @@
+package analysis
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	// Directories with fewer than this many files (including subdirectories)
+	// are too small to fingerprint meaningfully.
+	minVendorFiles = 4
+
+	// Number of hash functions in each MinHash signature, and how they're
+	// split into bands for locality-sensitive hashing. With 8 bands of 4 rows,
+	// pairs with a similarity of 0.8 are compared ~98% of the time.
+	minHashSize = 32
+	minHashRows = 4
+
+	// Directories are grouped as near-identical if their estimated Jaccard
+	// similarity (on the set of file hashes) is at least this.
+	DefaultVendorThreshold = 0.8
+
+	// LSH buckets with more members than this are skipped, since comparing
+	// every pair would be too expensive. Exact copies are still grouped.
+	maxVendorBucket = 500
+)
+
+// A DirectoryFingerprint summarizes the contents of a directory, for finding
+// copies of it in other packages. Only file contents are considered, not
+// names, so renamed files still match.
+type DirectoryFingerprint struct {
+	//lint:ignore U1000 msgpack config options
+	_msgpack struct{} `msgpack:",as_array"`
+
+	Path    string // relative to the root of the package
+	Files   int
+	Exact   string // hash of the sorted, deduplicated file hashes
+	MinHash []uint32
+}
+
+// ConstructVendorIndex fingerprints every directory in the archive (except the
+// root and debian/) with enough files in it, including nested archives.
+func ConstructVendorIndex(a Archive) []DirectoryFingerprint {
+	var result []DirectoryFingerprint
+	for _, name := range sortedNames(a.Tree) {
+		if name == "debian" {
+			continue
+		}
+		if dir, ok := a.Tree.Contents[name].(Directory); ok {
+			fingerprintDirectory(name, dir, &result)
+		}
+	}
+	return result
+}
+
+func sortedNames(d Directory) []string {
+	var names []string
+	for name := range d.Contents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// fingerprintDirectory fingerprints a directory and its subdirectories, and
+// returns the hashes of all the files underneath it.
+func fingerprintDirectory(p string, d Directory, result *[]DirectoryFingerprint) [][32]byte {
+	var hashes [][32]byte
+	for _, name := range sortedNames(d) {
+		switch node := d.Contents[name].(type) {
+		case File:
+			hashes = append(hashes, node.SHA256)
+		case Directory:
+			hashes = append(hashes, fingerprintDirectory(path.Join(p, name), node, result)...)
+		}
+	}
+	if len(hashes) >= minVendorFiles {
+		*result = append(*result, fingerprint(p, hashes))
+	}
+	return hashes
+}
+
+func fingerprint(p string, hashes [][32]byte) DirectoryFingerprint {
+	var set = make(map[[32]byte]bool, len(hashes))
+	var unique [][32]byte
+	for _, h := range hashes {
+		if !set[h] {
+			set[h] = true
+			unique = append(unique, h)
+		}
+	}
+	sort.Slice(unique, func(i, j int) bool {
+		return string(unique[i][:]) < string(unique[j][:])
+	})
+
+	exact := sha256.New()
+	var signature = make([]uint32, minHashSize)
+	for i := range signature {
+		signature[i] = ^uint32(0)
+	}
+	for _, h := range unique {
+		exact.Write(h[:])
+
+		// The file hashes are already uniformly distributed, so we derive
+		// each hash function by remixing them with a different seed.
+		x := binary.BigEndian.Uint64(h[:8])
+		for i := range signature {
+			if v := uint32(mix64(x ^ minHashSeeds[i])); v < signature[i] {
+				signature[i] = v
+			}
+		}
+	}
+	return DirectoryFingerprint{
+		Path:    p,
+		Files:   len(hashes),
+		Exact:   hex.EncodeToString(exact.Sum(nil)[:16]),
+		MinHash: signature,
+	}
+}
+
+var minHashSeeds = func() []uint64 {
+	var seeds = make([]uint64, minHashSize)
+	var state uint64 = 0x5352432e434f4445 // "SRC.CODE"
+	for i := range seeds {
+		state += 0x9e3779b97f4a7c15
+		seeds[i] = mix64(state)
+	}
+	return seeds
+}()
+
+// mix64 is the SplitMix64 finalizer.
+func mix64(z uint64) uint64 {
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// A VendorGroup is a set of identical or near-identical directories found in
+// two or more packages.
+type VendorGroup struct {
+	Name    string         `json:"name"`  // most common directory name
+	Exact   bool           `json:"exact"` // all members are identical
+	Members []VendorMember `json:"members"`
+}
+
+type VendorMember struct {
+	Package string `json:"package"`
+	Path    string `json:"path"`
+	Files   int    `json:"files"`
+}
+
+// GroupVendoredCopies finds directories that appear, identically or nearly so,
+// in more than one package. Groups that are just subdirectories of another
+// group's members are omitted.
+func GroupVendoredCopies(packages map[string][]DirectoryFingerprint, threshold float64) []VendorGroup {
+	type item struct {
+		pkg string
+		fp  DirectoryFingerprint
+	}
+	var items []item
+	for _, pkg := range sortedKeys(packages) {
+		for _, fp := range packages[pkg] {
+			if len(fp.MinHash) == minHashSize {
+				items = append(items, item{pkg, fp})
+			}
+		}
+	}
+
+	var uf = newUnionFind(len(items))
+
+	// Exact copies
+	var exact = make(map[string]int)
+	for i, it := range items {
+		if j, found := exact[it.fp.Exact]; found {
+			uf.union(i, j)
+		} else {
+			exact[it.fp.Exact] = i
+		}
+	}
+
+	// Near-identical copies, by locality-sensitive hashing
+	for band := 0; band < minHashSize/minHashRows; band++ {
+		var buckets = make(map[[minHashRows]uint32][]int)
+		for i, it := range items {
+			var key [minHashRows]uint32
+			copy(key[:], it.fp.MinHash[band*minHashRows:])
+			buckets[key] = append(buckets[key], i)
+		}
+		for _, bucket := range buckets {
+			if len(bucket) < 2 || len(bucket) > maxVendorBucket {
+				continue
+			}
+			for x := 0; x < len(bucket); x++ {
+				for y := x + 1; y < len(bucket); y++ {
+					a, b := items[bucket[x]], items[bucket[y]]
+					if a.pkg == b.pkg || uf.find(bucket[x]) == uf.find(bucket[y]) {
+						continue
+					}
+					if similarity(a.fp.MinHash, b.fp.MinHash) >= threshold {
+						uf.union(bucket[x], bucket[y])
+					}
+				}
+			}
+		}
+	}
+
+	// Collect groups spanning at least two packages
+	var components = make(map[int][]int)
+	for i := range items {
+		root := uf.find(i)
+		components[root] = append(components[root], i)
+	}
+	var grouped = make(map[string]bool) // "pkg\x00path"
+	var candidates [][]int
+	for _, members := range components {
+		var pkgs = make(map[string]bool)
+		for _, i := range members {
+			pkgs[items[i].pkg] = true
+		}
+		if len(pkgs) < 2 {
+			continue
+		}
+		for _, i := range members {
+			grouped[items[i].pkg+"\x00"+items[i].fp.Path] = true
+		}
+		candidates = append(candidates, members)
+	}
+
+	var groups []VendorGroup
+	for _, members := range candidates {
+		// If every member's parent directory is also a vendored copy, this
+		// group is redundant.
+		var subsumed = true
+		for _, i := range members {
+			parent := path.Dir(items[i].fp.Path)
+			if parent == "." || !grouped[items[i].pkg+"\x00"+parent] {
+				subsumed = false
+				break
+			}
+		}
+		if subsumed {
+			continue
+		}
+
+		var group = VendorGroup{Exact: true}
+		var names = make(map[string]int)
+	next:
+		for _, i := range members {
+			it := items[i]
+			for _, j := range members {
+				if items[j].pkg == it.pkg && strings.HasPrefix(items[j].fp.Path, it.fp.Path+"/") {
+					// Skip wrapper directories, e.g. `third_party/` when it
+					// only contains `third_party/zlib/`
+					continue next
+				}
+			}
+			group.Members = append(group.Members, VendorMember{
+				Package: it.pkg,
+				Path:    it.fp.Path,
+				Files:   it.fp.Files,
+			})
+			if it.fp.Exact != items[members[0]].fp.Exact {
+				group.Exact = false
+			}
+			names[path.Base(it.fp.Path)]++
+		}
+		sort.Slice(group.Members, func(i, j int) bool {
+			a, b := group.Members[i], group.Members[j]
+			if a.Package != b.Package {
+				return a.Package < b.Package
+			}
+			return a.Path < b.Path
+		})
+		for _, name := range sortedKeys(names) {
+			if names[name] > names[group.Name] {
+				group.Name = name
+			}
+		}
+		groups = append(groups, group)
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		a, b := groups[i], groups[j]
+		if len(a.Members) != len(b.Members) {
+			return len(a.Members) > len(b.Members)
+		}
+		return strings.Compare(a.Members[0].Package+"/"+a.Members[0].Path,
+			b.Members[0].Package+"/"+b.Members[0].Path) < 0
+	})
+	return groups
+}
+
+// similarity estimates the Jaccard similarity of two sets from their MinHash
+// signatures.
+func similarity(a, b []uint32) float64 {
+	var matches int
+	for i := range a {
+		if a[i] == b[i] {
+			matches++
+		}
+	}
+	return float64(matches) / float64(len(a))
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type unionFind []int
+
+func newUnionFind(n int) unionFind {
+	var uf = make(unionFind, n)
+	for i := range uf {
+		uf[i] = i
+	}
+	return uf
+}
+
+func (uf unionFind) find(i int) int {
+	for uf[i] != i {
+		uf[i] = uf[uf[i]]
+		i = uf[i]
+	}
+	return i
+}
+
+func (uf unionFind) union(i, j int) {
+	uf[uf.find(i)] = uf.find(j)
+}
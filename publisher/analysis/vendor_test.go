@@ -0,0 +1,65 @@
+package analysis
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"testing"
+)
+
+func fakeLibrary(variant string) Directory {
+	var lib = Directory{Contents: make(map[string]INode)}
+	for i := 0; i < 20; i++ {
+		content := fmt.Sprintf("zlib file %d", i)
+		if i == 0 {
+			content += variant
+		}
+		lib.Contents[fmt.Sprintf("f%d.c", i)] = File{SHA256: sha256.Sum256([]byte(content))}
+	}
+	var contrib = Directory{Contents: make(map[string]INode)}
+	for i := 0; i < 4; i++ {
+		contrib.Contents[fmt.Sprintf("c%d.c", i)] = File{SHA256: sha256.Sum256([]byte(fmt.Sprint("contrib", i)))}
+	}
+	lib.Contents["contrib"] = contrib
+	return lib
+}
+
+func fakePackage(name string, dirs map[string]Directory) []DirectoryFingerprint {
+	var root = Directory{Contents: make(map[string]INode)}
+	for name, dir := range dirs {
+		root.Contents[name] = dir
+	}
+	for i := 0; i < 10; i++ {
+		root.Contents[fmt.Sprintf("own%d.c", i)] = File{SHA256: sha256.Sum256([]byte(name + fmt.Sprint(i)))}
+	}
+	return ConstructVendorIndex(Archive{Tree: root})
+}
+
+func TestGroupVendoredCopies(t *testing.T) {
+	var unrelated = Directory{Contents: make(map[string]INode)}
+	for i := 0; i < 5; i++ {
+		unrelated.Contents[fmt.Sprint(i)] = File{SHA256: sha256.Sum256([]byte(fmt.Sprint("other", i)))}
+	}
+
+	packages := map[string][]DirectoryFingerprint{
+		"alpha": fakePackage("alpha", map[string]Directory{"zlib": fakeLibrary("")}),
+		"beta":  fakePackage("beta", map[string]Directory{"third_party": {Contents: map[string]INode{"zlib": fakeLibrary("")}}}),
+		"gamma": fakePackage("gamma", map[string]Directory{"zlib-1.3": fakeLibrary("patched"), "other": unrelated}),
+	}
+	groups := GroupVendoredCopies(packages, DefaultVendorThreshold)
+	if len(groups) != 1 {
+		t.Fatalf("Expected one group, got %#v", groups)
+	}
+
+	g := groups[0]
+	if g.Name != "zlib" || g.Exact {
+		t.Errorf("Unexpected group: %#v", g)
+	}
+	expected := []VendorMember{
+		{Package: "alpha", Path: "zlib", Files: 24},
+		{Package: "beta", Path: "third_party/zlib", Files: 24},
+		{Package: "gamma", Path: "zlib-1.3", Files: 24},
+	}
+	if fmt.Sprint(g.Members) != fmt.Sprint(expected) {
+		t.Errorf("Unexpected members: %#v", g.Members)
+	}
+}
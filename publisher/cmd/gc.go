@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/btidor/src.codes/publisher/database"
+	"github.com/btidor/src.codes/publisher/upload"
+)
+
+// Snapshots taken within this window are retained by garbage collection, along
+// with everything they reference. The latest snapshot of each distribution is
+// always retained.
+const snapshotRetention = 90 * 24 * time.Hour
+
+// Index files are named "<name>_<version>:<epoch>.<ext>". Debian versions can
+// contain colons, but the epoch is the last one before the extension.
+var indexFilename = regexp.MustCompile(`^(.*):(\d+)\.[^:]*$`)
+
+// collectGarbage finds objects in storage that aren't referenced by any
+// retained snapshot: index files for expired package versions, package lists
+// of expired snapshots, and files that don't appear in any retained package
+// version. Unless `remove` is set, this is a dry
+// run: it reports what would be removed and deletes nothing, though it does
+// record any missing manifests.
+//
+// This must not run concurrently with the publisher.
+func collectGarbage(remove bool) {
+	var cutoff = time.Now().Add(-snapshotRetention)
+	log.Printf("Collecting garbage from before %s\n", cutoff.Format(time.RFC3339))
+
+	// Package versions published before manifests were introduced need one
+	// before we can tell which files they use.
+	var manifests = db.ListManifests()
+	var missing = make(map[string][]database.PackageVersion)
+	var count int
+	for distro, pvs := range db.RetainedPackageVersions(cutoff) {
+		for _, pv := range pvs {
+			if !manifests[database.ManifestKey{PackageVersion: pv.ID, Epoch: pv.Epoch}] {
+				missing[distro] = append(missing[distro], pv)
+				count++
+			}
+		}
+	}
+	if count > 0 {
+		log.Printf("Backfilling %d manifests from published trees\n", count)
+		walkPublishedTrees(missing, func(distro string, pv database.PackageVersion, hashes map[string][32]byte) {
+			var list [][32]byte
+			for _, hash := range hashes {
+				list = append(list, hash)
+			}
+			db.RecordManifest(pv, list)
+		})
+	}
+
+	// Remove expired rows from the database before deleting any objects, so
+	// nothing in the database refers to a missing object. If we crash partway
+	// through, leftover index files will be found again by the next run, since
+	// they're found by listing storage; leftover files are no longer tracked
+	// and just take up space.
+	garbage := db.CollectGarbage(cutoff, !remove)
+	for _, pv := range garbage.Unmanifested {
+		log.Printf("[%s_%s:%d] No manifest, could not download tree?\n", pv.Name, pv.Version, pv.Epoch)
+	}
+	if len(garbage.Unmanifested) > 0 {
+		log.Printf("%d package versions have no manifest; skipping files\n", len(garbage.Unmanifested))
+	}
+
+	// Find index files that don't belong to a retained package version and
+	// epoch
+	var indexes []index
+	var indexBytes int64
+	for distro, pvs := range garbage.Retained {
+		for _, i := range expiredIndexes(distro, pvs, up.ListPackageIndexes(distro)) {
+			indexes = append(indexes, i)
+			indexBytes += i.size
+		}
+	}
+
+	var snapshots int
+	for distro, pvs := range garbage.PackageVersions {
+		log.Printf("[%s] %d expired package versions\n", distro, len(pvs))
+	}
+	for distro, ids := range garbage.Snapshots {
+		log.Printf("[%s] %d expired snapshots\n", distro, len(ids))
+		snapshots += len(ids)
+	}
+	log.Printf("%d index files (%d bytes), %d snapshots and %d files are unreferenced\n",
+		len(indexes), indexBytes, snapshots, len(garbage.Hashes))
+
+	if !remove {
+		for _, i := range indexes {
+			fmt.Printf("%s/%s/%s\n", i.distro, i.name, i.filename)
+		}
+		log.Println("Dry run; re-run with `gc --delete` to remove")
+		return
+	}
+
+	for distro, ids := range garbage.Snapshots {
+		up.UploadSnapshotIndex(distro, db.ListSnapshots(distro))
+		for _, id := range ids {
+			up.DeleteSnapshot(distro, id)
+		}
+	}
+	deleteInParallel(len(indexes), func(i int) {
+		up.DeletePackageIndex(indexes[i].distro, indexes[i].name, indexes[i].filename)
+	})
+	deleteInParallel(len(garbage.Hashes), func(i int) {
+		up.DeleteFile(garbage.Hashes[i])
+	})
+	log.Println("\u2713 Garbage collected")
+}
+
+// An index is an index file in storage.
+type index struct {
+	distro, name, filename string
+	size                   int64
+}
+
+// expiredIndexes finds the index files in a distribution that don't belong to
+// one of the retained package versions, at one of the epochs it's retained at.
+// Snapshots record the epoch that was current when they were taken, so after an
+// epoch bump, an old epoch's indexes are kept until the last snapshot that
+// points to them expires.
+func expiredIndexes(distro string, retained []database.PackageVersion, objects map[string][]upload.Object) []index {
+	type key struct {
+		name, version string
+		epoch         int
+	}
+	var keep = make(map[key]bool)
+	for _, pv := range retained {
+		keep[key{pv.Name, pv.Version, pv.Epoch}] = true
+	}
+
+	var names []string
+	for name := range objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var expired []index
+	for _, name := range names {
+		for _, obj := range objects[name] {
+			var version, epoch, ok = parseIndexFilename(name, obj.ObjectName)
+			if !ok {
+				log.Printf("[%s/%s] Skipping unrecognized file %s\n", distro, name, obj.ObjectName)
+				continue
+			}
+			if !keep[key{name, version, epoch}] {
+				expired = append(expired, index{distro, name, obj.ObjectName, obj.Length})
+			}
+		}
+	}
+	return expired
+}
+
+// parseIndexFilename extracts the version and epoch from the name of one of a
+// package's index files.
+func parseIndexFilename(name, filename string) (version string, epoch int, ok bool) {
+	rest, found := strings.CutPrefix(filename, name+"_")
+	if !found {
+		return "", 0, false
+	}
+	match := indexFilename.FindStringSubmatch(rest)
+	if match == nil {
+		return "", 0, false
+	}
+	epoch, err := strconv.Atoi(match[2])
+	if err != nil {
+		return "", 0, false
+	}
+	return match[1], epoch, true
+}
+
+// deleteInParallel calls fn for 0..n-1 using uploadThreads workers, logging
+// progress.
+func deleteInParallel(n int, fn func(i int)) {
+	var wg sync.WaitGroup
+	jobs := make(chan int)
+	for w := range uploadThreads {
+		wg.Add(1)
+		go func(w int, jobs <-chan int, wg *sync.WaitGroup) {
+			defer wg.Done()
+			for i := range jobs {
+				fn(i)
+			}
+		}(w, jobs, &wg)
+	}
+
+	for i := range n {
+		if i > 0 && i%1000 == 0 {
+			log.Printf("Deleting: % 7d / % 7d\n", i, n)
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
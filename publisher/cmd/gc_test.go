@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/btidor/src.codes/publisher/database"
+	"github.com/btidor/src.codes/publisher/upload"
+)
+
+func TestExpiredIndexesAfterEpochBump(t *testing.T) {
+	openTestDatabase(t)
+	a := recordTestPackage(testPackage("sid", "a", "1"))
+	db.RecordManifest(a, [][32]byte{{1}})
+	db.UpdateDistroContents("sid", []database.PackageVersion{a})
+	db.RecordSnapshot("sid", time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	// Reprocess at the next epoch
+	if _, err := db.Exec("UPDATE package_versions SET sc_epoch = $1 WHERE id = $2", a.Epoch+1, a.ID); err != nil {
+		t.Fatal(err)
+	}
+	bumped := a
+	bumped.Epoch++
+	db.RecordManifest(bumped, [][32]byte{{1}})
+	db.RecordSnapshot("sid", time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC))
+
+	var objects = map[string][]upload.Object{"a": {
+		{ObjectName: fmt.Sprintf("a_0.9:%d.json", a.Epoch)},
+		{ObjectName: fmt.Sprintf("a_1:%d.json", a.Epoch)},
+		{ObjectName: fmt.Sprintf("a_1:%d.tags", a.Epoch)},
+		{ObjectName: fmt.Sprintf("a_1:%d.json", bumped.Epoch)},
+		{ObjectName: "README"},
+	}}
+	var filenames = func(indexes []index) string {
+		var result []string
+		for _, i := range indexes {
+			result = append(result, i.filename)
+		}
+		return fmt.Sprint(result)
+	}
+
+	// The first snapshot still points to the old epoch
+	garbage := db.CollectGarbage(time.Date(2025, 12, 1, 0, 0, 0, 0, time.UTC), true)
+	expected := fmt.Sprintf("[a_0.9:%d.json]", a.Epoch)
+	if actual := filenames(expiredIndexes("sid", garbage.Retained["sid"], objects)); actual != expected {
+		t.Errorf("Unexpected expired indexes: got %s, want %s", actual, expected)
+	}
+
+	// Once it expires, so does the old epoch
+	garbage = db.CollectGarbage(time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), false)
+	expected = fmt.Sprintf("[a_0.9:%d.json a_1:%d.json a_1:%d.tags]", a.Epoch, a.Epoch, a.Epoch)
+	if actual := filenames(expiredIndexes("sid", garbage.Retained["sid"], objects)); actual != expected {
+		t.Errorf("Unexpected expired indexes: got %s, want %s", actual, expected)
+	}
+}
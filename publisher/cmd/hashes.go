@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/hex"
+	"log"
+	"sync"
+
+	"github.com/btidor/src.codes/publisher/analysis"
+	"github.com/btidor/src.codes/publisher/database"
+)
+
+// backfillHashes repopulates the files table with full-width hashes, read from
+// the trees of every package version we've published. This is a one-time
+// migration for databases created when the table only held the first 64 bits
+// of each hash.
+func backfillHashes() {
+	log.Println("Backfilling file hashes from published trees")
+
+	var batch [][32]byte
+	var count int
+	walkPublishedTrees(db.ListAllPackageVersions(), func(distro string, pv database.PackageVersion, hashes map[string][32]byte) {
+		for _, hash := range hashes {
+			batch = append(batch, hash)
+			if len(batch) >= checkpointLimit {
+				db.RecordHashes(batch)
+				count += len(batch)
+				batch = nil
+			}
+		}
+	})
+	db.RecordHashes(batch)
+	count += len(batch)
+
+	db.FinishHashBackfill()
+	log.Printf("Backfilled %d file hashes\n", count)
+}
+
+// verifyHashes looks for files that were never uploaded because the first 64
+// bits of their hash collided with another file's, back when that's all the
+// files table stored. Missing files are removed from the files table and the
+// package versions containing them are marked for reprocessing, so they'll be
+// uploaded on the next run. Returns true if any were found.
+func verifyHashes() (errored bool) {
+	groups := db.FindHashCollisions()
+	log.Printf("Checking %d groups of hashes with colliding prefixes\n", len(groups))
+
+	var missing = make(map[[32]byte]bool)
+	for _, group := range groups {
+		for _, hash := range group {
+			if !up.HasFile(hash) {
+				log.Printf("Missing file %x\n", hash)
+				missing[hash] = true
+			}
+		}
+	}
+	if len(missing) == 0 {
+		log.Println("\u2713 No missing files")
+		return false
+	}
+
+	var forget [][32]byte
+	for hash := range missing {
+		forget = append(forget, hash)
+	}
+	db.ForgetHashes(forget)
+
+	var affected []database.PackageVersion
+	walkPublishedTrees(db.ListAllPackageVersions(), func(distro string, pv database.PackageVersion, hashes map[string][32]byte) {
+		var found bool
+		for p, hash := range hashes {
+			if missing[hash] {
+				log.Printf("[%s/%s_%s] Missing %s\n", distro, pv.Name, pv.Version, p)
+				found = true
+			}
+		}
+		if found {
+			affected = append(affected, pv)
+		}
+	})
+	db.InvalidatePackageVersions(affected)
+	log.Printf("%d missing files in %d package versions; marked for reprocessing\n",
+		len(missing), len(affected))
+	return true
+}
+
+// walkPublishedTrees downloads the tree of each of the given package versions,
+// by distro, and calls fn with the hash of each file, keyed by path. Trees are
+// downloaded in parallel, but calls to fn are serialized.
+func walkPublishedTrees(all map[string][]database.PackageVersion, fn func(distro string, pv database.PackageVersion, hashes map[string][32]byte)) {
+	type job struct {
+		distro string
+		pv     database.PackageVersion
+	}
+	type result struct {
+		job
+		hashes map[string][32]byte
+	}
+
+	var wg sync.WaitGroup
+	jobs := make(chan job)
+	results := make(chan result, 16)
+	for w := range downloadThreads {
+		wg.Add(1)
+		go func(w int, jobs <-chan job, wg *sync.WaitGroup) {
+			defer wg.Done()
+			for j := range jobs {
+				data, err := up.DownloadTree(j.distro, j.pv)
+				if err != nil {
+					// A missing tree only means its files will be uploaded
+					// again if they're seen again, so keep going.
+					log.Printf("[%s/%s_%s] Could not download tree: %s\n",
+						j.distro, j.pv.Name, j.pv.Version, err)
+					continue
+				}
+				parsed, err := analysis.ParseTreeHashes(data)
+				if err != nil {
+					panic(err)
+				}
+				var hashes = make(map[string][32]byte, len(parsed))
+				for p, h := range parsed {
+					var hash [32]byte
+					if n, err := hex.Decode(hash[:], []byte(h)); err != nil || n != len(hash) {
+						log.Printf("[%s/%s_%s] Invalid hash for %s: %q\n",
+							j.distro, j.pv.Name, j.pv.Version, p, h)
+						continue
+					}
+					hashes[p] = hash
+				}
+				results <- result{j, hashes}
+			}
+		}(w, jobs, &wg)
+	}
+
+	var wg2 sync.WaitGroup
+	wg2.Add(1)
+	go func() {
+		defer wg2.Done()
+		for r := range results {
+			fn(r.distro, r.pv, r.hashes)
+		}
+	}()
+
+	var total, count int
+	for _, pvs := range all {
+		total += len(pvs)
+	}
+	for distro, pvs := range all {
+		for _, pv := range pvs {
+			count++
+			if count%1000 == 0 {
+				log.Printf("Trees: % 7d / % 7d\n", count, total)
+			}
+			jobs <- job{distro, pv}
+		}
+	}
+
+	close(jobs)
+	wg.Wait()
+	close(results)
+	wg2.Wait()
+}
@@ -7,8 +7,10 @@ import (
 	"net/url"
 	"os"
 	"runtime/debug"
+	"sort"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/btidor/src.codes/internal"
 	"github.com/btidor/src.codes/publisher"
@@ -27,6 +29,7 @@ const (
 	pkgThreads      int    = 32
 	uploadThreads   int    = 16
 	downloadThreads int    = 16
+	hashThreads     int    = 4
 	checkpointLimit int    = 1024
 
 	dbBatchSize int    = 1024
@@ -43,11 +46,20 @@ const (
 var db *database.Database
 var up *upload.Uploader
 
+// runID identifies the current run in the database, for recording package
+// attempts.
+var runID int64
+
 func main() {
 	var err error
 
-	// Get a database handle.
-	db, err = database.Connect(dbFilename, dbBatchSize)
+	// Get a database handle. Use Postgres if configured, otherwise a local
+	// SQLite file.
+	var driver = database.SQLite(dbFilename)
+	if dsn := os.Getenv("DATABASE_URL"); dsn != "" {
+		driver = database.Postgres(dsn)
+	}
+	db, err = database.Connect(driver, dbBatchSize)
 	if err != nil {
 		panic(err)
 	}
@@ -61,6 +73,27 @@ func main() {
 	}
 	log.Println("\u2713 Storage CDN")
 
+	// Finish migrating to full-width file hashes, if needed.
+	if db.NeedsHashBackfill() {
+		backfillHashes()
+	}
+	log.Println("\u2713 File Hashes")
+
+	// `publisher verify` checks for files lost to hash collisions, then exits.
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		if verifyHashes() {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// `publisher gc` reports what can be removed from storage; `publisher gc
+	// --delete` removes it.
+	if len(os.Args) > 1 && os.Args[1] == "gc" {
+		collectGarbage(len(os.Args) > 2 && os.Args[2] == "--delete")
+		return
+	}
+
 	// Read config file from `../distributions.toml`
 	var rawConfig map[string]internal.ConfigEntry
 	_, err = toml.DecodeFile(configPath, &rawConfig)
@@ -77,15 +110,37 @@ func main() {
 		if err != nil {
 			panic(err)
 		}
+		if cfg.LargeFileSize == 0 {
+			cfg.LargeFileSize = analysis.DefaultLargeFileSize
+		} else if cfg.LargeFileSize > internal.MaxSearchFileSize {
+			// The grep server couldn't search files in the normal index
+			err = fmt.Errorf("%s: large_file_size is over the limit of %d bytes",
+				name, internal.MaxSearchFileSize)
+			panic(err)
+		}
+		if cfg.MaxFileSize == 0 {
+			cfg.MaxFileSize = analysis.DefaultMaxFileSize
+		}
 		config = append(config, publisher.Distro{
-			Name:       name,
-			Mirror:     u,
-			Areas:      cfg.Areas,
-			Components: cfg.Components,
+			Name:           name,
+			Mirror:         u,
+			Areas:          cfg.Areas,
+			Components:     cfg.Components,
+			LargeFileSize:  cfg.LargeFileSize,
+			MaxFileSize:    cfg.MaxFileSize,
+			ExpandArchives: cfg.ExpandArchives,
 		})
 	}
 	log.Println("\u2713 Distro Config")
 
+	// `publisher status` reports packages that are failing, then exits.
+	if len(os.Args) > 1 && os.Args[1] == "status" {
+		if printStatus(config) {
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Start debug server
 	// http://localhost:6060/debug/pprof/goroutine?debug=2
 	go func() {
@@ -95,18 +150,20 @@ func main() {
 	log.Println()
 
 	// Run!
+	runID = db.StartRun(time.Now())
 	var errored = false
 	for _, distro := range config {
-		if processDistro(distro) {
+		if processDistro(distro, config) {
 			errored = true
 		}
 	}
+	db.FinishRun(runID, time.Now(), errored)
 	if errored {
 		os.Exit(1)
 	}
 }
 
-func processDistro(distro publisher.Distro) (errored bool) {
+func processDistro(distro publisher.Distro, config []publisher.Distro) (errored bool) {
 	defer func() {
 		if err := recover(); err != nil {
 			// If we fail when processing one distro, log the error and
@@ -133,18 +190,28 @@ func processDistro(distro publisher.Distro) (errored bool) {
 		}
 	}
 
-	var existing = db.ListExistingPackages(distro.Name, packages)
+	// The same version of a package is often in several distros (e.g. a suite
+	// and its -updates pocket). If it's been processed for another distro with
+	// the same analysis settings, it can be copied.
+	var peers []string
+	for _, other := range config {
+		if other.Name != distro.Name && other.SameAnalysis(distro) {
+			peers = append(peers, other.Name)
+		}
+	}
+
+	var pkgvers, todo = planDistro(distro.Name, peers, packages, time.Now())
 
 	// Process packages in parallel
-	var jobs = make(chan apt.Package)
-	var results = make(chan database.PackageVersion, len(packages))
+	var jobs = make(chan packageJob)
+	var results = make(chan database.PackageVersion, len(todo))
 	var wg sync.WaitGroup
 	for w := range pkgThreads {
 		wg.Add(1)
-		go func(w int, jobs <-chan apt.Package, wg *sync.WaitGroup) {
+		go func(w int, jobs <-chan packageJob, wg *sync.WaitGroup) {
 			defer wg.Done()
-			for pkg := range jobs {
-				if pv, suberrored := processPackage(pkg); suberrored {
+			for job := range jobs {
+				if pv, suberrored := processPackage(distro, job.pkg, job.prev, job.from); suberrored {
 					errored = true
 				} else {
 					results <- pv
@@ -153,41 +220,33 @@ func processDistro(distro publisher.Distro) (errored bool) {
 		}(w, jobs, &wg)
 	}
 
-	var pkgvers []database.PackageVersion
-	var count int = 0
-	for _, pkg := range packages {
-		count += 1
-		ex, found := existing[pkg.Name]
-		if found && ex.Version == pkg.Version && ex.Epoch >= publisher.Epoch && !reindexPkgs {
-			// Package version has been processed on a previous run
-			pkgvers = append(pkgvers, ex)
-		} else {
-			// Package version is new, must be processed
-			jobs <- pkg
-			log.Printf("[%s] Feed: % 5d / % 5d\n", distro.Name, count, len(packages))
-		}
+	for i, job := range todo {
+		jobs <- job
+		log.Printf("[%s] Feed: % 5d / % 5d\n", distro.Name, i+1, len(todo))
 	}
 	close(jobs)
 	wg.Wait()
 	close(results)
 
-	var processed = false
+	var updated []database.PackageVersion
 	for pv := range results {
 		pkgvers = append(pkgvers, pv)
-		processed = true
+		updated = append(updated, pv)
 	}
-	if !processed && !reindexDistro {
+	if len(updated) == 0 && !reindexDistro {
 		// We didn't update any packages, so skip recomputing the indexes.
 		log.Printf("[%s] No new packages, skipping index creation\n", distro.Name)
+		recordSnapshot(distro.Name)
 		return
 	}
 
 	log.Printf("[%s] Updating table of contents in DB\n", distro.Name)
 	db.UpdateDistroContents(distro.Name, pkgvers)
+	recordSnapshot(distro.Name)
 
 	log.Printf("[%s] Preparing package list\n", distro.Name)
 	pkgvers = db.ListDistroContents(distro.Name)
-	up.UploadPackageList(distro.Name, pkgvers)
+	up.UploadPackageList(distro.Name, pkgvers, db.ListDistroUpstream(distro.Name))
 
 	log.Printf("[%s] Compiling consolidated fzf index\n", distro.Name)
 	up.ConsolidateFzfIndex(distro.Name, pkgvers)
@@ -195,11 +254,113 @@ func processDistro(distro publisher.Distro) (errored bool) {
 	log.Printf("[%s] Compiling consolidated symbols index\n", distro.Name)
 	up.ConsolidateSymbolsIndex(distro.Name, pkgvers)
 
+	log.Printf("[%s] Compiling consolidated license index\n", distro.Name)
+	up.ConsolidateLicenseIndex(distro.Name, pkgvers)
+
+	log.Printf("[%s] Compiling consolidated header index\n", distro.Name)
+	up.ConsolidateHeaderIndex(distro.Name, pkgvers)
+
+	log.Printf("[%s] Compiling consolidated module index\n", distro.Name)
+	up.ConsolidateModuleIndex(distro.Name, pkgvers)
+
+	log.Printf("[%s] Finding vendored copies\n", distro.Name)
+	up.ConsolidateVendorIndex(distro.Name, pkgvers)
+
+	log.Printf("[%s] Compiling line count statistics\n", distro.Name)
+	up.UploadSLOCDistroIndex(distro.Name, db.AggregateLineCounts(distro.Name))
+
+	log.Printf("[%s] Updating changelog feed\n", distro.Name)
+	up.UpdateChangelogFeed(distro.Name, updated)
+
 	log.Printf("[%s] Done!\n", distro.Name)
 	return
 }
 
-func processPackage(pkg apt.Package) (_ database.PackageVersion, errored bool) {
+// A packageJob is a package version to be processed. See processPackage.
+type packageJob struct {
+	pkg  apt.Package
+	prev *database.PackageVersion
+	from *database.SharedVersion
+}
+
+// planDistro decides which packages in a distro need to be processed. Package
+// versions that were processed on a previous run are returned as they are; the
+// rest become jobs, except for those that have been failing and are backing
+// off. Jobs for package versions already processed for one of the `peers` are
+// set to copy them.
+func planDistro(distro string, peers []string, packages map[string]apt.Package, now time.Time) ([]database.PackageVersion, []packageJob) {
+	var existing = db.ListExistingPackages(distro, packages)
+	var failures = db.ListFailures(distro)
+
+	// The version currently in the distro, if any, is the one to diff against
+	var current = make(map[string]database.PackageVersion)
+	for _, pv := range db.ListDistroContents(distro) {
+		current[pv.Name] = pv
+	}
+
+	var shared = make(map[string]database.SharedVersion)
+	if !reindexPkgs {
+		shared = db.ListSharedPackages(peers, packages)
+	}
+
+	var names []string
+	for name := range packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var pkgvers []database.PackageVersion
+	var jobs []packageJob
+	for _, name := range names {
+		pkg := packages[name]
+		ex, found := existing[pkg.Name]
+		f, failed := failures[pkg.Name]
+		if found && ex.Epoch >= publisher.Epoch && !reindexPkgs {
+			// Package version has been processed on a previous run
+			pkgvers = append(pkgvers, ex)
+		} else if failed && f.Version == pkg.Version && now.Before(nextRetry(f)) {
+			// Package version has been failing; as when it fails, leave it
+			// out of the distro
+			log.Printf("[%s] Failed %d times, backing off until %s\n",
+				pkg.Slug(), f.Failures, nextRetry(f).Format(time.RFC3339))
+		} else {
+			// Package version is new, must be processed
+			var job = packageJob{pkg: pkg}
+			if pv, found := current[pkg.Name]; found {
+				job.prev = &pv
+			}
+			if sv, found := shared[pkg.Name]; found {
+				job.from = &sv
+			}
+			jobs = append(jobs, job)
+		}
+	}
+	return pkgvers, jobs
+}
+
+// recordSnapshot saves the distribution's current contents as a snapshot and
+// publishes it, along with the updated list of snapshots.
+func recordSnapshot(distro string) {
+	snapshot := db.RecordSnapshot(distro, time.Now())
+	log.Printf("[%s] Recorded snapshot %d: %d packages (%d added, %d removed, %d updated)\n",
+		distro, snapshot.ID, snapshot.Packages, snapshot.Added, snapshot.Removed, snapshot.Updated)
+	up.UploadSnapshot(distro, snapshot, db.ListSnapshotContents(distro, snapshot.ID))
+	up.UploadSnapshotIndex(distro, db.ListSnapshots(distro))
+}
+
+// processPackage downloads, analyzes and uploads a package. If the package is
+// already in the distro, `prev` points to the version there (which is this
+// version, if it's being reprocessed; see diffBase). If this version was
+// processed for another distro, `from` points to it, and its results are
+// copied instead (see sharePackage).
+func processPackage(distro publisher.Distro, pkg apt.Package, prev *database.PackageVersion, from *database.SharedVersion) (_ database.PackageVersion, errored bool) {
+	var attempt = database.PackageAttempt{
+		Run:       runID,
+		Distro:    distro.Name,
+		Name:      pkg.Name,
+		Version:   pkg.Version,
+		StartedAt: time.Now(),
+	}
 	defer func() {
 		if err := recover(); err != nil {
 			// If we fail when processing one package, log the error and
@@ -210,20 +371,42 @@ func processPackage(pkg apt.Package) (_ database.PackageVersion, errored bool) {
 			log.Println()
 			log.Println(string(debug.Stack()))
 			log.Println("*****************")
+			attempt.Error = fmt.Sprint(err)
 			errored = true
 		}
+		attempt.Duration = time.Since(attempt.StartedAt)
+		db.RecordAttempt(attempt)
 	}()
 
+	if from != nil {
+		attempt.Stage = "share"
+		pv := sharePackage(pkg, prev, *from)
+		attempt.Stage = "done"
+		return pv, false
+	}
+
+	attempt.Stage = "download"
 	log.Printf("[%s] Begin download, extract + walk tree\n", pkg.Slug())
-	var archive = analysis.DownloadExtractAndWalkTree(pkg)
+	var archive = analysis.DownloadExtractAndWalkTree(pkg, hashThreads)
 	defer archive.CleanUp()
+	log.Printf("[%s] Hashed %d files (%d bytes) in %s, skipped %d special files\n",
+		pkg.Slug(), archive.Stats.Files, archive.Stats.Bytes,
+		archive.Stats.Elapsed, len(archive.Stats.Skipped))
+
+	if distro.ExpandArchives {
+		attempt.Stage = "expand"
+		log.Printf("[%s] Expanding nested archives\n", pkg.Slug())
+		analysis.ExpandNestedArchives(&archive, analysis.DefaultNestedArchiveLimits, hashThreads)
+	}
 
+	attempt.Stage = "deduplicate"
 	log.Printf("[%s] Begin deduplication\n", pkg.Slug())
 	var files []analysis.File
 	if !reindexPkgs {
 		files = db.DeduplicateFiles(archive.Tree.Files())
 	}
 
+	attempt.Stage = "upload"
 	log.Printf("[%s] Begin upload of %d files\n", pkg.Slug(), len(files))
 	var count atomic.Int64
 	var wg sync.WaitGroup
@@ -256,28 +439,194 @@ func processPackage(pkg apt.Package) (_ database.PackageVersion, errored bool) {
 	close(jobs)
 	wg.Wait()
 
+	attempt.Stage = "tree"
 	log.Printf("[%s] Uploaded %d files; uploading tree\n", pkg.Slug(), len(files))
 	up.UploadTree(archive)
 
+	if base, found := diffBase(pkg, prev); found {
+		attempt.Stage = "diff"
+		log.Printf("[%s] Computing and uploading diff from %s\n", pkg.Slug(), base.Version)
+		processDiff(archive, base)
+	}
+
+	attempt.Stage = "fzf"
 	log.Printf("[%s] Computing and uploading fzf index\n", pkg.Slug())
 	fzf := analysis.ConstructFzfIndex(archive)
 	up.UploadFzfPackageIndex(*archive.Pkg, fzf)
 
+	attempt.Stage = "ctags"
 	log.Printf("[%s] Computing and uploading ctags index\n", pkg.Slug())
 	ctags := analysis.ConstructCtagsIndex(archive)
+	defer ctags.Remove()
 	up.UploadCtagsPackageIndex(*archive.Pkg, ctags)
 
+	attempt.Stage = "outline"
+	log.Printf("[%s] Computing and uploading outline index\n", pkg.Slug())
+	outline := analysis.ConstructOutlineIndex(archive, ctags)
+	defer outline.Remove()
+	up.UploadOutlinePackageIndex(*archive.Pkg, outline)
+
+	attempt.Stage = "lsif"
+	log.Printf("[%s] Computing and uploading LSIF export\n", pkg.Slug())
+	lsif := analysis.ConstructLSIFIndex(archive, ctags)
+	defer lsif.Remove()
+	up.UploadLSIFPackageIndex(*archive.Pkg, lsif)
+
+	attempt.Stage = "symbols"
 	log.Printf("[%s] Computing and uploading symbols index\n", pkg.Slug())
 	symbols := analysis.ConstructSymbolsIndex(archive, ctags)
 	up.UploadSymbolsPackageIndex(*archive.Pkg, symbols)
 
+	attempt.Stage = "codesearch"
 	log.Printf("[%s] Computing and uploading codesearch index\n", pkg.Slug())
-	codesearch, sourcetar := analysis.ConstructCodesearchIndex(archive)
-	up.UploadCodesearchPackageIndex(*archive.Pkg, codesearch, sourcetar)
-
+	codesearch := analysis.ConstructCodesearchIndex(archive, analysis.CodesearchLimits{
+		LargeFileSize: distro.LargeFileSize,
+		MaxFileSize:   distro.MaxFileSize,
+	})
+	defer codesearch.Remove()
+	up.UploadCodesearchPackageIndex(*archive.Pkg, codesearch)
+
+	attempt.Stage = "licenses"
+	log.Printf("[%s] Computing and uploading license index\n", pkg.Slug())
+	licenses := analysis.ConstructLicenseIndex(archive)
+	up.UploadLicensePackageIndex(*archive.Pkg, licenses)
+
+	attempt.Stage = "changelog"
+	log.Printf("[%s] Parsing and uploading changelog\n", pkg.Slug())
+	changelog := analysis.ConstructChangelogIndex(archive)
+	up.UploadChangelogPackageIndex(*archive.Pkg, changelog)
+
+	attempt.Stage = "includes"
+	log.Printf("[%s] Computing and uploading include index\n", pkg.Slug())
+	includes := analysis.ConstructIncludeIndex(archive)
+	headers := analysis.ConstructHeaderIndex(archive)
+	up.UploadIncludePackageIndex(*archive.Pkg, includes, headers)
+
+	attempt.Stage = "imports"
+	log.Printf("[%s] Computing and uploading import index\n", pkg.Slug())
+	imports, modules := analysis.ConstructImportIndex(archive)
+	up.UploadImportPackageIndex(*archive.Pkg, imports, modules)
+
+	attempt.Stage = "vendor"
+	log.Printf("[%s] Computing and uploading directory fingerprints\n", pkg.Slug())
+	vendor := analysis.ConstructVendorIndex(archive)
+	up.UploadVendorPackageIndex(*archive.Pkg, vendor)
+
+	attempt.Stage = "sloc"
+	log.Printf("[%s] Computing and uploading line counts\n", pkg.Slug())
+	sloc := analysis.ConstructSLOCIndex(archive)
+	up.UploadSLOCPackageIndex(*archive.Pkg, sloc)
+
+	attempt.Stage = "upstream"
+	log.Printf("[%s] Reading upstream metadata\n", pkg.Slug())
+	upstream := analysis.ReadUpstream(archive)
+	trackUpstreamMove(pkg, prev, &upstream)
+
+	attempt.Stage = "record"
 	log.Printf("[%s] Recording package version in DB\n", pkg.Slug())
 	var pv = db.RecordPackageVersion(archive)
+	var hashes [][32]byte
+	for _, file := range archive.Tree.Files() {
+		hashes = append(hashes, file.SHA256)
+	}
+	db.RecordManifest(pv, hashes)
+	db.RecordLineCounts(pv, sloc)
+	db.RecordUpstream(pv, upstream)
 
+	attempt.Stage = "done"
 	log.Printf("[%s] Done!\n", pkg.Slug())
 	return pv, false
 }
+
+// diffBase picks the package version to diff against: `prev`, the version in
+// the distro, unless this is the same version being reprocessed (e.g. at a new
+// epoch), in which case the one before it.
+func diffBase(pkg apt.Package, prev *database.PackageVersion) (database.PackageVersion, bool) {
+	if prev == nil {
+		return database.PackageVersion{}, false
+	} else if prev.Version != pkg.Version {
+		return *prev, true
+	}
+	return db.PreviousVersion(pkg.Source.Distro, pkg.Name, pkg.Version)
+}
+
+func processDiff(archive analysis.Archive, prev database.PackageVersion) {
+	data, err := up.DownloadTree(archive.Pkg.Source.Distro, prev)
+	if err != nil {
+		// The previous tree may have been uploaded under a different name by
+		// an old version of the publisher; skip the diff rather than failing.
+		log.Printf("[%s] Could not download previous tree: %s\n", archive.Pkg.Slug(), err)
+		return
+	}
+	previous, err := analysis.ParseTreeHashes(data)
+	if err != nil {
+		panic(err)
+	}
+	diff := analysis.ConstructDiffIndex(archive, prev.Version, previous)
+	up.UploadDiffPackageIndex(*archive.Pkg, diff)
+}
+
+// sharePackage copies a package version that was processed for another distro:
+// its index files, and its rows in the database. Only the diff, which depends on
+// the previous version in this distro, and the upstream history are redone.
+func sharePackage(pkg apt.Package, prev *database.PackageVersion, from database.SharedVersion) database.PackageVersion {
+	log.Printf("[%s] Already processed for %s, copying index files\n", pkg.Slug(), from.Distro)
+	count := up.CopyPackageIndexes(from.Distro, pkg.Source.Distro, from.PackageVersion)
+	log.Printf("[%s] Copied %d index files\n", pkg.Slug(), count)
+
+	if base, found := diffBase(pkg, prev); found {
+		log.Printf("[%s] Computing and uploading diff from %s\n", pkg.Slug(), base.Version)
+		processSharedDiff(pkg, from, base)
+	}
+
+	log.Printf("[%s] Recording package version in DB\n", pkg.Slug())
+	upstream, found := db.GetUpstream(from.PackageVersion)
+	var pv = db.CopyPackageVersion(from, pkg.Source.Distro)
+	if found {
+		trackUpstreamMove(pkg, prev, &upstream)
+		db.RecordUpstream(pv, upstream)
+	}
+
+	log.Printf("[%s] Done!\n", pkg.Slug())
+	return pv
+}
+
+func processSharedDiff(pkg apt.Package, from database.SharedVersion, prev database.PackageVersion) {
+	data, err := up.DownloadTree(pkg.Source.Distro, prev)
+	if err != nil {
+		log.Printf("[%s] Could not download previous tree: %s\n", pkg.Slug(), err)
+		return
+	}
+	previous, err := analysis.ParseTreeHashes(data)
+	if err != nil {
+		panic(err)
+	}
+	data, err = up.DownloadTree(from.Distro, from.PackageVersion)
+	if err != nil {
+		panic(err)
+	}
+	current, err := analysis.ParseTreeHashes(data)
+	if err != nil {
+		panic(err)
+	}
+	diff := analysis.DiffTreeHashes(pkg.Name, prev.Version, pkg.Version, previous, current)
+	up.UploadDiffPackageIndex(pkg, diff)
+}
+
+// trackUpstreamMove sets upstream.MovedFrom if the package's upstream project
+// has changed since `prev`, the previously processed version.
+func trackUpstreamMove(pkg apt.Package, prev *database.PackageVersion, upstream *analysis.Upstream) {
+	upstream.MovedFrom = ""
+	if prev == nil {
+		return
+	}
+	if old, found := db.GetUpstream(*prev); found {
+		from, to := old.Canonical(), upstream.Canonical()
+		if from != "" && to != "" && from != to {
+			log.Printf("[%s] Upstream moved from %s to %s\n", pkg.Slug(), from, to)
+			upstream.MovedFrom = from
+		} else if prev.Version == pkg.Version {
+			upstream.MovedFrom = old.MovedFrom // reprocessing, keep history
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/btidor/src.codes/publisher/analysis"
+	"github.com/btidor/src.codes/publisher/apt"
+	"github.com/btidor/src.codes/publisher/database"
+)
+
+func openTestDatabase(t *testing.T) {
+	var err error
+	db, err = database.Connect(database.SQLite(filepath.Join(t.TempDir(), "test.db")), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+}
+
+func testPackage(distro, name, version string) apt.Package {
+	return apt.Package{Source: apt.Source{Distro: distro}, Name: name, Version: version}
+}
+
+func recordTestPackage(pkg apt.Package) database.PackageVersion {
+	return db.RecordPackageVersion(analysis.Archive{Pkg: &pkg})
+}
+
+func TestPlanDistro(t *testing.T) {
+	openTestDatabase(t)
+	a1 := recordTestPackage(testPackage("sid", "a", "1"))
+	b1 := recordTestPackage(testPackage("sid", "b", "1"))
+	db.UpdateDistroContents("sid", []database.PackageVersion{a1, b1})
+
+	var now = time.Now()
+	db.RecordAttempt(database.PackageAttempt{
+		Distro: "sid", Name: "c", Version: "1", StartedAt: now, Stage: "download", Error: "boom",
+	})
+
+	done, jobs := planDistro("sid", nil, map[string]apt.Package{
+		"a": testPackage("sid", "a", "2"),
+		"b": testPackage("sid", "b", "1"),
+		"c": testPackage("sid", "c", "1"),
+		"d": testPackage("sid", "d", "1"),
+	}, now)
+
+	if len(done) != 1 || done[0] != b1 {
+		t.Errorf("Expected only b to be done already, got %#v", done)
+	}
+	if len(jobs) != 2 {
+		t.Fatalf("Expected jobs for a and d, got %#v", jobs)
+	}
+	if jobs[0].pkg.Name != "a" || jobs[0].prev == nil || *jobs[0].prev != a1 {
+		t.Errorf("Expected a to be diffed against version 1, got %#v", jobs[0].prev)
+	}
+	if jobs[1].pkg.Name != "d" || jobs[1].prev != nil {
+		t.Errorf("Expected d to have no previous version, got %#v", jobs[1].prev)
+	}
+}
+
+func TestUpstreamMove(t *testing.T) {
+	openTestDatabase(t)
+	a1 := recordTestPackage(testPackage("sid", "a", "1"))
+	db.UpdateDistroContents("sid", []database.PackageVersion{a1})
+	db.RecordUpstream(a1, analysis.Upstream{
+		Repository: "https://old.example.com/a.git",
+		MovedFrom:  "https://older.example.com/a.git",
+	})
+
+	var upstreamFor = func(version, repository string) analysis.Upstream {
+		pkg := testPackage("sid", "a", version)
+		_, jobs := planDistro("sid", nil, map[string]apt.Package{"a": pkg}, time.Now())
+		if len(jobs) != 1 {
+			t.Fatalf("Expected a to be processed, got %#v", jobs)
+		}
+		var u = analysis.Upstream{Repository: repository}
+		trackUpstreamMove(pkg, jobs[0].prev, &u)
+		return u
+	}
+
+	if u := upstreamFor("2", "https://new.example.com/a.git"); u.MovedFrom != "https://old.example.com/a.git" {
+		t.Errorf("Expected move to be detected, got %q", u.MovedFrom)
+	}
+	if u := upstreamFor("2", "https://old.example.com/a.git"); u.MovedFrom != "" {
+		t.Errorf("Expected no move, got %q", u.MovedFrom)
+	}
+
+	// When reprocessing the same version, history is kept
+	db.InvalidatePackageVersions([]database.PackageVersion{a1})
+	if u := upstreamFor("1", "https://old.example.com/a.git"); u.MovedFrom != "https://older.example.com/a.git" {
+		t.Errorf("Expected history to be kept, got %q", u.MovedFrom)
+	}
+}
+
+func TestPlanDistroSharing(t *testing.T) {
+	openTestDatabase(t)
+	recordTestPackage(testPackage("bookworm", "a", "1"))
+	var packages = map[string]apt.Package{"a": testPackage("sid", "a", "1")}
+
+	if _, jobs := planDistro("sid", []string{"bookworm"}, packages, time.Now()); len(jobs) != 1 ||
+		jobs[0].from == nil || jobs[0].from.Distro != "bookworm" {
+		t.Errorf("Expected a to be copied from bookworm, got %#v", jobs)
+	}
+	if _, jobs := planDistro("sid", nil, packages, time.Now()); len(jobs) != 1 || jobs[0].from != nil {
+		t.Errorf("Expected a to be processed from scratch, got %#v", jobs)
+	}
+}
+
+func TestDiffBaseAfterEpochBump(t *testing.T) {
+	openTestDatabase(t)
+	a1 := recordTestPackage(testPackage("sid", "a", "1"))
+	db.UpdateDistroContents("sid", []database.PackageVersion{a1})
+	db.RecordSnapshot("sid", time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	a2 := recordTestPackage(testPackage("sid", "a", "2"))
+	db.UpdateDistroContents("sid", []database.PackageVersion{a2})
+	db.RecordSnapshot("sid", time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC))
+
+	if base, found := diffBase(testPackage("sid", "a", "3"), &a2); !found || base != a2 {
+		t.Errorf("Expected a new version to be diffed against a2, got %#v", base)
+	}
+
+	// Reprocessing a2 at a new epoch should still diff it against a1
+	if base, found := diffBase(testPackage("sid", "a", "2"), &a2); !found || base != a1 {
+		t.Errorf("Expected a reprocessed version to be diffed against a1, got %#v", base)
+	}
+	if _, found := diffBase(testPackage("sid", "b", "1"), nil); found {
+		t.Errorf("Expected a new package to have no diff")
+	}
+}
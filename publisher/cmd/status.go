@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/btidor/src.codes/publisher"
+	"github.com/btidor/src.codes/publisher/database"
+)
+
+// After a package version fails, we wait before trying it again, doubling the
+// wait after each consecutive failure up to a maximum. A new version of the
+// package is always tried right away.
+const (
+	retryBackoff    time.Duration = 6 * time.Hour
+	maxRetryBackoff time.Duration = 14 * 24 * time.Hour
+)
+
+// nextRetry returns the earliest time a failing package version should be
+// attempted again.
+func nextRetry(f database.Failure) time.Time {
+	var wait = retryBackoff
+	for i := 1; i < f.Failures && wait < maxRetryBackoff; i++ {
+		wait *= 2
+	}
+	return f.StartedAt.Add(f.Duration).Add(min(wait, maxRetryBackoff))
+}
+
+// printStatus reports the last publisher run and the packages currently failing
+// in each distro. Returns true if anything is failing.
+func printStatus(config []publisher.Distro) (failing bool) {
+	if run, found := db.LastRun(); !found {
+		fmt.Println("No runs recorded")
+	} else if run.FinishedAt.IsZero() {
+		fmt.Printf("Last run #%d started %s, did not finish\n",
+			run.ID, run.StartedAt.Local().Format(time.DateTime))
+	} else {
+		var result = "succeeded"
+		if run.Errored {
+			result = "had errors"
+		}
+		fmt.Printf("Last run #%d started %s, took %s, %s\n", run.ID,
+			run.StartedAt.Local().Format(time.DateTime),
+			run.FinishedAt.Sub(run.StartedAt), result)
+	}
+
+	sort.Slice(config, func(i, j int) bool { return config[i].Name < config[j].Name })
+	for _, distro := range config {
+		failures := db.ListFailures(distro.Name)
+		fmt.Println()
+		if len(failures) == 0 {
+			fmt.Printf("[%s] No failing packages\n", distro.Name)
+			continue
+		}
+		failing = true
+		fmt.Printf("[%s] %d failing packages\n", distro.Name, len(failures))
+
+		var names []string
+		for name := range failures {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "PACKAGE\tVERSION\tFAILURES\tSINCE\tSTAGE\tNEXT RETRY\tERROR")
+		for _, name := range names {
+			f := failures[name]
+			errText, _, _ := strings.Cut(f.Error, "\n")
+			if len(errText) > 80 {
+				errText = errText[:77] + "..."
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\t%s\n", f.Name, f.Version, f.Failures,
+				f.FirstFailed.Local().Format(time.DateTime), f.Stage,
+				nextRetry(f).Local().Format(time.DateTime), errText)
+		}
+		w.Flush()
+	}
+	return failing
+}
@@ -13,6 +13,8 @@ import (
 	"unicode/utf8"
 )
 
+// A Document is a single paragraph of control data. Multi-line values are
+// stored with their line breaks (and leading whitespace) intact.
 type Document map[string]string
 
 type File struct {
@@ -41,6 +43,7 @@ func Parse(s string) (Document, error) {
 			if key == "" {
 				return nil, fmt.Errorf("found continuation before first key")
 			}
+			value.WriteByte('\n')
 			value.WriteString(line)
 		} else {
 			// Finish previous entry
@@ -66,6 +69,41 @@ func Parse(s string) (Document, error) {
 	return d, nil
 }
 
+// ParseAll parses a sequence of paragraphs separated by blank lines, as in a
+// Sources index or a debian/copyright file. Lines containing only whitespace
+// count as blank, and comment lines beginning with '#' are ignored.
+func ParseAll(s string) ([]Document, error) {
+	var docs []Document
+	var paragraph []string
+	var finish = func() error {
+		if len(paragraph) == 0 {
+			return nil
+		}
+		d, err := Parse(strings.Join(paragraph, "\n"))
+		if err != nil {
+			return err
+		}
+		docs = append(docs, d)
+		paragraph = nil
+		return nil
+	}
+
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" {
+			if err := finish(); err != nil {
+				return nil, err
+			}
+		} else if !strings.HasPrefix(line, "#") {
+			paragraph = append(paragraph, line)
+		}
+	}
+	if err := finish(); err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
+
 func (d Document) GetString(key string) string {
 	if entry, found := (d)[key]; found {
 		return entry
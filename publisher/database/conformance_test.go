@@ -0,0 +1,614 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/btidor/src.codes/publisher"
+	"github.com/btidor/src.codes/publisher/analysis"
+	"github.com/btidor/src.codes/publisher/apt"
+)
+
+// The conformance tests run the same checks against every driver. SQLite is
+// always tested; Postgres is tested if a server is reachable at
+// $SRCCODES_TEST_POSTGRES (by default, the local server).
+const defaultTestPostgres = "postgres://postgres@localhost/postgres?sslmode=disable&connect_timeout=2"
+
+func TestConformanceSQLite(t *testing.T) {
+	runConformance(t, func(t *testing.T) Driver {
+		return SQLite(filepath.Join(t.TempDir(), "database.db"))
+	})
+}
+
+func TestConformancePostgres(t *testing.T) {
+	dsn := os.Getenv("SRCCODES_TEST_POSTGRES")
+	if dsn == "" {
+		dsn = defaultTestPostgres
+	}
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+	if err := admin.Ping(); err != nil {
+		t.Skipf("Postgres not available: %s", err)
+	}
+
+	// Give each test an empty schema of its own
+	runConformance(t, func(t *testing.T) Driver {
+		schema := fmt.Sprintf("conformance_%d", time.Now().UnixNano())
+		if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		})
+
+		u, err := url.Parse(dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q := u.Query()
+		q.Set("search_path", schema)
+		u.RawQuery = q.Encode()
+		return Postgres(u.String())
+	})
+}
+
+func runConformance(t *testing.T, newDriver func(t *testing.T) Driver) {
+	var tests = []struct {
+		name string
+		fn   func(t *testing.T, db *Database)
+	}{
+		{"Reconnect", testReconnect},
+		{"PackageVersions", testPackageVersions},
+		{"DistroContents", testDistroContents},
+		{"Files", testFiles},
+		{"LineCounts", testLineCounts},
+		{"Upstream", testUpstream},
+		{"Snapshots", testSnapshots},
+		{"GarbageCollection", testGarbageCollection},
+		{"EpochGarbageCollection", testEpochGarbageCollection},
+		{"Attempts", testAttempts},
+		{"Sharing", testSharing},
+		{"Concurrency", testConcurrency},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			driver := newDriver(t)
+			db, err := Connect(driver, 4)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			tt.fn(t, db)
+		})
+	}
+}
+
+func recordPackage(t *testing.T, db *Database, distro, name, version string) PackageVersion {
+	t.Helper()
+	return db.RecordPackageVersion(analysis.Archive{
+		Pkg: &apt.Package{
+			Source:  apt.Source{Distro: distro},
+			Name:    name,
+			Version: version,
+		},
+	})
+}
+
+func testReconnect(t *testing.T, db *Database) {
+	var version int
+	if err := db.QueryRow("SELECT version FROM schema_version").Scan(&version); err != nil {
+		t.Fatal(err)
+	} else if version != LatestVersion {
+		t.Errorf("Expected schema version %d, got %d", LatestVersion, version)
+	}
+
+	// Connecting again is a no-op
+	again, err := Connect(db.driver, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again.Close()
+}
+
+func testPackageVersions(t *testing.T, db *Database) {
+	a := recordPackage(t, db, "sid", "zlib", "1.0")
+	b := recordPackage(t, db, "sid", "zlib", "1.0")
+	if a.ID != b.ID {
+		t.Errorf("Re-recording a package version changed its ID: %d != %d", a.ID, b.ID)
+	}
+	c := recordPackage(t, db, "sid", "bash", "5.2")
+	recordPackage(t, db, "bookworm", "zlib", "1.0")
+
+	existing := db.ListExistingPackages("sid", map[string]apt.Package{
+		"zlib": {Name: "zlib", Version: "1.0"},
+		"bash": {Name: "bash", Version: "5.1"}, // different version
+		"curl": {Name: "curl", Version: "8.0"}, // not recorded
+	})
+	if len(existing) != 1 || existing["zlib"].ID != a.ID || existing["zlib"].Epoch != publisher.Epoch {
+		t.Errorf("Unexpected existing packages: %#v", existing)
+	}
+
+	all := db.ListAllPackageVersions()
+	if len(all["sid"]) != 2 || len(all["bookworm"]) != 1 {
+		t.Errorf("Unexpected package versions: %#v", all)
+	}
+
+	db.InvalidatePackageVersions([]PackageVersion{c})
+	for _, pv := range db.ListAllPackageVersions()["sid"] {
+		if pv.ID == c.ID && pv.Epoch != 0 {
+			t.Errorf("Expected epoch to be reset, got %d", pv.Epoch)
+		} else if pv.ID == a.ID && pv.Epoch != publisher.Epoch {
+			t.Errorf("Expected epoch to be unchanged, got %d", pv.Epoch)
+		}
+	}
+}
+
+func testDistroContents(t *testing.T, db *Database) {
+	var pvs []PackageVersion
+	for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+		pvs = append(pvs, recordPackage(t, db, "sid", name, "1"))
+	}
+	db.UpdateDistroContents("sid", pvs)
+
+	// Update one package, and remove another
+	updated := recordPackage(t, db, "sid", "b", "2")
+	db.UpdateDistroContents("sid", []PackageVersion{pvs[0], updated, pvs[2], pvs[3], pvs[4]})
+
+	var actual []string
+	for _, pv := range db.ListDistroContents("sid") {
+		actual = append(actual, pv.Name+"="+pv.Version)
+	}
+	sort.Strings(actual)
+	expected := fmt.Sprint([]string{"a=1", "b=2", "c=1", "d=1", "e=1"})
+	if fmt.Sprint(actual) != expected {
+		t.Errorf("Unexpected distro contents\nGot %v\nExp %s", actual, expected)
+	}
+	if len(db.ListDistroContents("bookworm")) != 0 {
+		t.Errorf("Expected distros to be independent")
+	}
+}
+
+func testFiles(t *testing.T, db *Database) {
+	var a, b, c, d [32]byte
+	a[0], b[0], c[0], d[0] = 1, 2, 3, 4
+	b2 := b
+	b2[31] = 0xff // same 64-bit prefix as b
+
+	db.RecordHashes([][32]byte{a, b, b2, c})
+	db.RecordHashes([][32]byte{a}) // duplicates are ignored
+
+	deduped := db.DeduplicateFiles([]analysis.File{
+		{SHA256: a}, {SHA256: b}, {SHA256: d}, {SHA256: c}, {SHA256: a},
+	})
+	if len(deduped) != 1 || deduped[0].SHA256 != d {
+		t.Errorf("Unexpected deduplicated files: %v", deduped)
+	}
+
+	collisions := db.FindHashCollisions()
+	if len(collisions) != 1 || len(collisions[0]) != 2 ||
+		collisions[0][0] != b || collisions[0][1] != b2 {
+		t.Errorf("Unexpected collisions: %x", collisions)
+	}
+
+	db.ForgetHashes([][32]byte{b2})
+	if collisions := db.FindHashCollisions(); len(collisions) != 0 {
+		t.Errorf("Unexpected collisions: %x", collisions)
+	}
+	if db.NeedsHashBackfill() {
+		t.Errorf("New database shouldn't need a hash backfill")
+	}
+}
+
+func testLineCounts(t *testing.T, db *Database) {
+	a := recordPackage(t, db, "sid", "a", "1")
+	b := recordPackage(t, db, "sid", "b", "1")
+	db.UpdateDistroContents("sid", []PackageVersion{a, b})
+
+	db.RecordLineCounts(a, analysis.SLOCStats{Languages: map[string]analysis.LineCounts{
+		"Go": {Files: 1, Code: 1, Comment: 1, Blank: 1}, // replaced below
+	}})
+	db.RecordLineCounts(a, analysis.SLOCStats{Languages: map[string]analysis.LineCounts{
+		"C":  {Files: 2, Code: 100, Comment: 10, Blank: 5},
+		"Go": {Files: 1, Code: 50, Comment: 5, Blank: 2},
+	}})
+	db.RecordLineCounts(b, analysis.SLOCStats{Languages: map[string]analysis.LineCounts{
+		"C": {Files: 3, Code: 300, Comment: 30, Blank: 15},
+	}})
+
+	sloc := db.AggregateLineCounts("sid")
+	if sloc.Languages["C"] != (analysis.LineCounts{Files: 5, Code: 400, Comment: 40, Blank: 20}) {
+		t.Errorf("Unexpected C line counts: %#v", sloc.Languages["C"])
+	}
+	if sloc.Total != (analysis.LineCounts{Files: 6, Code: 450, Comment: 45, Blank: 22}) {
+		t.Errorf("Unexpected total line counts: %#v", sloc.Total)
+	}
+	if len(sloc.Packages) != 2 || sloc.Packages[0].Name != "b" || sloc.Packages[1].Code != 150 {
+		t.Errorf("Unexpected package line counts: %#v", sloc.Packages)
+	}
+}
+
+func testUpstream(t *testing.T, db *Database) {
+	a := recordPackage(t, db, "sid", "a", "1")
+	b := recordPackage(t, db, "sid", "b", "1")
+	db.UpdateDistroContents("sid", []PackageVersion{a, b})
+
+	if _, found := db.GetUpstream(a); found {
+		t.Errorf("Expected no upstream metadata")
+	}
+
+	db.RecordUpstream(a, analysis.Upstream{Homepage: "https://old.example.com"})
+	expected := analysis.Upstream{
+		Homepage:   "https://example.com",
+		Repository: "https://git.example.com/a.git",
+		Watch:      []string{"https://example.com/releases/a-(.*)\\.tar\\.gz", "https://mirror.example.com/"},
+		MovedFrom:  "https://old.example.com",
+	}
+	db.RecordUpstream(a, expected)
+
+	actual, found := db.GetUpstream(a)
+	if !found || fmt.Sprint(actual) != fmt.Sprint(expected) {
+		t.Errorf("Unexpected upstream metadata\nGot %#v\nExp %#v", actual, expected)
+	}
+	list := db.ListDistroUpstream("sid")
+	if len(list) != 1 || fmt.Sprint(list["a"]) != fmt.Sprint(expected) {
+		t.Errorf("Unexpected distro upstream metadata: %#v", list)
+	}
+}
+
+func testConcurrency(t *testing.T, db *Database) {
+	// Writers and readers run in parallel on the connection pool
+	var wg sync.WaitGroup
+	for w := range 8 {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := range 50 {
+				var hash [32]byte
+				hash[0], hash[1] = byte(w), byte(i)
+				db.RecordHashes([][32]byte{hash})
+				db.DeduplicateFiles([]analysis.File{{SHA256: hash}})
+				pv := recordPackage(t, db, "sid", fmt.Sprintf("pkg%d", w), fmt.Sprint(i))
+				db.RecordLineCounts(pv, analysis.SLOCStats{Languages: map[string]analysis.LineCounts{
+					"C": {Files: 1, Code: int64(i)},
+				}})
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM files").Scan(&count); err != nil {
+		t.Fatal(err)
+	} else if count != 8*50 {
+		t.Errorf("Expected %d files, got %d", 8*50, count)
+	}
+}
+
+func testSnapshots(t *testing.T, db *Database) {
+	a1 := recordPackage(t, db, "sid", "a", "1")
+	b1 := recordPackage(t, db, "sid", "b", "1")
+	c1 := recordPackage(t, db, "sid", "c", "1")
+	db.UpdateDistroContents("sid", []PackageVersion{a1, b1, c1})
+	first := db.RecordSnapshot("sid", time.Date(2026, 9, 1, 12, 0, 0, 0, time.UTC))
+
+	a2 := recordPackage(t, db, "sid", "a", "2")
+	d1 := recordPackage(t, db, "sid", "d", "1")
+	db.UpdateDistroContents("sid", []PackageVersion{a2, b1, d1})
+	second := db.RecordSnapshot("sid", time.Date(2026, 9, 2, 12, 0, 0, 0, time.UTC))
+	third := db.RecordSnapshot("sid", time.Date(2026, 9, 3, 12, 0, 0, 0, time.UTC))
+
+	if first.Packages != 3 || first.Added != 3 || first.Removed != 0 || first.Updated != 0 {
+		t.Errorf("Unexpected first snapshot: %#v", first)
+	}
+	if second.Packages != 3 || second.Added != 1 || second.Removed != 1 || second.Updated != 1 {
+		t.Errorf("Unexpected second snapshot: %#v", second)
+	}
+	if third.Added != 0 || third.Removed != 0 || third.Updated != 0 {
+		t.Errorf("Unexpected third snapshot: %#v", third)
+	}
+
+	var contents = func(id int64) string {
+		var result []string
+		for _, pv := range db.ListSnapshotContents("sid", id) {
+			result = append(result, pv.Name+"="+pv.Version)
+		}
+		sort.Strings(result)
+		return fmt.Sprint(result)
+	}
+	if actual := contents(first.ID); actual != "[a=1 b=1 c=1]" {
+		t.Errorf("Unexpected contents of first snapshot: %s", actual)
+	}
+	if actual := contents(second.ID); actual != "[a=2 b=1 d=1]" {
+		t.Errorf("Unexpected contents of second snapshot: %s", actual)
+	}
+	if actual := contents(third.ID); actual != "[a=2 b=1 d=1]" {
+		t.Errorf("Unexpected contents of third snapshot: %s", actual)
+	}
+
+	snapshots := db.ListSnapshots("sid")
+	if len(snapshots) != 3 || snapshots[1] != second {
+		t.Errorf("Unexpected snapshots: %#v", snapshots)
+	}
+	if len(db.ListSnapshots("bookworm")) != 0 {
+		t.Errorf("Expected distros to be independent")
+	}
+
+	// Reprocessing a2 should diff against a1, not a2 itself
+	if prev, found := db.PreviousVersion("sid", "a", "2"); !found || prev != a1 {
+		t.Errorf("Unexpected previous version of a: %#v", prev)
+	}
+	if prev, found := db.PreviousVersion("sid", "b", "1"); found {
+		t.Errorf("Expected no previous version of b, got %#v", prev)
+	}
+}
+
+func testGarbageCollection(t *testing.T, db *Database) {
+	var h [7][32]byte
+	for i := range h {
+		h[i][0] = byte(i)
+	}
+	db.RecordHashes(h[1:])
+
+	a1 := recordPackage(t, db, "sid", "a", "1")
+	b1 := recordPackage(t, db, "sid", "b", "1")
+	db.RecordManifest(a1, [][32]byte{h[1], h[2], h[6]})
+	db.RecordManifest(b1, [][32]byte{h[3], h[4]})
+	db.RecordManifest(b1, [][32]byte{h[2], h[3], h[3]}) // reprocessed, replaces h[4]
+	db.UpdateDistroContents("sid", []PackageVersion{a1, b1})
+	first := db.RecordSnapshot("sid", time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	a2 := recordPackage(t, db, "sid", "a", "2")
+	db.RecordManifest(a2, [][32]byte{h[1], h[5]})
+	db.UpdateDistroContents("sid", []PackageVersion{a2, b1})
+	second := db.RecordSnapshot("sid", time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC))
+
+	var cutoff = time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	var names = func(pvs []PackageVersion) string {
+		var result []string
+		for _, pv := range pvs {
+			result = append(result, pv.Name+"="+pv.Version)
+		}
+		sort.Strings(result)
+		return fmt.Sprint(result)
+	}
+	if actual := names(db.RetainedPackageVersions(cutoff)["sid"]); actual != "[a=2 b=1]" {
+		t.Errorf("Unexpected retained package versions: %s", actual)
+	}
+
+	for _, dryRun := range []bool{true, false} {
+		garbage := db.CollectGarbage(cutoff, dryRun)
+		if actual := names(garbage.Retained["sid"]); actual != "[a=2 b=1]" {
+			t.Errorf("Unexpected retained package versions: %s", actual)
+		}
+		if actual := names(garbage.PackageVersions["sid"]); actual != "[a=1]" {
+			t.Errorf("Unexpected expired package versions: %s", actual)
+		}
+		if fmt.Sprint(garbage.Snapshots["sid"]) != fmt.Sprint([]int64{first.ID}) {
+			t.Errorf("Unexpected expired snapshots: %v", garbage.Snapshots)
+		}
+		sort.Slice(garbage.Hashes, func(i, j int) bool { return garbage.Hashes[i][0] < garbage.Hashes[j][0] })
+		if len(garbage.Hashes) != 2 || garbage.Hashes[0] != h[4] || garbage.Hashes[1] != h[6] {
+			t.Errorf("Unexpected garbage files: %x", garbage.Hashes)
+		}
+
+		var remaining = len(db.ListAllPackageVersions()["sid"])
+		if dryRun && remaining != 3 {
+			t.Errorf("Dry run deleted package versions")
+		} else if !dryRun && remaining != 2 {
+			t.Errorf("Expected expired package versions to be deleted, %d remain", remaining)
+		}
+	}
+
+	if deduped := db.DeduplicateFiles([]analysis.File{{SHA256: h[4]}, {SHA256: h[5]}}); len(deduped) != 1 || deduped[0].SHA256 != h[4] {
+		t.Errorf("Expected garbage files to be forgotten: %v", deduped)
+	}
+	if snapshots := db.ListSnapshots("sid"); len(snapshots) != 1 || snapshots[0].ID != second.ID {
+		t.Errorf("Unexpected snapshots after collection: %v", snapshots)
+	}
+	if actual := names(db.ListSnapshotContents("sid", second.ID)); actual != "[a=2 b=1]" {
+		t.Errorf("Unexpected snapshot contents after collection: %s", actual)
+	}
+
+	// If a package version has no manifest, files can't be collected
+	c1 := recordPackage(t, db, "sid", "c", "1")
+	db.UpdateDistroContents("sid", []PackageVersion{a2, b1, c1})
+	db.RecordHashes([][32]byte{h[4]})
+	garbage := db.CollectGarbage(cutoff, true)
+	if len(garbage.Unmanifested) != 1 || garbage.Unmanifested[0].ID != c1.ID || len(garbage.Hashes) != 0 {
+		t.Errorf("Expected no files to be collected: %#v", garbage)
+	}
+}
+
+func testEpochGarbageCollection(t *testing.T, db *Database) {
+	var h [4][32]byte
+	for i := range h {
+		h[i][0] = byte(i)
+	}
+	db.RecordHashes(h[1:])
+
+	a := recordPackage(t, db, "sid", "a", "1")
+	db.RecordManifest(a, [][32]byte{h[1], h[2]})
+	db.UpdateDistroContents("sid", []PackageVersion{a})
+	first := db.RecordSnapshot("sid", time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	// Reprocess at a new epoch. Unlike a new version, this doesn't count as an
+	// update, but the first snapshot still points to the old epoch.
+	if _, err := db.Exec("UPDATE package_versions SET sc_epoch = $1 WHERE id = $2", a.Epoch+1, a.ID); err != nil {
+		t.Fatal(err)
+	}
+	bumped := a
+	bumped.Epoch++
+	db.RecordManifest(bumped, [][32]byte{h[1], h[3]})
+	second := db.RecordSnapshot("sid", time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC))
+	if second.Updated != 0 {
+		t.Errorf("Expected a new epoch not to count as an update: %#v", second)
+	}
+	if actual := db.ListSnapshotContents("sid", first.ID); len(actual) != 1 || actual[0] != a {
+		t.Errorf("Unexpected contents of first snapshot: %#v", actual)
+	}
+	if actual := db.ListSnapshotContents("sid", second.ID); len(actual) != 1 || actual[0] != bumped {
+		t.Errorf("Unexpected contents of second snapshot: %#v", actual)
+	}
+
+	var epochs = func(pvs []PackageVersion) string {
+		var result []string
+		for _, pv := range pvs {
+			result = append(result, fmt.Sprintf("%s=%s:%d", pv.Name, pv.Version, pv.Epoch))
+		}
+		return fmt.Sprint(result)
+	}
+
+	// While the first snapshot is retained, so is the old epoch
+	garbage := db.CollectGarbage(time.Date(2025, 12, 1, 0, 0, 0, 0, time.UTC), true)
+	expected := fmt.Sprintf("[a=1:%d a=1:%d]", a.Epoch, bumped.Epoch)
+	if actual := epochs(garbage.Retained["sid"]); actual != expected {
+		t.Errorf("Unexpected retained package versions: %s", actual)
+	}
+	if len(garbage.Hashes) != 0 {
+		t.Errorf("Expected no garbage files: %x", garbage.Hashes)
+	}
+
+	// Once it expires, the old epoch's manifest and files are collected
+	garbage = db.CollectGarbage(time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), false)
+	expected = fmt.Sprintf("[a=1:%d]", bumped.Epoch)
+	if actual := epochs(garbage.Retained["sid"]); actual != expected {
+		t.Errorf("Unexpected retained package versions: %s", actual)
+	}
+	if len(garbage.PackageVersions["sid"]) != 0 || len(garbage.Unmanifested) != 0 {
+		t.Errorf("Unexpected garbage: %#v", garbage)
+	}
+	if len(garbage.Hashes) != 1 || garbage.Hashes[0] != h[2] {
+		t.Errorf("Expected the old epoch's files to be collected: %x", garbage.Hashes)
+	}
+	manifests := db.ListManifests()
+	if manifests[ManifestKey{a.ID, a.Epoch}] || !manifests[ManifestKey{a.ID, bumped.Epoch}] {
+		t.Errorf("Expected only the old epoch's manifest to be removed: %v", manifests)
+	}
+}
+
+func testAttempts(t *testing.T, db *Database) {
+	if _, found := db.LastRun(); found {
+		t.Errorf("Expected no runs")
+	}
+	var start = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	run := db.StartRun(start)
+
+	var attempt = func(name, version, errText string, at time.Time) {
+		db.RecordAttempt(PackageAttempt{
+			Run: run, Distro: "sid", Name: name, Version: version,
+			StartedAt: at, Duration: 1500 * time.Millisecond, Stage: "ctags", Error: errText,
+		})
+	}
+	attempt("a", "1", "boom", start)
+	attempt("a", "1", "boom", start.Add(time.Hour))
+	attempt("b", "1", "boom", start)
+	attempt("b", "1", "", start.Add(time.Hour))
+	attempt("c", "1", "boom", start)
+	attempt("c", "2", "bang", start.Add(time.Hour))
+	attempt("d", "1", "", start)
+
+	failures := db.ListFailures("sid")
+	if len(failures) != 2 {
+		t.Errorf("Expected 2 failures, got %#v", failures)
+	}
+	if f := failures["a"]; f.Failures != 2 || !f.FirstFailed.Equal(start) ||
+		!f.StartedAt.Equal(start.Add(time.Hour)) || f.Duration != 1500*time.Millisecond ||
+		f.Stage != "ctags" || f.Error != "boom" {
+		t.Errorf("Unexpected failure: %#v", f)
+	}
+	if f := failures["c"]; f.Failures != 1 || f.Version != "2" || f.Error != "bang" {
+		t.Errorf("Unexpected failure: %#v", f)
+	}
+	if len(db.ListFailures("bookworm")) != 0 {
+		t.Errorf("Expected no failures in another distro")
+	}
+
+	// Attempts before a success are pruned
+	var count int
+	if err := db.QueryRow(
+		"SELECT COUNT(*) FROM package_attempts WHERE distro = 'sid' AND pkg_name = 'b'",
+	).Scan(&count); err != nil {
+		t.Fatal(err)
+	} else if count != 1 {
+		t.Errorf("Expected 1 attempt at b, got %d", count)
+	}
+	attempt("a", "1", "", start.Add(2*time.Hour))
+	attempt("a", "1", "boom", start.Add(3*time.Hour))
+	if f := db.ListFailures("sid")["a"]; f.Failures != 1 || !f.FirstFailed.Equal(start.Add(3*time.Hour)) {
+		t.Errorf("Expected failures before a success to be forgotten: %#v", f)
+	}
+
+	if last, found := db.LastRun(); !found || last.ID != run || !last.FinishedAt.IsZero() {
+		t.Errorf("Unexpected last run: %#v", last)
+	}
+	db.FinishRun(run, start.Add(2*time.Hour), true)
+	if last, _ := db.LastRun(); !last.Errored || !last.FinishedAt.Equal(start.Add(2*time.Hour)) {
+		t.Errorf("Unexpected last run: %#v", last)
+	}
+}
+
+func testSharing(t *testing.T, db *Database) {
+	a := recordPackage(t, db, "sid", "a", "1")
+	recordPackage(t, db, "sid", "b", "1")
+	recordPackage(t, db, "bookworm", "a", "1")
+	db.RecordLineCounts(a, analysis.SLOCStats{Languages: map[string]analysis.LineCounts{
+		"C": {Files: 2, Code: 100, Comment: 10, Blank: 5},
+	}})
+	upstream := analysis.Upstream{Homepage: "https://example.com", Watch: []string{"https://example.com/"}}
+	db.RecordUpstream(a, upstream)
+	db.RecordManifest(a, [][32]byte{{1}, {2}})
+
+	shared := db.ListSharedPackages([]string{"sid", "bookworm"}, map[string]apt.Package{
+		"a": {Name: "a", Version: "1"},
+		"b": {Name: "b", Version: "2"},
+		"c": {Name: "c", Version: "1"},
+	})
+	if len(shared) != 1 || shared["a"].Distro != "bookworm" || shared["a"].Version != "1" {
+		t.Errorf("Unexpected shared packages: %#v", shared)
+	}
+	if shared := db.ListSharedPackages([]string{"sid"}, map[string]apt.Package{"a": {Name: "a", Version: "1"}}); shared["a"].Distro != "sid" {
+		t.Errorf("Expected packages to be shared from peers only: %#v", shared)
+	}
+	if shared := db.ListSharedPackages(nil, map[string]apt.Package{"a": {Name: "a", Version: "1"}}); len(shared) != 0 {
+		t.Errorf("Expected no packages to be shared without peers: %#v", shared)
+	}
+
+	// Copy from sid, which has the metadata, twice to check it's replaced
+	var pv PackageVersion
+	for range 2 {
+		pv = db.CopyPackageVersion(SharedVersion{"sid", a}, "trixie")
+	}
+	if pv.ID == a.ID || pv.Name != "a" || pv.Version != "1" || pv.Epoch != a.Epoch {
+		t.Errorf("Unexpected copied package version: %#v", pv)
+	}
+	db.UpdateDistroContents("trixie", []PackageVersion{pv})
+	if actual := db.ListDistroContents("trixie"); len(actual) != 1 || actual[0] != pv {
+		t.Errorf("Unexpected distro contents: %#v", actual)
+	}
+	if actual, _ := db.GetUpstream(pv); fmt.Sprint(actual) != fmt.Sprint(upstream) {
+		t.Errorf("Unexpected upstream metadata: %#v", actual)
+	}
+	if sloc := db.AggregateLineCounts("trixie"); sloc.Total.Code != 100 {
+		t.Errorf("Unexpected line counts: %#v", sloc)
+	}
+	if !db.ListManifests()[ManifestKey{pv.ID, pv.Epoch}] {
+		t.Errorf("Expected manifest to be copied")
+	}
+	if b := db.CopyPackageVersion(shared["a"], "experimental"); db.ListManifests()[ManifestKey{b.ID, b.Epoch}] {
+		t.Errorf("Expected no manifest to be copied from a package version without one")
+	}
+	var files int
+	if err := db.QueryRow("SELECT COUNT(*) FROM manifest_files WHERE package_version = $1", pv.ID).Scan(&files); err != nil || files != 2 {
+		t.Errorf("Expected 2 manifest files, got %d (%v)", files, err)
+	}
+}
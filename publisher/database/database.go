@@ -2,44 +2,39 @@ package database
 
 import (
 	"database/sql"
-	"errors"
-	"os"
-	"sync"
-
-	_ "embed"
-
-	_ "github.com/mattn/go-sqlite3"
 )
 
+// A Database is a pool of connections to the publisher's database. It's safe
+// for concurrent use.
 type Database struct {
 	*sql.DB
 	batchSize int
-	mutex     sync.Mutex
+	driver    Driver
 }
 
-//go:embed schema.sql
-var create string
-
-func Connect(filename string, batchSize int) (*Database, error) {
-	_, err := os.Stat(filename)
-	first := errors.Is(err, os.ErrNotExist)
-
-	db, err := sql.Open("sqlite3", filename)
+// Connect opens the database, creating the schema if it's empty or migrating it
+// to the latest schema version if it's out of date. Before migrating, a backup
+// of the database is taken (see Driver).
+func Connect(driver Driver, batchSize int) (*Database, error) {
+	db, err := driver.open()
 	if err != nil {
 		return nil, err
 	}
 
-	if first {
-		_, err = db.Exec(create)
-		if err != nil {
-			return nil, err
-		}
-	}
-
 	err = db.Ping()
+	if err == nil {
+		err = migrate(db, driver)
+	}
 	if err != nil {
 		db.Close()
 		return nil, err
 	}
-	return &Database{db, batchSize, sync.Mutex{}}, nil
+	return &Database{db, batchSize, driver}, nil
+}
+
+// A querier is a *sql.DB or a *sql.Tx.
+type querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
 }
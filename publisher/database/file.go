@@ -1,24 +1,19 @@
 package database
 
 import (
-	"encoding/binary"
 	"fmt"
 
 	"github.com/btidor/src.codes/publisher/analysis"
 )
 
 func (db *Database) DeduplicateFiles(files []analysis.File) []analysis.File {
-	db.mutex.Lock()
-	defer db.mutex.Unlock()
-
 	var deduped []analysis.File
 	for i := 0; i < len(files); i += db.batchSize {
 		var values []any
-		var query string = "SELECT DISTINCT short_hash FROM files" +
-			" WHERE short_hash IN ("
+		var query string = "SELECT hash FROM files WHERE hash IN ("
 		var n int = 1
 		for j := i; j < i+db.batchSize && j < len(files); j++ {
-			values = append(values, convertHash(files[j].SHA256))
+			values = append(values, files[j].SHA256[:])
 			query += fmt.Sprintf("$%d, ", n)
 			n++
 		}
@@ -29,10 +24,10 @@ func (db *Database) DeduplicateFiles(files []analysis.File) []analysis.File {
 			panic(err)
 		}
 
-		existing := make(map[int64]bool, db.batchSize)
+		existing := make(map[[32]byte]bool, db.batchSize)
 		for rows.Next() {
-			var hash int64
-			if err := rows.Scan(&hash); err != nil {
+			hash, err := scanHash(rows)
+			if err != nil {
 				rows.Close()
 				panic(err)
 			}
@@ -40,8 +35,7 @@ func (db *Database) DeduplicateFiles(files []analysis.File) []analysis.File {
 		}
 
 		for j := i; j < i+db.batchSize && j < len(files); j++ {
-			hash := convertHash(files[j].SHA256)
-			if _, found := existing[hash]; !found {
+			if _, found := existing[files[j].SHA256]; !found {
 				deduped = append(deduped, files[j])
 			}
 		}
@@ -54,25 +48,100 @@ func (db *Database) RecordHashes(hashes [][32]byte) {
 	if len(hashes) < 1 {
 		return
 	}
-	db.mutex.Lock()
-	defer db.mutex.Unlock()
-
 	var values []any
-	var query string = "INSERT INTO files (short_hash) VALUES "
+	var query string = "INSERT INTO files (hash) VALUES "
 	for i, hash := range hashes {
-		values = append(values, convertHash(hash))
+		values = append(values, hash[:])
 		query += fmt.Sprintf("($%d), ", i+1)
 	}
 
 	query = query[:len(query)-2] +
-		" ON CONFLICT (short_hash) DO NOTHING"
+		" ON CONFLICT (hash) DO NOTHING"
 	_, err := db.Exec(query, values...)
 	if err != nil {
 		panic(err)
 	}
 }
 
-func convertHash(hash [32]byte) int64 {
-	v := binary.LittleEndian.Uint64(hash[:8])
-	return int64(v)
+// ForgetHashes removes hashes from the files table, so that the files will be
+// uploaded again the next time they're seen.
+func (db *Database) ForgetHashes(hashes [][32]byte) {
+	if len(hashes) < 1 {
+		return
+	}
+	var values []any
+	var query string = "DELETE FROM files WHERE hash IN ("
+	for i, hash := range hashes {
+		values = append(values, hash[:])
+		query += fmt.Sprintf("$%d, ", i+1)
+	}
+	query = query[:len(query)-2] + ")"
+	if _, err := db.Exec(query, values...); err != nil {
+		panic(err)
+	}
+}
+
+// FindHashCollisions returns groups of recorded hashes that share the same
+// first 64 bits. Before the files table held full hashes, only one file from
+// each group would have been uploaded.
+func (db *Database) FindHashCollisions() [][][32]byte {
+	rows, err := db.Query(
+		"SELECT hash FROM files WHERE substr(hash, 1, 8) IN (" +
+			" SELECT substr(hash, 1, 8) AS prefix FROM files" +
+			" GROUP BY prefix HAVING COUNT(*) > 1" +
+			") ORDER BY hash",
+	)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	var groups [][][32]byte
+	for rows.Next() {
+		hash, err := scanHash(rows)
+		if err != nil {
+			panic(err)
+		}
+		var n = len(groups)
+		if n > 0 && [8]byte(groups[n-1][0][:8]) == [8]byte(hash[:8]) {
+			groups[n-1] = append(groups[n-1], hash)
+		} else {
+			groups = append(groups, [][32]byte{hash})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		panic(err)
+	}
+	return groups
+}
+
+// NeedsHashBackfill reports whether the database has 64-bit file hashes left
+// over from an older version of the publisher. If so, the files table must be
+// repopulated with full hashes from the published trees, then the old hashes
+// can be dropped with FinishHashBackfill.
+func (db *Database) NeedsHashBackfill() bool {
+	exists, err := db.driver.tableExists(db.DB, "legacy_files")
+	if err != nil {
+		panic(err)
+	}
+	return exists
+}
+
+// FinishHashBackfill drops the old 64-bit file hashes.
+func (db *Database) FinishHashBackfill() {
+	if _, err := db.Exec("DROP TABLE IF EXISTS legacy_files"); err != nil {
+		panic(err)
+	}
+}
+
+func scanHash(row interface{ Scan(...any) error }) ([32]byte, error) {
+	var hash [32]byte
+	var raw []byte
+	if err := row.Scan(&raw); err != nil {
+		return hash, err
+	} else if len(raw) != len(hash) {
+		return hash, fmt.Errorf("invalid hash in files table: %x", raw)
+	}
+	copy(hash[:], raw)
+	return hash, nil
 }
@@ -0,0 +1,287 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Garbage is what CollectGarbage found to be no longer needed.
+type Garbage struct {
+	// Retained lists the package versions that are still referenced, by
+	// distro, once for each epoch that's still referenced. Their indexes must
+	// be kept at those epochs; other epochs can be deleted.
+	Retained map[string][]PackageVersion
+
+	// Expired package versions and snapshots, by distro. These have been
+	// removed from the database, and their objects can be deleted.
+	PackageVersions map[string][]PackageVersion
+	Snapshots       map[string][]int64
+
+	// Hashes lists the files that aren't in the manifest of any retained
+	// package version and epoch. These have been removed from the files table,
+	// and their objects can be deleted.
+	Hashes [][32]byte
+
+	// Unmanifested lists retained package versions with no manifest at a
+	// retained epoch. If there are any, we can't tell which files are still
+	// referenced, so no files are collected.
+	Unmanifested []PackageVersion
+}
+
+// RetainedPackageVersions lists the package versions that are in the current
+// contents of a distribution or in one of its retained snapshots, by distro.
+// A package version is listed once for each epoch it's retained at. Snapshots
+// are retained if they were taken since the cutoff; the latest snapshot of each
+// distribution is always retained.
+func (db *Database) RetainedPackageVersions(cutoff time.Time) map[string][]PackageVersion {
+	_, retained := findRetained(db.DB, cutoff)
+	var result = make(map[string][]PackageVersion)
+	for distro, pvs := range listAllPackageVersions(db.DB) {
+		for _, pv := range pvs {
+			result[distro] = append(result[distro], retainedEpochs(pv, retained[pv.ID])...)
+		}
+	}
+	return result
+}
+
+// retainedEpochs returns a copy of the package version for each epoch it's
+// retained at, in order.
+func retainedEpochs(pv PackageVersion, epochs map[int]bool) []PackageVersion {
+	var result []PackageVersion
+	for epoch := range epochs {
+		var copy = pv
+		copy.Epoch = epoch
+		result = append(result, copy)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Epoch < result[j].Epoch })
+	return result
+}
+
+// CollectGarbage removes expired snapshots, package versions and epochs from
+// the database, along with the hashes of files that are no longer referenced
+// (see RetainedPackageVersions). It returns what was removed, so that the caller can
+// delete the corresponding objects from storage. In a dry run, the changes are
+// rolled back.
+//
+// This must not run concurrently with the publisher, which records files before
+// the package version that references them.
+func (db *Database) CollectGarbage(cutoff time.Time, dryRun bool) Garbage {
+	tx, err := db.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	var garbage = Garbage{
+		Retained:        make(map[string][]PackageVersion),
+		PackageVersions: make(map[string][]PackageVersion),
+		Snapshots:       make(map[string][]int64),
+	}
+	oldest, retained := findRetained(tx, cutoff)
+	manifests := listManifests(tx)
+
+	var expired []any
+	for distro, pvs := range listAllPackageVersions(tx) {
+		for _, pv := range pvs {
+			epochs, found := retained[pv.ID]
+			if !found {
+				garbage.PackageVersions[distro] = append(garbage.PackageVersions[distro], pv)
+				expired = append(expired, pv.ID)
+				continue
+			}
+			for _, pv := range retainedEpochs(pv, epochs) {
+				garbage.Retained[distro] = append(garbage.Retained[distro], pv)
+				if !manifests[ManifestKey{pv.ID, pv.Epoch}] {
+					garbage.Unmanifested = append(garbage.Unmanifested, pv)
+				}
+			}
+		}
+	}
+
+	rows, err := tx.Query("SELECT distro, id FROM snapshots ORDER BY id")
+	if err != nil {
+		panic(err)
+	}
+	var snapshots []any
+	for rows.Next() {
+		var distro string
+		var id int64
+		if err := rows.Scan(&distro, &id); err != nil {
+			panic(err)
+		}
+		if id < oldest[distro] {
+			garbage.Snapshots[distro] = append(garbage.Snapshots[distro], id)
+			snapshots = append(snapshots, id)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		panic(err)
+	}
+	rows.Close()
+
+	// Delete expired snapshots, and the contents that ended before the oldest
+	// retained snapshot
+	db.execBatched(tx, "DELETE FROM snapshots WHERE id IN (", snapshots)
+	for distro, id := range oldest {
+		_, err := tx.Exec(
+			"DELETE FROM snapshot_contents WHERE distro = $1 AND until_snapshot <= $2",
+			distro, id,
+		)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	// Delete expired package versions and everything recorded about them
+	for _, prefix := range []string{
+		"DELETE FROM package_versions WHERE id IN (",
+		"DELETE FROM line_counts WHERE package_version IN (",
+		"DELETE FROM upstream_metadata WHERE package_version IN (",
+		"DELETE FROM manifest_files WHERE package_version IN (",
+		"DELETE FROM manifests WHERE package_version IN (",
+	} {
+		db.execBatched(tx, prefix, expired)
+	}
+
+	// And the manifests of superseded epochs of the package versions that are
+	// still retained
+	for key := range manifests {
+		if epochs, found := retained[key.PackageVersion]; found && !epochs[key.Epoch] {
+			if err := deleteManifest(tx, key); err != nil {
+				panic(err)
+			}
+		}
+	}
+
+	// Then any files that are no longer in a manifest
+	if len(garbage.Unmanifested) == 0 {
+		rows, err := tx.Query(
+			"SELECT hash FROM files f WHERE NOT EXISTS" +
+				" (SELECT 1 FROM manifest_files mf WHERE mf.hash = f.hash)",
+		)
+		if err != nil {
+			panic(err)
+		}
+		var hashes []any
+		for rows.Next() {
+			hash, err := scanHash(rows)
+			if err != nil {
+				panic(err)
+			}
+			garbage.Hashes = append(garbage.Hashes, hash)
+			hashes = append(hashes, hash[:])
+		}
+		if err := rows.Err(); err != nil {
+			panic(err)
+		}
+		rows.Close()
+		db.execBatched(tx, "DELETE FROM files WHERE hash IN (", hashes)
+	}
+
+	if !dryRun {
+		if err := tx.Commit(); err != nil {
+			panic(err)
+		}
+	}
+	return garbage
+}
+
+// findRetained determines the oldest retained snapshot of each distribution,
+// and the epochs at which each package version is retained, by ID.
+func findRetained(q querier, cutoff time.Time) (map[string]int64, map[int64]map[int]bool) {
+	var oldest = make(map[string]int64)
+	rows, err := q.Query(
+		"SELECT distro, MIN(id) FROM snapshots s WHERE taken_at >= $1"+
+			" OR id = (SELECT MAX(id) FROM snapshots WHERE distro = s.distro)"+
+			" GROUP BY distro",
+		cutoff.UTC().Format(time.RFC3339),
+	)
+	if err != nil {
+		panic(err)
+	}
+	for rows.Next() {
+		var distro string
+		var id int64
+		if err := rows.Scan(&distro, &id); err != nil {
+			panic(err)
+		}
+		oldest[distro] = id
+	}
+	if err := rows.Err(); err != nil {
+		panic(err)
+	}
+	rows.Close()
+
+	var retained = make(map[int64]map[int]bool)
+	var retain = func(id int64, epoch int) {
+		if retained[id] == nil {
+			retained[id] = make(map[int]bool)
+		}
+		// An invalidated package version has epoch 0 until it's reprocessed,
+		// and no indexes at that epoch
+		if epoch != 0 {
+			retained[id][epoch] = true
+		}
+	}
+
+	rows, err = q.Query(
+		"SELECT pv.id, pv.sc_epoch FROM distribution_contents dc" +
+			" JOIN package_versions pv ON dc.current = pv.id",
+	)
+	if err != nil {
+		panic(err)
+	}
+	for rows.Next() {
+		var id int64
+		var epoch int
+		if err := rows.Scan(&id, &epoch); err != nil {
+			panic(err)
+		}
+		retain(id, epoch)
+	}
+	if err := rows.Err(); err != nil {
+		panic(err)
+	}
+	rows.Close()
+
+	// A package version is in a retained snapshot if it was still current at
+	// the oldest one
+	rows, err = q.Query("SELECT distro, package_version, sc_epoch, until_snapshot FROM snapshot_contents")
+	if err != nil {
+		panic(err)
+	}
+	for rows.Next() {
+		var distro string
+		var id int64
+		var epoch int
+		var until *int64
+		if err := rows.Scan(&distro, &id, &epoch, &until); err != nil {
+			panic(err)
+		}
+		if first, found := oldest[distro]; found && (until == nil || *until > first) {
+			retain(id, epoch)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		panic(err)
+	}
+	rows.Close()
+	return oldest, retained
+}
+
+// execBatched runs a statement of the form "... IN (" once per batch of values.
+func (db *Database) execBatched(q querier, prefix string, values []any) {
+	for i := 0; i < len(values); i += db.batchSize {
+		var query = prefix
+		var n int = 1
+		for j := i; j < i+db.batchSize && j < len(values); j++ {
+			query += fmt.Sprintf("$%d, ", n)
+			n++
+		}
+		query = query[:len(query)-2] + ")"
+		if _, err := q.Exec(query, values[i:min(i+db.batchSize, len(values))]...); err != nil {
+			panic(err)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package database
+
+import (
+	"fmt"
+)
+
+// A ManifestKey identifies the manifest of a package version at one epoch.
+// Each epoch has its own manifest, since snapshots may still point to the tree
+// from an earlier epoch.
+type ManifestKey struct {
+	PackageVersion int64
+	Epoch          int
+}
+
+// RecordManifest stores the hashes of the files in a package version at its
+// epoch, replacing any manifest recorded for that epoch before. Duplicates are
+// ignored.
+func (db *Database) RecordManifest(pv PackageVersion, hashes [][32]byte) {
+	var unique = make(map[[32]byte]bool, len(hashes))
+	var deduped [][32]byte
+	for _, hash := range hashes {
+		if !unique[hash] {
+			unique[hash] = true
+			deduped = append(deduped, hash)
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		"DELETE FROM manifest_files WHERE package_version = $1 AND sc_epoch = $2",
+		pv.ID, pv.Epoch,
+	)
+	if err != nil {
+		panic(err)
+	}
+	for i := 0; i < len(deduped); i += db.batchSize {
+		var values []any
+		var query string = "INSERT INTO manifest_files (package_version, sc_epoch, hash) VALUES "
+		var n int = 1
+		for j := i; j < i+db.batchSize && j < len(deduped); j++ {
+			values = append(values, pv.ID, pv.Epoch, deduped[j][:])
+			query += fmt.Sprintf("($%d, $%d, $%d), ", n, n+1, n+2)
+			n += 3
+		}
+		query = query[:len(query)-2]
+		if _, err := tx.Exec(query, values...); err != nil {
+			panic(err)
+		}
+	}
+
+	if err := updateManifestCount(tx, ManifestKey{pv.ID, pv.Epoch}); err != nil {
+		panic(err)
+	}
+	if err := tx.Commit(); err != nil {
+		panic(err)
+	}
+}
+
+// updateManifestCount marks a package version as having a manifest at the
+// given epoch, and records the number of files in it.
+func updateManifestCount(q querier, key ManifestKey) error {
+	_, err := q.Exec(
+		"INSERT INTO manifests (package_version, sc_epoch, files)"+
+			" SELECT CAST($1 AS BIGINT), CAST($2 AS INT), COUNT(*) FROM manifest_files"+
+			" WHERE package_version = $1 AND sc_epoch = $2"+
+			" ON CONFLICT (package_version, sc_epoch) DO UPDATE SET files = EXCLUDED.files",
+		key.PackageVersion, key.Epoch,
+	)
+	return err
+}
+
+// deleteManifest removes a package version's manifest at the given epoch.
+func deleteManifest(q querier, key ManifestKey) error {
+	for _, table := range []string{"manifest_files", "manifests"} {
+		_, err := q.Exec(
+			"DELETE FROM "+table+" WHERE package_version = $1 AND sc_epoch = $2",
+			key.PackageVersion, key.Epoch,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListManifests lists the manifests that have been recorded.
+func (db *Database) ListManifests() map[ManifestKey]bool {
+	return listManifests(db.DB)
+}
+
+func listManifests(q querier) map[ManifestKey]bool {
+	rows, err := q.Query("SELECT package_version, sc_epoch FROM manifests")
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	var result = make(map[ManifestKey]bool)
+	for rows.Next() {
+		var key ManifestKey
+		if err := rows.Scan(&key.PackageVersion, &key.Epoch); err != nil {
+			panic(err)
+		}
+		result[key] = true
+	}
+	if err := rows.Err(); err != nil {
+		panic(err)
+	}
+	return result
+}
@@ -0,0 +1,179 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Migrations are numbered SQL files in each driver's migrations/ directory,
+// applied in order, each in its own transaction. For SQLite, migration 1 is the
+// schema as it was before versioning was introduced. Once a migration has been
+// released it must not be edited; add a new one instead, and update the
+// drivers' schema.sql to match.
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+// LatestVersion is the schema version created by schema.sql. SQLite has the
+// complete history, so its last migration determines the version.
+var LatestVersion = func() int {
+	m := SQLite("").migrations()
+	return m[len(m)-1].version
+}()
+
+// loadMigrations reads the migrations from the given directory, which may not
+// exist if the driver doesn't have any yet.
+func loadMigrations(dir string) []migration {
+	entries, err := driverFiles.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		panic(err)
+	}
+
+	var result []migration
+	for _, entry := range entries {
+		num, _, found := strings.Cut(entry.Name(), "_")
+		version, err := strconv.Atoi(num)
+		if !found || err != nil {
+			panic(fmt.Errorf("invalid migration filename: %s", entry.Name()))
+		}
+		result = append(result, migration{
+			version, entry.Name(), readDriverFile(path.Join(dir, entry.Name())),
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].version < result[j].version
+	})
+	for i, m := range result {
+		if i > 0 && m.version != result[i-1].version+1 {
+			panic(fmt.Errorf("migrations are not numbered consecutively: %s", m.name))
+		}
+	}
+	return result
+}
+
+// migrate brings the database up to the latest schema version, installing the
+// schema from scratch if the database is empty.
+func migrate(db *sql.DB, driver Driver) error {
+	current, err := schemaVersion(db, driver)
+	if err != nil {
+		return err
+	} else if current == 0 {
+		return install(db, driver)
+	}
+	return migrateTo(db, driver, LatestVersion)
+}
+
+// install creates the latest schema in an empty database.
+func install(db *sql.DB, driver Driver) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(driver.schema()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO schema_version (version) VALUES ($1)", LatestVersion); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateTo(db *sql.DB, driver Driver, target int) error {
+	current, err := schemaVersion(db, driver)
+	if err != nil {
+		return err
+	} else if current > target {
+		return fmt.Errorf("database is at schema version %d, newer than this publisher (%d)", current, target)
+	} else if current == target {
+		return nil
+	}
+
+	if current == 0 {
+		// Building up from nothing, e.g. in tests
+		if _, err := db.Exec("CREATE TABLE schema_version (version INT NOT NULL)"); err != nil {
+			return err
+		}
+		if _, err := db.Exec("INSERT INTO schema_version (version) VALUES (0)"); err != nil {
+			return err
+		}
+	} else {
+		backup, err := driver.backup(db, current)
+		if err != nil {
+			return fmt.Errorf("backup before migrating: %w", err)
+		}
+		log.Printf("Backed up database to %s\n", backup)
+	}
+
+	var version = current
+	for _, m := range driver.migrations() {
+		if m.version <= current || m.version > target {
+			continue
+		}
+		log.Printf("Applying migration %s\n", m.name)
+		if err := applyMigration(db, m); err != nil {
+			return fmt.Errorf("migration %s: %w", m.name, err)
+		}
+		version = m.version
+	}
+	if version != target {
+		return fmt.Errorf("no migrations from schema version %d to %d", version, target)
+	}
+	return nil
+}
+
+func applyMigration(db *sql.DB, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(m.sql); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE schema_version SET version = $1", m.version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// schemaVersion returns the database's schema version, or zero if it's empty.
+// Databases from before the schema_version table existed are assigned a version
+// by the driver.
+func schemaVersion(db *sql.DB, driver Driver) (int, error) {
+	if exists, err := driver.tableExists(db, "schema_version"); err != nil {
+		return 0, err
+	} else if exists {
+		var version int
+		err := db.QueryRow("SELECT version FROM schema_version").Scan(&version)
+		return version, err
+	}
+
+	if exists, err := driver.tableExists(db, "package_versions"); err != nil || !exists {
+		return 0, err
+	}
+	version, err := driver.legacyVersion(db)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := db.Exec("CREATE TABLE schema_version (version INT NOT NULL)"); err != nil {
+		return 0, err
+	}
+	if _, err := db.Exec("INSERT INTO schema_version (version) VALUES ($1)", version); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
@@ -0,0 +1,132 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// describeSchema summarizes the tables, columns and indexes in a database, so
+// that schemas can be compared without regard to formatting and comments.
+func describeSchema(t *testing.T, db *sql.DB) string {
+	t.Helper()
+	rows, err := db.Query(
+		"SELECT t.name, t.wr, c.name, c.type, c.\"notnull\", c.pk" +
+			" FROM pragma_table_list t, pragma_table_info(t.name) c" +
+			" WHERE t.schema = 'main' AND t.name NOT LIKE 'sqlite_%'" +
+			" ORDER BY t.name, c.cid",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for rows.Next() {
+		var table, column, typ string
+		var withoutRowid, notNull, pk int
+		if err := rows.Scan(&table, &withoutRowid, &column, &typ, &notNull, &pk); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, fmt.Sprintf("table %s (wr=%d): %s %s notnull=%d pk=%d",
+			table, withoutRowid, column, typ, notNull, pk))
+	}
+	rows.Close()
+
+	rows, err = db.Query(
+		"SELECT m.tbl_name, i.name, i.\"unique\", c.name" +
+			" FROM sqlite_master m, pragma_index_list(m.tbl_name) i, pragma_index_info(i.name) c" +
+			" WHERE m.type = 'table' AND i.origin = 'c'" +
+			" ORDER BY m.tbl_name, i.name, c.seqno",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var table, index, column string
+		var unique int
+		if err := rows.Scan(&table, &index, &unique, &column); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, fmt.Sprintf("index %s on %s (unique=%d): %s", index, table, unique, column))
+	}
+	rows.Close()
+	return strings.Join(lines, "\n")
+}
+
+func TestMigrateFromV1(t *testing.T) {
+	dir := t.TempDir()
+
+	fresh, err := Connect(SQLite(filepath.Join(dir, "fresh.db")), 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fresh.Close()
+
+	filename := filepath.Join(dir, "old.db")
+	raw, err := SQLite(filename).open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateTo(raw, SQLite(filename), 1); err != nil {
+		t.Fatal(err)
+	}
+	raw.Close()
+
+	migrated, err := Connect(SQLite(filename), 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer migrated.Close()
+	if _, err := os.Stat(filename + ".v1.bak"); err != nil {
+		t.Errorf("Expected backup before migrating: %s", err)
+	}
+
+	// Migration 4 leaves the old hashes around until they've been backfilled
+	if !migrated.NeedsHashBackfill() {
+		t.Errorf("Expected legacy hashes after migrating from v1")
+	}
+	migrated.FinishHashBackfill()
+
+	expected := describeSchema(t, fresh.DB)
+	actual := describeSchema(t, migrated.DB)
+	if actual != expected {
+		t.Errorf("Migrated schema differs from fresh install\nGot:\n%s\n\nExp:\n%s", actual, expected)
+	}
+
+	var version int
+	if err := migrated.QueryRow("SELECT version FROM schema_version").Scan(&version); err != nil {
+		t.Fatal(err)
+	} else if version != LatestVersion {
+		t.Errorf("Expected schema version %d, got %d", LatestVersion, version)
+	}
+}
+
+func TestUnversionedDatabase(t *testing.T) {
+	// A database created from the original schema.sql, before schema
+	// versioning, should be detected as version 1 and migrated.
+	filename := filepath.Join(t.TempDir(), "old.db")
+	raw, err := SQLite(filename).open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range SQLite(filename).migrations()[:1] {
+		if _, err := raw.Exec(m.sql); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := raw.Exec("INSERT INTO files (short_hash) VALUES (42)"); err != nil {
+		t.Fatal(err)
+	}
+	raw.Close()
+
+	db, err := Connect(SQLite(filename), 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if !db.NeedsHashBackfill() {
+		t.Errorf("Expected legacy hashes to be preserved")
+	}
+}
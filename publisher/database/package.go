@@ -16,9 +16,6 @@ type PackageVersion struct {
 }
 
 func (db *Database) RecordPackageVersion(a analysis.Archive) PackageVersion {
-	db.mutex.Lock()
-	defer db.mutex.Unlock()
-
 	_, err := db.Exec(
 		"INSERT INTO package_versions (distro, pkg_name, pkg_version, sc_epoch)"+
 			" VALUES ($1, $2, $3, $4)"+
@@ -50,9 +47,6 @@ func (db *Database) RecordPackageVersion(a analysis.Archive) PackageVersion {
 }
 
 func (db *Database) ListExistingPackages(distro string, pkgs map[string]apt.Package) map[string]PackageVersion {
-	db.mutex.Lock()
-	defer db.mutex.Unlock()
-
 	var plist []apt.Package
 	for _, pkg := range pkgs {
 		plist = append(plist, pkg)
@@ -91,9 +85,6 @@ func (db *Database) ListExistingPackages(distro string, pkgs map[string]apt.Pack
 }
 
 func (db *Database) ListDistroContents(distro string) []PackageVersion {
-	db.mutex.Lock()
-	defer db.mutex.Unlock()
-
 	rows, err := db.Query(
 		"SELECT pv.id, pv.pkg_name, pv.pkg_version, pv.sc_epoch"+
 			" FROM distribution_contents dc"+
@@ -118,13 +109,10 @@ func (db *Database) ListDistroContents(distro string) []PackageVersion {
 }
 
 func (db *Database) UpdateDistroContents(distro string, pvs []PackageVersion) {
-	db.mutex.Lock()
-	defer db.mutex.Unlock()
-
 	// Ensure all packages are present in database
 	for i := 0; i < len(pvs); i += db.batchSize {
 		var values []any
-		var query string = "REPLACE INTO distribution_contents" +
+		var query string = "INSERT INTO distribution_contents" +
 			" (distro, pkg_name, current) VALUES "
 		var n int = 1
 		for j := i; j < i+db.batchSize && j < len(pvs); j++ {
@@ -134,7 +122,8 @@ func (db *Database) UpdateDistroContents(distro string, pvs []PackageVersion) {
 			query += fmt.Sprintf("($%d, $%d, $%d), ", n, n+1, n+2)
 			n += 3
 		}
-		query = query[:len(query)-2]
+		query = query[:len(query)-2] +
+			" ON CONFLICT (distro, pkg_name) DO UPDATE SET current = EXCLUDED.current"
 		_, err := db.Exec(query, values...)
 		if err != nil {
 			panic(err)
@@ -149,13 +138,11 @@ func (db *Database) UpdateDistroContents(distro string, pvs []PackageVersion) {
 	}
 
 	var toDelete []any
-	db.mutex.Unlock()
 	for _, q := range db.ListDistroContents(distro) {
 		if _, found := seen[q.Name]; !found {
 			toDelete = append(toDelete, q.ID)
 		}
 	}
-	db.mutex.Lock()
 
 	if len(toDelete) > 0 {
 		var query string = "DELETE FROM distribution_contents WHERE ID IN ("
@@ -169,3 +156,53 @@ func (db *Database) UpdateDistroContents(distro string, pvs []PackageVersion) {
 		}
 	}
 }
+
+// ListAllPackageVersions lists every package version that has been processed,
+// including old versions that are no longer in the distribution, keyed by
+// distro.
+func (db *Database) ListAllPackageVersions() map[string][]PackageVersion {
+	return listAllPackageVersions(db.DB)
+}
+
+func listAllPackageVersions(q querier) map[string][]PackageVersion {
+	rows, err := q.Query(
+		"SELECT distro, id, pkg_name, pkg_version, sc_epoch" +
+			" FROM package_versions ORDER BY distro, pkg_name, pkg_version",
+	)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	var result = make(map[string][]PackageVersion)
+	for rows.Next() {
+		var distro string
+		pv := PackageVersion{}
+		if err := rows.Scan(&distro, &pv.ID, &pv.Name, &pv.Version, &pv.Epoch); err != nil {
+			panic(err)
+		}
+		result[distro] = append(result[distro], pv)
+	}
+	if err := rows.Err(); err != nil {
+		panic(err)
+	}
+	return result
+}
+
+// InvalidatePackageVersions resets the epoch of the given package versions, so
+// that they'll be reprocessed on the next run if they're still current.
+func (db *Database) InvalidatePackageVersions(pvs []PackageVersion) {
+	if len(pvs) < 1 {
+		return
+	}
+	var values []any
+	var query string = "UPDATE package_versions SET sc_epoch = 0 WHERE id IN ("
+	for i, pv := range pvs {
+		values = append(values, pv.ID)
+		query += fmt.Sprintf("$%d, ", i+1)
+	}
+	query = query[:len(query)-2] + ")"
+	if _, err := db.Exec(query, values...); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,27 @@
+-- Record the contents of each distribution on every run. (See snapshot.go.)
+CREATE TABLE snapshots (
+    id              BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+
+    distro          VARCHAR(32) NOT NULL,
+    taken_at        VARCHAR(32) NOT NULL,
+
+    packages        INT NOT NULL,
+    added           INT NOT NULL,
+    removed         INT NOT NULL,
+    updated         INT NOT NULL
+);
+
+CREATE INDEX snapshot_distro ON snapshots (distro, id);
+
+CREATE TABLE snapshot_contents (
+    distro          VARCHAR(32) NOT NULL,
+    pkg_name        VARCHAR(255) NOT NULL,
+    package_version BIGINT NOT NULL,
+
+    first_snapshot  BIGINT NOT NULL,
+    until_snapshot  BIGINT,
+
+    PRIMARY KEY (distro, pkg_name, first_snapshot)
+);
+
+CREATE INDEX snapshot_open ON snapshot_contents (distro, until_snapshot);
@@ -0,0 +1,24 @@
+-- Record each publisher run and every attempt to process a package, so that
+-- repeatedly failing packages can be backed off and reported. (See run.go.)
+CREATE TABLE runs (
+    id              BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    started_at      VARCHAR(32) NOT NULL,
+    finished_at     VARCHAR(32),
+    errored         BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE TABLE package_attempts (
+    id              BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    run             BIGINT NOT NULL,
+
+    distro          VARCHAR(32) NOT NULL,
+    pkg_name        VARCHAR(255) NOT NULL,
+    pkg_version     VARCHAR(255) NOT NULL,
+
+    started_at      VARCHAR(32) NOT NULL,
+    duration_ms     BIGINT NOT NULL,
+    stage           VARCHAR(32) NOT NULL,
+    error           TEXT
+);
+
+CREATE INDEX package_attempt ON package_attempts (distro, pkg_name, id);
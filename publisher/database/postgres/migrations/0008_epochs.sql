@@ -0,0 +1,34 @@
+-- Record the epoch of each snapshot range and manifest, so that the garbage
+-- collector can tell when an old epoch's indexes and files are no longer
+-- referenced. (See gc.go.) Existing rows are assumed to be at their package
+-- version's current epoch.
+ALTER TABLE snapshot_contents ADD COLUMN sc_epoch INT NOT NULL DEFAULT 0;
+
+UPDATE snapshot_contents sc SET sc_epoch = pv.sc_epoch
+FROM package_versions pv WHERE pv.id = sc.package_version;
+
+ALTER TABLE snapshot_contents ALTER COLUMN sc_epoch DROP DEFAULT;
+
+DELETE FROM manifests m
+WHERE NOT EXISTS (SELECT 1 FROM package_versions pv WHERE pv.id = m.package_version);
+
+ALTER TABLE manifests ADD COLUMN sc_epoch INT NOT NULL DEFAULT 0;
+
+UPDATE manifests m SET sc_epoch = pv.sc_epoch
+FROM package_versions pv WHERE pv.id = m.package_version;
+
+ALTER TABLE manifests ALTER COLUMN sc_epoch DROP DEFAULT;
+ALTER TABLE manifests DROP CONSTRAINT manifests_pkey;
+ALTER TABLE manifests ADD PRIMARY KEY (package_version, sc_epoch);
+
+DELETE FROM manifest_files mf
+WHERE NOT EXISTS (SELECT 1 FROM package_versions pv WHERE pv.id = mf.package_version);
+
+ALTER TABLE manifest_files ADD COLUMN sc_epoch INT NOT NULL DEFAULT 0;
+
+UPDATE manifest_files mf SET sc_epoch = pv.sc_epoch
+FROM package_versions pv WHERE pv.id = mf.package_version;
+
+ALTER TABLE manifest_files ALTER COLUMN sc_epoch DROP DEFAULT;
+ALTER TABLE manifest_files DROP CONSTRAINT manifest_files_pkey;
+ALTER TABLE manifest_files ADD PRIMARY KEY (package_version, sc_epoch, hash);
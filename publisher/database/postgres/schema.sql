@@ -0,0 +1,131 @@
+-- This file creates a new PostgreSQL database at the latest schema version. It
+-- mirrors ../sqlite/schema.sql, which has the full commentary on each table.
+-- Postgres support was added at schema version 4, so migrations for this
+-- driver start at version 5.
+
+CREATE TABLE schema_version (
+    version         INT NOT NULL
+);
+
+CREATE TABLE package_versions (
+    id              BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+
+    distro          VARCHAR(32) NOT NULL,
+    pkg_name        VARCHAR(255) NOT NULL,
+    pkg_version     VARCHAR(255) NOT NULL,
+
+    sc_epoch        INT NOT NULL
+);
+
+CREATE UNIQUE INDEX package_version
+ON package_versions (distro, pkg_name, pkg_version);
+
+CREATE TABLE distribution_contents (
+    id              BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+
+    distro          VARCHAR(32) NOT NULL,
+    pkg_name        VARCHAR(255) NOT NULL,
+    current         BIGINT NOT NULL  -- foreign key to package_versions
+);
+
+CREATE UNIQUE INDEX package on distribution_contents (distro, pkg_name);
+
+CREATE TABLE files (
+    hash            BYTEA PRIMARY KEY
+);
+
+CREATE TABLE line_counts (
+    package_version BIGINT NOT NULL,  -- foreign key to package_versions
+    language        VARCHAR(64) NOT NULL,
+
+    files           INT NOT NULL,
+    code            INT NOT NULL,
+    comment         INT NOT NULL,
+    blank           INT NOT NULL,
+
+    PRIMARY KEY (package_version, language)
+);
+
+CREATE TABLE upstream_metadata (
+    package_version     BIGINT PRIMARY KEY,  -- foreign key to package_versions
+
+    homepage            TEXT NOT NULL,
+    repository          TEXT NOT NULL,
+    repository_browse   TEXT NOT NULL,
+    bug_database        TEXT NOT NULL,
+    bug_submit          TEXT NOT NULL,
+    documentation       TEXT NOT NULL,
+    changelog           TEXT NOT NULL,
+    watch               TEXT NOT NULL,
+    moved_from          TEXT NOT NULL
+);
+
+CREATE TABLE snapshots (
+    id              BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+
+    distro          VARCHAR(32) NOT NULL,
+    taken_at        VARCHAR(32) NOT NULL,  -- RFC 3339, in UTC
+
+    packages        INT NOT NULL,
+    added           INT NOT NULL,
+    removed         INT NOT NULL,
+    updated         INT NOT NULL
+);
+
+CREATE INDEX snapshot_distro ON snapshots (distro, id);
+
+CREATE TABLE snapshot_contents (
+    distro          VARCHAR(32) NOT NULL,
+    pkg_name        VARCHAR(255) NOT NULL,
+    package_version BIGINT NOT NULL,  -- foreign key to package_versions
+
+    first_snapshot  BIGINT NOT NULL,  -- foreign key to snapshots
+    until_snapshot  BIGINT,           -- foreign key to snapshots
+
+    sc_epoch        INT NOT NULL,
+
+    PRIMARY KEY (distro, pkg_name, first_snapshot)
+);
+
+CREATE INDEX snapshot_open ON snapshot_contents (distro, until_snapshot);
+
+CREATE TABLE manifests (
+    package_version BIGINT NOT NULL,  -- foreign key to package_versions
+    sc_epoch        INT NOT NULL,
+    files           INT NOT NULL,
+
+    PRIMARY KEY (package_version, sc_epoch)
+);
+
+CREATE TABLE manifest_files (
+    package_version BIGINT NOT NULL,  -- foreign key to package_versions
+    sc_epoch        INT NOT NULL,
+    hash            BYTEA NOT NULL,   -- foreign key to files
+
+    PRIMARY KEY (package_version, sc_epoch, hash)
+);
+
+CREATE INDEX manifest_file_hash ON manifest_files (hash);
+
+CREATE TABLE runs (
+    id              BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    started_at      VARCHAR(32) NOT NULL,  -- RFC 3339, in UTC
+    finished_at     VARCHAR(32),           -- RFC 3339, in UTC
+    errored         BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE TABLE package_attempts (
+    id              BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    run             BIGINT NOT NULL,  -- foreign key to runs
+
+    distro          VARCHAR(32) NOT NULL,
+    pkg_name        VARCHAR(255) NOT NULL,
+    pkg_version     VARCHAR(255) NOT NULL,
+
+    started_at      VARCHAR(32) NOT NULL,  -- RFC 3339, in UTC
+    duration_ms     BIGINT NOT NULL,
+    stage           VARCHAR(32) NOT NULL,
+    error           TEXT
+);
+
+CREATE INDEX package_attempt ON package_attempts (distro, pkg_name, id);
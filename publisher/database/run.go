@@ -0,0 +1,173 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// StartRun records the start of a publisher run and returns its ID, for
+// recording package attempts.
+func (db *Database) StartRun(at time.Time) int64 {
+	var id int64
+	err := db.QueryRow(
+		"INSERT INTO runs (started_at) VALUES ($1) RETURNING id",
+		at.UTC().Format(time.RFC3339),
+	).Scan(&id)
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+// FinishRun records the end of a publisher run.
+func (db *Database) FinishRun(run int64, at time.Time, errored bool) {
+	_, err := db.Exec(
+		"UPDATE runs SET finished_at = $1, errored = $2 WHERE id = $3",
+		at.UTC().Format(time.RFC3339), errored, run,
+	)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// A Run is one invocation of the publisher. FinishedAt is zero if the run is
+// still in progress, or crashed.
+type Run struct {
+	ID         int64
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Errored    bool
+}
+
+// LastRun returns the most recent publisher run, if there has been one.
+func (db *Database) LastRun() (Run, bool) {
+	var run Run
+	var startedAt string
+	var finishedAt sql.NullString
+	err := db.QueryRow(
+		"SELECT id, started_at, finished_at, errored FROM runs ORDER BY id DESC LIMIT 1",
+	).Scan(&run.ID, &startedAt, &finishedAt, &run.Errored)
+	if err == sql.ErrNoRows {
+		return Run{}, false
+	} else if err != nil {
+		panic(err)
+	}
+	if run.StartedAt, err = time.Parse(time.RFC3339, startedAt); err != nil {
+		panic(err)
+	}
+	if finishedAt.Valid {
+		if run.FinishedAt, err = time.Parse(time.RFC3339, finishedAt.String); err != nil {
+			panic(err)
+		}
+	}
+	return run, true
+}
+
+// A PackageAttempt is one try at processing a package version. Stage is the
+// last stage of processing reached; Error is empty if the attempt succeeded.
+type PackageAttempt struct {
+	Run     int64
+	Distro  string
+	Name    string
+	Version string
+
+	StartedAt time.Time
+	Duration  time.Duration
+	Stage     string
+	Error     string
+}
+
+// RecordAttempt saves the outcome of an attempt to process a package version.
+// Once a package succeeds, its earlier attempts are no longer needed for
+// backing off, so they're pruned.
+func (db *Database) RecordAttempt(a PackageAttempt) {
+	var errText sql.NullString
+	if a.Error != "" {
+		errText = sql.NullString{String: a.Error, Valid: true}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRow(
+		"INSERT INTO package_attempts (run, distro, pkg_name, pkg_version,"+
+			" started_at, duration_ms, stage, error)"+
+			" VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id",
+		a.Run, a.Distro, a.Name, a.Version, a.StartedAt.UTC().Format(time.RFC3339),
+		a.Duration.Milliseconds(), a.Stage, errText,
+	).Scan(&id)
+	if err != nil {
+		panic(err)
+	}
+	if !errText.Valid {
+		_, err = tx.Exec(
+			"DELETE FROM package_attempts WHERE distro = $1 AND pkg_name = $2 AND id < $3",
+			a.Distro, a.Name, id,
+		)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		panic(err)
+	}
+}
+
+// A Failure is a package whose most recent attempt failed. Failures counts the
+// consecutive failed attempts at this version; the other fields describe the
+// most recent one.
+type Failure struct {
+	PackageAttempt
+	Failures    int
+	FirstFailed time.Time
+}
+
+// ListFailures finds the packages in a distribution whose most recent attempt
+// failed, by package name. Packages that have since been removed from the
+// distribution are included until they're attempted again.
+func (db *Database) ListFailures(distro string) map[string]Failure {
+	rows, err := db.Query(
+		"SELECT run, pkg_name, pkg_version, started_at, duration_ms, stage, error"+
+			" FROM package_attempts a WHERE distro = $1 AND id > COALESCE(("+
+			"  SELECT MAX(id) FROM package_attempts s"+
+			"  WHERE s.distro = a.distro AND s.pkg_name = a.pkg_name AND s.error IS NULL"+
+			" ), 0) ORDER BY id",
+		distro,
+	)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	var failures = make(map[string]Failure)
+	for rows.Next() {
+		var a = PackageAttempt{Distro: distro}
+		var startedAt string
+		var duration int64
+		var errText sql.NullString
+		if err := rows.Scan(&a.Run, &a.Name, &a.Version, &startedAt, &duration, &a.Stage, &errText); err != nil {
+			panic(err)
+		}
+		if a.StartedAt, err = time.Parse(time.RFC3339, startedAt); err != nil {
+			panic(err)
+		}
+		a.Duration = time.Duration(duration) * time.Millisecond
+		a.Error = errText.String
+
+		var f = failures[a.Name]
+		if f.Version != a.Version {
+			f = Failure{FirstFailed: a.StartedAt}
+		}
+		f.PackageAttempt = a
+		f.Failures++
+		failures[a.Name] = f
+	}
+	if err := rows.Err(); err != nil {
+		panic(err)
+	}
+	return failures
+}
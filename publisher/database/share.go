@@ -0,0 +1,147 @@
+package database
+
+import (
+	"fmt"
+
+	"github.com/btidor/src.codes/publisher"
+	"github.com/btidor/src.codes/publisher/apt"
+)
+
+// A SharedVersion is a package version that was processed for another
+// distribution. The same version of a package is identical across
+// distributions, so its indexes can be copied rather than recomputed.
+type SharedVersion struct {
+	Distro string
+	PackageVersion
+}
+
+// ListSharedPackages finds the given packages that have already been processed
+// at the current epoch for one of the `peers`, keyed by package name. Only
+// distros that analyze packages the same way should be peers (see
+// publisher.Distro.SameAnalysis).
+func (db *Database) ListSharedPackages(peers []string, pkgs map[string]apt.Package) map[string]SharedVersion {
+	var shared = make(map[string]SharedVersion)
+	if len(peers) == 0 {
+		return shared
+	}
+
+	var plist []apt.Package
+	for _, pkg := range pkgs {
+		plist = append(plist, pkg)
+	}
+
+	for i := 0; i < len(plist); i += db.batchSize {
+		var values = []any{publisher.Epoch}
+		var query string = "SELECT distro, id, pkg_name, pkg_version, sc_epoch" +
+			" FROM package_versions WHERE sc_epoch = $1 AND distro IN ("
+		var n int = 2
+		for _, peer := range peers {
+			values = append(values, peer)
+			query += fmt.Sprintf("$%d, ", n)
+			n++
+		}
+		query = query[:len(query)-2] + ") AND (pkg_name, pkg_version) IN ("
+		for j := i; j < i+db.batchSize && j < len(plist); j++ {
+			values = append(values, plist[j].Name, plist[j].Version)
+			query += fmt.Sprintf("($%d, $%d), ", n, n+1)
+			n += 2
+		}
+		query = query[:len(query)-2] + ") ORDER BY distro"
+		rows, err := db.Query(query, values...)
+		if err != nil {
+			panic(err)
+		}
+
+		for rows.Next() {
+			var sv SharedVersion
+			if err := rows.Scan(&sv.Distro, &sv.ID, &sv.Name, &sv.Version, &sv.Epoch); err != nil {
+				rows.Close()
+				panic(err)
+			}
+			if _, found := shared[sv.Name]; !found {
+				shared[sv.Name] = sv
+			}
+		}
+		if err := rows.Err(); err != nil {
+			panic(err)
+		}
+		rows.Close()
+	}
+	return shared
+}
+
+// CopyPackageVersion records a shared package version in another distribution,
+// along with its line counts, upstream metadata and manifest, as if it had been
+// processed there.
+func (db *Database) CopyPackageVersion(from SharedVersion, distro string) PackageVersion {
+	tx, err := db.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	var pv = from.PackageVersion
+	err = tx.QueryRow(
+		"INSERT INTO package_versions (distro, pkg_name, pkg_version, sc_epoch)"+
+			" VALUES ($1, $2, $3, $4)"+
+			" ON CONFLICT (distro, pkg_name, pkg_version)"+
+			" DO UPDATE SET sc_epoch = EXCLUDED.sc_epoch RETURNING id",
+		distro, pv.Name, pv.Version, pv.Epoch,
+	).Scan(&pv.ID)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, table := range []struct{ name, columns string }{
+		{"line_counts", "language, files, code, comment, blank"},
+		{"upstream_metadata", upstreamColumns},
+	} {
+		_, err := tx.Exec("DELETE FROM "+table.name+" WHERE package_version = $1", pv.ID)
+		if err != nil {
+			panic(err)
+		}
+		// Postgres can't infer the type of a parameter in a SELECT list
+		_, err = tx.Exec(
+			"INSERT INTO "+table.name+" (package_version, "+table.columns+")"+
+				" SELECT CAST($1 AS BIGINT), "+table.columns+
+				" FROM "+table.name+" WHERE package_version = $2",
+			pv.ID, from.ID,
+		)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	// Replace the manifest at this epoch, as in RecordManifest. If there's no
+	// manifest to copy, leave it to the garbage collector to fill in.
+	if err := deleteManifest(tx, ManifestKey{pv.ID, pv.Epoch}); err != nil {
+		panic(err)
+	}
+	var manifests int
+	err = tx.QueryRow(
+		"SELECT COUNT(*) FROM manifests WHERE package_version = $1 AND sc_epoch = $2",
+		from.ID, from.Epoch,
+	).Scan(&manifests)
+	if err != nil {
+		panic(err)
+	}
+	if manifests > 0 {
+		_, err = tx.Exec(
+			"INSERT INTO manifest_files (package_version, sc_epoch, hash)"+
+				" SELECT CAST($1 AS BIGINT), sc_epoch, hash FROM manifest_files"+
+				" WHERE package_version = $2 AND sc_epoch = $3",
+			pv.ID, from.ID, from.Epoch,
+		)
+		if err != nil {
+			panic(err)
+		}
+		if err := updateManifestCount(tx, ManifestKey{pv.ID, pv.Epoch}); err != nil {
+			panic(err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		panic(err)
+	}
+	return pv
+}
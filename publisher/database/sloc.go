@@ -0,0 +1,113 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/btidor/src.codes/publisher/analysis"
+)
+
+// PackageSLOC is one package's total line counts, for ranking packages by size.
+type PackageSLOC struct {
+	Name string `json:"name"`
+	analysis.LineCounts
+}
+
+// DistroSLOC aggregates line counts across all the packages currently in a
+// distribution.
+type DistroSLOC struct {
+	Languages map[string]analysis.LineCounts `json:"languages"`
+	Total     analysis.LineCounts            `json:"total"`
+	Packages  []PackageSLOC                  `json:"packages"` // largest first
+}
+
+// RecordLineCounts stores the line counts for a package version, replacing any
+// previously recorded.
+func (db *Database) RecordLineCounts(pv PackageVersion, stats analysis.SLOCStats) {
+	tx, err := db.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM line_counts WHERE package_version = $1", pv.ID)
+	if err != nil {
+		panic(err)
+	}
+
+	var languages []string
+	for language := range stats.Languages {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+
+	for i := 0; i < len(languages); i += db.batchSize {
+		var values []any
+		var query string = "INSERT INTO line_counts" +
+			" (package_version, language, files, code, comment, blank) VALUES "
+		var n int = 1
+		for j := i; j < i+db.batchSize && j < len(languages); j++ {
+			c := stats.Languages[languages[j]]
+			values = append(values, pv.ID, languages[j], c.Files, c.Code, c.Comment, c.Blank)
+			query += fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d), ", n, n+1, n+2, n+3, n+4, n+5)
+			n += 6
+		}
+		query = query[:len(query)-2]
+		if _, err := tx.Exec(query, values...); err != nil {
+			panic(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		panic(err)
+	}
+}
+
+// AggregateLineCounts totals up the line counts for the current version of
+// each package in the distribution.
+func (db *Database) AggregateLineCounts(distro string) DistroSLOC {
+	rows, err := db.Query(
+		"SELECT pv.pkg_name, lc.language, lc.files, lc.code, lc.comment, lc.blank"+
+			" FROM distribution_contents dc"+
+			" JOIN package_versions pv ON dc.current = pv.id"+
+			" JOIN line_counts lc ON lc.package_version = pv.id"+
+			" WHERE dc.distro = $1",
+		distro,
+	)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	var result = DistroSLOC{Languages: make(map[string]analysis.LineCounts)}
+	var packages = make(map[string]analysis.LineCounts)
+	for rows.Next() {
+		var name, language string
+		var c analysis.LineCounts
+		if err := rows.Scan(&name, &language, &c.Files, &c.Code, &c.Comment, &c.Blank); err != nil {
+			panic(err)
+		}
+		total := result.Languages[language]
+		total.Add(c)
+		result.Languages[language] = total
+		result.Total.Add(c)
+
+		pkg := packages[name]
+		pkg.Add(c)
+		packages[name] = pkg
+	}
+	if err := rows.Err(); err != nil {
+		panic(err)
+	}
+
+	for name, c := range packages {
+		result.Packages = append(result.Packages, PackageSLOC{name, c})
+	}
+	sort.Slice(result.Packages, func(i, j int) bool {
+		a, b := result.Packages[i], result.Packages[j]
+		if a.Code != b.Code {
+			return a.Code > b.Code
+		}
+		return a.Name < b.Name
+	})
+	return result
+}
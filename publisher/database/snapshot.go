@@ -0,0 +1,221 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// A Snapshot is the contents of a distribution as of one publisher run. Indexes
+// for old package versions are left in storage, so a snapshot's packages stay
+// addressable by version and epoch after the distribution moves on.
+type Snapshot struct {
+	ID      int64     `json:"id"`
+	TakenAt time.Time `json:"taken_at"`
+
+	Packages int `json:"packages"`
+
+	// Changes relative to the previous snapshot
+	Added   int `json:"added"`
+	Removed int `json:"removed"`
+	Updated int `json:"updated"`
+}
+
+// RecordSnapshot saves the current contents of the distribution (as written by
+// UpdateDistroContents) as a new snapshot.
+func (db *Database) RecordSnapshot(distro string, at time.Time) Snapshot {
+	tx, err := db.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+
+	current := queryPackageIDs(tx,
+		"SELECT dc.pkg_name, dc.current, pv.sc_epoch FROM distribution_contents dc"+
+			" JOIN package_versions pv ON dc.current = pv.id WHERE dc.distro = $1", distro)
+	open := queryPackageIDs(tx,
+		"SELECT pkg_name, package_version, sc_epoch FROM snapshot_contents"+
+			" WHERE distro = $1 AND until_snapshot IS NULL", distro)
+
+	var snapshot = Snapshot{
+		TakenAt:  at.UTC().Truncate(time.Second),
+		Packages: len(current),
+	}
+	var closed, opened []string
+	for name, pv := range current {
+		if prev, found := open[name]; !found {
+			snapshot.Added++
+			opened = append(opened, name)
+		} else if prev.ID != pv.ID {
+			snapshot.Updated++
+			closed = append(closed, name)
+			opened = append(opened, name)
+		} else if prev.Epoch != pv.Epoch && pv.Epoch != 0 {
+			// Reprocessed: the same version, but at a new epoch. (A package
+			// version that's been invalidated has no indexes at epoch 0, so
+			// keep pointing to the old ones until it's reprocessed.)
+			closed = append(closed, name)
+			opened = append(opened, name)
+		}
+	}
+	for name := range open {
+		if _, found := current[name]; !found {
+			snapshot.Removed++
+			closed = append(closed, name)
+		}
+	}
+
+	// Use RETURNING, since LastInsertId() isn't supported everywhere
+	err = tx.QueryRow(
+		"INSERT INTO snapshots (distro, taken_at, packages, added, removed, updated)"+
+			" VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+		distro, snapshot.TakenAt.Format(time.RFC3339), snapshot.Packages,
+		snapshot.Added, snapshot.Removed, snapshot.Updated,
+	).Scan(&snapshot.ID)
+	if err != nil {
+		panic(err)
+	}
+
+	for i := 0; i < len(closed); i += db.batchSize {
+		var values = []any{snapshot.ID, distro}
+		var query string = "UPDATE snapshot_contents SET until_snapshot = $1" +
+			" WHERE distro = $2 AND until_snapshot IS NULL AND pkg_name IN ("
+		var n int = 3
+		for j := i; j < i+db.batchSize && j < len(closed); j++ {
+			values = append(values, closed[j])
+			query += fmt.Sprintf("$%d, ", n)
+			n++
+		}
+		query = query[:len(query)-2] + ")"
+		if _, err := tx.Exec(query, values...); err != nil {
+			panic(err)
+		}
+	}
+
+	for i := 0; i < len(opened); i += db.batchSize {
+		var values []any
+		var query string = "INSERT INTO snapshot_contents" +
+			" (distro, pkg_name, package_version, sc_epoch, first_snapshot) VALUES "
+		var n int = 1
+		for j := i; j < i+db.batchSize && j < len(opened); j++ {
+			pv := current[opened[j]]
+			values = append(values, distro, opened[j], pv.ID, pv.Epoch, snapshot.ID)
+			query += fmt.Sprintf("($%d, $%d, $%d, $%d, $%d), ", n, n+1, n+2, n+3, n+4)
+			n += 5
+		}
+		query = query[:len(query)-2]
+		if _, err := tx.Exec(query, values...); err != nil {
+			panic(err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		panic(err)
+	}
+	return snapshot
+}
+
+// queryPackageIDs runs a query for the name, ID and epoch of a set of package
+// versions, and returns them by name.
+func queryPackageIDs(tx *sql.Tx, query string, args ...any) map[string]PackageVersion {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	var result = make(map[string]PackageVersion)
+	for rows.Next() {
+		var pv PackageVersion
+		if err := rows.Scan(&pv.Name, &pv.ID, &pv.Epoch); err != nil {
+			panic(err)
+		}
+		result[pv.Name] = pv
+	}
+	if err := rows.Err(); err != nil {
+		panic(err)
+	}
+	return result
+}
+
+// ListSnapshots lists the snapshots of a distribution, oldest first.
+func (db *Database) ListSnapshots(distro string) []Snapshot {
+	rows, err := db.Query(
+		"SELECT id, taken_at, packages, added, removed, updated FROM snapshots"+
+			" WHERE distro = $1 ORDER BY id",
+		distro,
+	)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	var snapshots []Snapshot
+	for rows.Next() {
+		var s Snapshot
+		var takenAt string
+		if err := rows.Scan(&s.ID, &takenAt, &s.Packages, &s.Added, &s.Removed, &s.Updated); err != nil {
+			panic(err)
+		}
+		if s.TakenAt, err = time.Parse(time.RFC3339, takenAt); err != nil {
+			panic(err)
+		}
+		snapshots = append(snapshots, s)
+	}
+	if err := rows.Err(); err != nil {
+		panic(err)
+	}
+	return snapshots
+}
+
+// ListSnapshotContents lists the package versions in a snapshot of a
+// distribution, at the epoch they had when the snapshot was taken.
+func (db *Database) ListSnapshotContents(distro string, snapshot int64) []PackageVersion {
+	rows, err := db.Query(
+		"SELECT pv.id, pv.pkg_name, pv.pkg_version, sc.sc_epoch"+
+			" FROM snapshot_contents sc"+
+			" JOIN package_versions pv ON sc.package_version = pv.id"+
+			" WHERE sc.distro = $1 AND sc.first_snapshot <= $2"+
+			" AND (sc.until_snapshot IS NULL OR sc.until_snapshot > $2)",
+		distro, snapshot,
+	)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	var pvs []PackageVersion
+	for rows.Next() {
+		pv := PackageVersion{}
+		if err := rows.Scan(&pv.ID, &pv.Name, &pv.Version, &pv.Epoch); err != nil {
+			panic(err)
+		}
+		pvs = append(pvs, pv)
+	}
+	if err := rows.Err(); err != nil {
+		panic(err)
+	}
+	return pvs
+}
+
+// PreviousVersion finds the most recent version of a package, other than the
+// given one, that was in a snapshot of the distribution, at the epoch it had
+// then. This is what a package version should be diffed against when it's
+// reprocessed.
+func (db *Database) PreviousVersion(distro, name, version string) (PackageVersion, bool) {
+	var pv = PackageVersion{Name: name}
+	err := db.QueryRow(
+		"SELECT pv.id, pv.pkg_version, sc.sc_epoch"+
+			" FROM snapshot_contents sc"+
+			" JOIN package_versions pv ON sc.package_version = pv.id"+
+			" WHERE sc.distro = $1 AND sc.pkg_name = $2 AND pv.pkg_version != $3"+
+			" ORDER BY sc.first_snapshot DESC LIMIT 1",
+		distro, name, version,
+	).Scan(&pv.ID, &pv.Version, &pv.Epoch)
+	if err == sql.ErrNoRows {
+		return PackageVersion{}, false
+	} else if err != nil {
+		panic(err)
+	}
+	return pv, true
+}
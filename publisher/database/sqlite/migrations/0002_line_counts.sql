@@ -0,0 +1,12 @@
+-- Lines of code per package version, by language. (See analysis.SLOCStats.)
+CREATE TABLE line_counts (
+    package_version INT NOT NULL,
+    language        VARCHAR(64) NOT NULL,
+
+    files           INT NOT NULL,
+    code            INT NOT NULL,
+    comment         INT NOT NULL,
+    blank           INT NOT NULL,
+
+    PRIMARY KEY (package_version, language)
+);
@@ -0,0 +1,14 @@
+-- Upstream metadata per package version. (See analysis.Upstream.)
+CREATE TABLE upstream_metadata (
+    package_version     INTEGER PRIMARY KEY,
+
+    homepage            TEXT NOT NULL,
+    repository          TEXT NOT NULL,
+    repository_browse   TEXT NOT NULL,
+    bug_database        TEXT NOT NULL,
+    bug_submit          TEXT NOT NULL,
+    documentation       TEXT NOT NULL,
+    changelog           TEXT NOT NULL,
+    watch               TEXT NOT NULL,
+    moved_from          TEXT NOT NULL
+);
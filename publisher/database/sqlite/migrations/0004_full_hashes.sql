@@ -0,0 +1,8 @@
+-- Store full SHA-256 hashes in the files table. The old 64-bit hashes can't be
+-- converted, so they're kept in `legacy_files` until the publisher has
+-- backfilled the new table from the published trees (see NeedsHashBackfill).
+ALTER TABLE files RENAME TO legacy_files;
+
+CREATE TABLE files (
+    hash            BLOB PRIMARY KEY
+) WITHOUT ROWID;
@@ -0,0 +1,27 @@
+-- Record the contents of each distribution on every run. (See snapshot.go.)
+CREATE TABLE snapshots (
+    id              INTEGER PRIMARY KEY,
+
+    distro          VARCHAR(32) NOT NULL,
+    taken_at        VARCHAR(32) NOT NULL,
+
+    packages        INT NOT NULL,
+    added           INT NOT NULL,
+    removed         INT NOT NULL,
+    updated         INT NOT NULL
+);
+
+CREATE INDEX snapshot_distro ON snapshots (distro, id);
+
+CREATE TABLE snapshot_contents (
+    distro          VARCHAR(32) NOT NULL,
+    pkg_name        VARCHAR(255) NOT NULL,
+    package_version INT NOT NULL,
+
+    first_snapshot  INT NOT NULL,
+    until_snapshot  INT,
+
+    PRIMARY KEY (distro, pkg_name, first_snapshot)
+);
+
+CREATE INDEX snapshot_open ON snapshot_contents (distro, until_snapshot);
@@ -0,0 +1,15 @@
+-- Record the files in each package version, for garbage collection. (See
+-- manifest.go.)
+CREATE TABLE manifests (
+    package_version INTEGER PRIMARY KEY,
+    files           INT NOT NULL
+);
+
+CREATE TABLE manifest_files (
+    package_version INT NOT NULL,
+    hash            BLOB NOT NULL,
+
+    PRIMARY KEY (package_version, hash)
+) WITHOUT ROWID;
+
+CREATE INDEX manifest_file_hash ON manifest_files (hash);
@@ -0,0 +1,41 @@
+-- Record the epoch of each snapshot range and manifest, so that the garbage
+-- collector can tell when an old epoch's indexes and files are no longer
+-- referenced. (See gc.go.) Existing rows are assumed to be at their package
+-- version's current epoch.
+ALTER TABLE snapshot_contents ADD COLUMN sc_epoch INT NOT NULL DEFAULT 0;
+
+UPDATE snapshot_contents SET sc_epoch = COALESCE(
+    (SELECT sc_epoch FROM package_versions pv WHERE pv.id = package_version), 0
+);
+
+CREATE TABLE manifests_new (
+    package_version INT NOT NULL,
+    sc_epoch        INT NOT NULL,
+    files           INT NOT NULL,
+
+    PRIMARY KEY (package_version, sc_epoch)
+);
+
+INSERT INTO manifests_new (package_version, sc_epoch, files)
+SELECT m.package_version, pv.sc_epoch, m.files
+FROM manifests m JOIN package_versions pv ON pv.id = m.package_version;
+
+DROP TABLE manifests;
+ALTER TABLE manifests_new RENAME TO manifests;
+
+CREATE TABLE manifest_files_new (
+    package_version INT NOT NULL,
+    sc_epoch        INT NOT NULL,
+    hash            BLOB NOT NULL,
+
+    PRIMARY KEY (package_version, sc_epoch, hash)
+) WITHOUT ROWID;
+
+INSERT INTO manifest_files_new (package_version, sc_epoch, hash)
+SELECT mf.package_version, pv.sc_epoch, mf.hash
+FROM manifest_files mf JOIN package_versions pv ON pv.id = mf.package_version;
+
+DROP TABLE manifest_files;
+ALTER TABLE manifest_files_new RENAME TO manifest_files;
+
+CREATE INDEX manifest_file_hash ON manifest_files (hash);
@@ -0,0 +1,9 @@
+-- Attempts before a package's most recent success are no longer needed for
+-- backing off, and are now pruned as packages succeed. (See RecordAttempt.)
+-- Prune the history that has built up so far.
+DELETE FROM package_attempts WHERE id < (
+    SELECT MAX(s.id) FROM package_attempts s
+    WHERE s.distro = package_attempts.distro
+        AND s.pkg_name = package_attempts.pkg_name
+        AND s.error IS NULL
+);
@@ -0,0 +1,183 @@
+-- This file creates a new database at the latest schema version. Existing
+-- databases are brought up to date by the numbered files in migrations/; any
+-- change here needs a corresponding migration (and vice versa), and the same
+-- change to ../postgres/schema.sql.
+
+-- The `schema_version` table holds a single row with the number of the last
+-- migration applied (or that this file is equivalent to).
+CREATE TABLE schema_version (
+    version         INT NOT NULL
+);
+
+-- The `package_versions` table tracks the unique packages in our system. Every
+-- version of every package is a row in this table. (If the same version of the
+-- same package appears in different distributions, each distro gets its own row
+-- too. It's only processed once, though: the other distros copy its indexes and
+-- rows, see share.go.)
+CREATE TABLE package_versions (
+    id              INTEGER PRIMARY KEY,
+
+    distro          VARCHAR(32) NOT NULL,
+    pkg_name        VARCHAR(255) NOT NULL,
+    pkg_version     VARCHAR(255) NOT NULL,
+
+    -- The epoch represents which version of the archiver last processed the
+    -- package. (It's also included in the index filenames on `ls` and `meta`).
+    -- If the archiver is updated to produce new indexes or formats, we'll
+    -- reprocess old packages and bump their epoch.
+    sc_epoch        INT NOT NULL
+);
+
+CREATE UNIQUE INDEX package_version
+ON package_versions (distro, pkg_name, pkg_version);
+
+-- The `distribution_contents` table mirrors the contents of the Sources file
+-- published by each distribution. It lists the latest version of each package
+-- included in the distribution, and excludes any packages that have been
+-- removed from the distribution. This table gets re-written as packages are
+-- updated, added and removed.
+CREATE TABLE distribution_contents (
+    id              INTEGER PRIMARY KEY,
+
+    distro          VARCHAR(32) NOT NULL,
+    pkg_name        VARCHAR(255) NOT NULL,
+    current         INT NOT NULL  -- foreign key to package_versions
+);
+
+CREATE UNIQUE INDEX package on distribution_contents (distro, pkg_name);
+
+-- The `files` table tracks the files uploaded to storage. We check this table
+-- to avoid uploading the same file multiple times. This table takes up the vast
+-- majority of our database storage, so we avoid storing any other columns, and
+-- store each SHA-256 hash as a 32-byte blob in a table without a rowid.
+CREATE TABLE files (
+    hash            BLOB PRIMARY KEY
+) WITHOUT ROWID;
+
+-- The `line_counts` table records the lines of code in each package version,
+-- by language. (See analysis.SLOCStats.)
+CREATE TABLE line_counts (
+    package_version INT NOT NULL,  -- foreign key to package_versions
+    language        VARCHAR(64) NOT NULL,
+
+    files           INT NOT NULL,
+    code            INT NOT NULL,
+    comment         INT NOT NULL,
+    blank           INT NOT NULL,
+
+    PRIMARY KEY (package_version, language)
+);
+
+-- The `upstream_metadata` table records where each package version comes from,
+-- from debian/upstream/metadata, debian/watch and debian/control. (See
+-- analysis.Upstream.)
+CREATE TABLE upstream_metadata (
+    package_version     INTEGER PRIMARY KEY,  -- foreign key to package_versions
+
+    homepage            TEXT NOT NULL,
+    repository          TEXT NOT NULL,
+    repository_browse   TEXT NOT NULL,
+    bug_database        TEXT NOT NULL,
+    bug_submit          TEXT NOT NULL,
+    documentation       TEXT NOT NULL,
+    changelog           TEXT NOT NULL,
+    watch               TEXT NOT NULL,  -- newline-separated URL patterns
+    moved_from          TEXT NOT NULL
+);
+
+-- The `snapshots` table records the contents of each distribution as of every
+-- publisher run, so that we can show what a distribution looked like in the
+-- past. Each row is one run's view of one distro, with summary statistics
+-- relative to the previous snapshot.
+CREATE TABLE snapshots (
+    id              INTEGER PRIMARY KEY,
+
+    distro          VARCHAR(32) NOT NULL,
+    taken_at        VARCHAR(32) NOT NULL,  -- RFC 3339, in UTC
+
+    packages        INT NOT NULL,
+    added           INT NOT NULL,
+    removed         INT NOT NULL,
+    updated         INT NOT NULL
+);
+
+CREATE INDEX snapshot_distro ON snapshots (distro, id);
+
+-- The `snapshot_contents` table records which version of each package was in
+-- a distribution, as a range of snapshots. Since most packages don't change
+-- from one run to the next, this is much smaller than storing every snapshot
+-- in full. A package version was in snapshot S if first_snapshot <= S and
+-- until_snapshot is NULL (it's still current) or greater than S.
+CREATE TABLE snapshot_contents (
+    distro          VARCHAR(32) NOT NULL,
+    pkg_name        VARCHAR(255) NOT NULL,
+    package_version INT NOT NULL,  -- foreign key to package_versions
+
+    first_snapshot  INT NOT NULL,  -- foreign key to snapshots
+    until_snapshot  INT,           -- foreign key to snapshots
+
+    -- The epoch of the package version's indexes while it was in these
+    -- snapshots. When a package version is reprocessed at a new epoch, its
+    -- range is closed and a new one opened, since older snapshots still point
+    -- to the old indexes.
+    sc_epoch        INT NOT NULL,
+
+    PRIMARY KEY (distro, pkg_name, first_snapshot)
+);
+
+CREATE INDEX snapshot_open ON snapshot_contents (distro, until_snapshot);
+
+-- The `manifests` table records which package versions have a file manifest
+-- at each epoch, and how many distinct files it lists. The manifest itself is stored in
+-- `manifest_files`, one row per file hash. The garbage collector uses these
+-- tables to find which files in storage are still referenced; package
+-- versions recorded before manifests existed get one from their published
+-- tree.
+CREATE TABLE manifests (
+    package_version INT NOT NULL,  -- foreign key to package_versions
+    sc_epoch        INT NOT NULL,
+    files           INT NOT NULL,
+
+    PRIMARY KEY (package_version, sc_epoch)
+);
+
+CREATE TABLE manifest_files (
+    package_version INT NOT NULL,  -- foreign key to package_versions
+    sc_epoch        INT NOT NULL,
+    hash            BLOB NOT NULL, -- foreign key to files
+
+    PRIMARY KEY (package_version, sc_epoch, hash)
+) WITHOUT ROWID;
+
+CREATE INDEX manifest_file_hash ON manifest_files (hash);
+
+-- The `runs` table records each time the publisher runs. finished_at is NULL
+-- if the run is in progress or crashed; errored is set if any distro or
+-- package failed.
+CREATE TABLE runs (
+    id              INTEGER PRIMARY KEY,
+    started_at      VARCHAR(32) NOT NULL,  -- RFC 3339, in UTC
+    finished_at     VARCHAR(32),           -- RFC 3339, in UTC
+    errored         BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+-- The `package_attempts` table records attempts to process a package version,
+-- successful or not, along with the last stage of processing it reached. error
+-- is NULL if the attempt succeeded. Consecutive failures of the same version
+-- are used to back off before retrying it, so only the most recent success and
+-- the attempts after it are kept.
+CREATE TABLE package_attempts (
+    id              INTEGER PRIMARY KEY,
+    run             INT NOT NULL,  -- foreign key to runs
+
+    distro          VARCHAR(32) NOT NULL,
+    pkg_name        VARCHAR(255) NOT NULL,
+    pkg_version     VARCHAR(255) NOT NULL,
+
+    started_at      VARCHAR(32) NOT NULL,  -- RFC 3339, in UTC
+    duration_ms     BIGINT NOT NULL,
+    stage           VARCHAR(32) NOT NULL,
+    error           TEXT
+);
+
+CREATE INDEX package_attempt ON package_attempts (distro, pkg_name, id);
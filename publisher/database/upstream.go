@@ -0,0 +1,96 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/btidor/src.codes/publisher/analysis"
+)
+
+const upstreamColumns = "homepage, repository, repository_browse, bug_database," +
+	" bug_submit, documentation, changelog, watch, moved_from"
+
+// RecordUpstream stores the upstream metadata for a package version, replacing
+// any previously recorded.
+func (db *Database) RecordUpstream(pv PackageVersion, u analysis.Upstream) {
+	_, err := db.Exec(
+		"INSERT INTO upstream_metadata (package_version, "+upstreamColumns+")"+
+			" VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)"+
+			" ON CONFLICT (package_version) DO UPDATE SET"+
+			" homepage = EXCLUDED.homepage, repository = EXCLUDED.repository,"+
+			" repository_browse = EXCLUDED.repository_browse,"+
+			" bug_database = EXCLUDED.bug_database, bug_submit = EXCLUDED.bug_submit,"+
+			" documentation = EXCLUDED.documentation, changelog = EXCLUDED.changelog,"+
+			" watch = EXCLUDED.watch, moved_from = EXCLUDED.moved_from",
+		pv.ID, u.Homepage, u.Repository, u.RepositoryBrowse, u.BugDatabase,
+		u.BugSubmit, u.Documentation, u.Changelog, strings.Join(u.Watch, "\n"),
+		u.MovedFrom,
+	)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// GetUpstream retrieves the upstream metadata for a package version, if any was
+// recorded.
+func (db *Database) GetUpstream(pv PackageVersion) (analysis.Upstream, bool) {
+	row := db.QueryRow(
+		"SELECT "+upstreamColumns+" FROM upstream_metadata WHERE package_version = $1",
+		pv.ID,
+	)
+	u, err := scanUpstream(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return analysis.Upstream{}, false
+	} else if err != nil {
+		panic(err)
+	}
+	return u, true
+}
+
+// ListDistroUpstream retrieves the upstream metadata for the current version of
+// each package in the distribution, keyed by package name.
+func (db *Database) ListDistroUpstream(distro string) map[string]analysis.Upstream {
+	rows, err := db.Query(
+		"SELECT pv.pkg_name, "+upstreamColumns+
+			" FROM distribution_contents dc"+
+			" JOIN package_versions pv ON dc.current = pv.id"+
+			" JOIN upstream_metadata um ON um.package_version = pv.id"+
+			" WHERE dc.distro = $1",
+		distro,
+	)
+	if err != nil {
+		panic(err)
+	}
+	defer rows.Close()
+
+	var result = make(map[string]analysis.Upstream)
+	for rows.Next() {
+		var name string
+		u, err := scanUpstream(rows, &name)
+		if err != nil {
+			panic(err)
+		}
+		result[name] = u
+	}
+	if err := rows.Err(); err != nil {
+		panic(err)
+	}
+	return result
+}
+
+func scanUpstream(row interface{ Scan(...any) error }, prefix ...any) (analysis.Upstream, error) {
+	var u analysis.Upstream
+	var watch string
+	dest := append(prefix,
+		&u.Homepage, &u.Repository, &u.RepositoryBrowse, &u.BugDatabase,
+		&u.BugSubmit, &u.Documentation, &u.Changelog, &watch, &u.MovedFrom,
+	)
+	if err := row.Scan(dest...); err != nil {
+		return u, err
+	}
+	if watch != "" {
+		u.Watch = strings.Split(watch, "\n")
+	}
+	return u, nil
+}
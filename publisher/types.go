@@ -5,7 +5,7 @@ import "net/url"
 
 // Epoch is the current version of the publisher. Bumping this number will cause
 // every package's index files to be recomputed.
-const Epoch = 1
+const Epoch = 12
 
 // Distro represents an umbrella distribution like 'hirsute' or 'buster'.
 type Distro struct {
@@ -13,4 +13,17 @@ type Distro struct {
 	Mirror     *url.URL
 	Areas      []string // 'security', 'updates', '', etc.
 	Components []string // 'main', 'multiverse', etc.
+
+	LargeFileSize  int64 // see internal.ConfigEntry
+	MaxFileSize    int64
+	ExpandArchives bool
+}
+
+// SameAnalysis reports whether packages in the two distros are analyzed the
+// same way, so that a package version processed for one can be reused in the
+// other.
+func (d Distro) SameAnalysis(other Distro) bool {
+	return d.LargeFileSize == other.LargeFileSize &&
+		d.MaxFileSize == other.MaxFileSize &&
+		d.ExpandArchives == other.ExpandArchives
 }
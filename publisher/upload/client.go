@@ -2,14 +2,20 @@ package upload
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/url"
 	"os"
+	"strings"
 
 	"github.com/hashicorp/go-retryablehttp"
 )
 
+// ErrNotFound is returned by Get if the file doesn't exist.
+var ErrNotFound = errors.New("not found in bucket")
+
 type Bucket struct {
 	url       *url.URL // https://HOST/ZONE
 	accessKey string
@@ -60,6 +66,31 @@ func (b *Bucket) Put(to string, reader io.Reader, contentType string) error {
 	return b.doRequest(req)
 }
 
+// PutFile uploads a file from disk. Unlike Put, which reads the body into
+// memory first, the file is streamed (and re-read from the start on retries).
+func (b *Bucket) PutFile(to, filename, contentType string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	req, err := b.newRequest("PUT", file, to)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = stat.Size()
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	req.Header.Set("Content-Type", contentType)
+	return b.doRequest(req)
+}
+
 func (b *Bucket) Get(path string) (*bytes.Buffer, error) {
 	req, err := b.newRequest("GET", nil, path)
 	if err != nil {
@@ -69,16 +100,117 @@ func (b *Bucket) Get(path string) (*bytes.Buffer, error) {
 	res, err := b.client.Do(req)
 	if err != nil {
 		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == 404 {
+		return nil, fmt.Errorf("%s: %w", path, ErrNotFound)
 	} else if res.StatusCode != 200 {
 		return nil, fmt.Errorf("unexpected response code %d", res.StatusCode)
 	}
 
-	defer res.Body.Close()
 	var buf bytes.Buffer
 	buf.ReadFrom(res.Body)
 	return &buf, nil
 }
 
+// Download streams a file from the bucket into w, and returns its content type.
+// Unlike Get, the file isn't held in memory.
+func (b *Bucket) Download(path string, w io.Writer) (string, error) {
+	req, err := b.newRequest("GET", nil, path)
+	if err != nil {
+		return "", err
+	}
+
+	res, err := b.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return "", fmt.Errorf("unexpected response code %d", res.StatusCode)
+	}
+	if _, err := io.Copy(w, res.Body); err != nil {
+		return "", err
+	}
+	return res.Header.Get("Content-Type"), nil
+}
+
+// Exists checks whether a file is present in the bucket, without downloading
+// it.
+func (b *Bucket) Exists(path string) (bool, error) {
+	req, err := b.newRequest("HEAD", nil, path)
+	if err != nil {
+		return false, err
+	}
+
+	res, err := b.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case 200:
+		return true, nil
+	case 404:
+		return false, nil
+	default:
+		return false, fmt.Errorf("unexpected response code %d", res.StatusCode)
+	}
+}
+
+// An Object is an entry in a directory listing.
+type Object struct {
+	ObjectName  string
+	Length      int64
+	IsDirectory bool
+}
+
+// List returns the contents of a directory in the bucket. A directory that
+// doesn't exist is empty.
+func (b *Bucket) List(dir string) ([]Object, error) {
+	req, err := b.newRequest("GET", nil, strings.TrimSuffix(dir, "/")+"/")
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == 404 {
+		return nil, nil
+	} else if res.StatusCode != 200 {
+		return nil, fmt.Errorf("unexpected response code %d", res.StatusCode)
+	}
+
+	var objects []Object
+	if err := json.NewDecoder(res.Body).Decode(&objects); err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+// Delete removes a file from the bucket. Deleting a file that doesn't exist is
+// not an error.
+func (b *Bucket) Delete(path string) error {
+	req, err := b.newRequest("DELETE", nil, path)
+	if err != nil {
+		return err
+	}
+
+	res, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == 200 || res.StatusCode == 404 {
+		return nil
+	}
+	return fmt.Errorf("unexpected response code %d", res.StatusCode)
+}
+
 func (b *Bucket) newRequest(method string, body io.Reader, parts ...string) (*retryablehttp.Request, error) {
 	path, err := url.JoinPath(b.url.String(), parts...)
 	if err != nil {
@@ -1,14 +1,17 @@
 package upload
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -22,6 +25,9 @@ import (
 
 const emptySHA string = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
 
+// The distro changelog feed keeps this many of the most recent entries.
+const changelogFeedLength = 500
+
 type Uploader struct {
 	ls   *Bucket
 	cat  *Bucket
@@ -54,17 +60,14 @@ func (up *Uploader) UploadFile(f analysis.File) {
 	if f.Size == 0 && hex != emptySHA {
 		panic(fmt.Errorf("unexpected empty file %#v", f))
 	}
-	file, err := os.Open(f.LocalPath)
-	if err != nil {
-		panic(err)
-	}
 	remote := path.Join(hex[0:2], hex[0:4], hex)
 	// Unfortunately, Bunny.net has rare spikes of 400s (several instances at
 	// once, across different packages). Or could there be something wrong with
 	// our connection pooling?
+	var err error
 	var delay = 1 * time.Second
 	for range 5 {
-		err = up.cat.Put(remote, file, "")
+		err = up.cat.PutFile(remote, f.LocalPath, "")
 		if err == nil {
 			return
 		}
@@ -75,17 +78,143 @@ func (up *Uploader) UploadFile(f analysis.File) {
 	panic(err)
 }
 
-func (up *Uploader) UploadTree(a analysis.Archive) {
-	data, err := json.MarshalIndent(a.Tree, "", "  ")
+// HasFile checks whether a file with the given hash has been uploaded.
+func (up *Uploader) HasFile(hash [32]byte) bool {
+	hex := hex.EncodeToString(hash[:])
+	found, err := up.cat.Exists(path.Join(hex[0:2], hex[0:4], hex))
+	if err != nil {
+		panic(err)
+	}
+	return found
+}
+
+// createSpool creates a temporary file for building up a large upload on disk
+// rather than in memory. Pass it to putSpool when it's complete.
+func createSpool(name string) *os.File {
+	file, err := os.CreateTemp("", "srccodes-"+name+"-")
 	if err != nil {
 		panic(err)
 	}
-	in := bytes.NewReader(data)
+	return file
+}
+
+// putSpool uploads a file created with createSpool, then removes it.
+func putSpool(b *Bucket, remote string, file *os.File, contentType string) {
+	defer os.Remove(file.Name())
+	if err := file.Close(); err != nil {
+		panic(err)
+	}
+	if err := b.PutFile(remote, file.Name(), contentType); err != nil {
+		panic(err)
+	}
+}
+
+// consolidate downloads the index with the given extension for each package
+// version, decodes it and passes it to merge along with the package name.
+// Downloads and decoding run in parallel, but calls to merge are serialized.
+func consolidate[T any](up *Uploader, distro, ext string, pkgvers []database.PackageVersion,
+	decode func(data []byte, v any) error, merge func(name string, index T)) {
+	type result struct {
+		name  string
+		index T
+	}
+
+	var wg sync.WaitGroup
+	jobs := make(chan database.PackageVersion)
+	results := make(chan result, 16)
+	for w := 0; w < up.downloadThreads; w++ {
+		wg.Add(1)
+		go func(w int, jobs <-chan database.PackageVersion, wg *sync.WaitGroup) {
+			defer wg.Done()
+			for pv := range jobs {
+				path := path.Join(distro, pv.Name, fmt.Sprintf(
+					"%s_%s:%d.%s", pv.Name, pv.Version, pv.Epoch, ext,
+				))
+				log.Printf("Downloading %s\n", path)
+				data, err := up.ls.Get(path)
+				if err != nil {
+					panic(err)
+				}
+				var r = result{name: pv.Name}
+				if err := decode(data.Bytes(), &r.index); err != nil {
+					panic(err)
+				}
+				results <- r
+				log.Printf("  done %s\n", path)
+			}
+		}(w, jobs, &wg)
+	}
+
+	var wg2 sync.WaitGroup
+	wg2.Add(1)
+	go func() {
+		defer wg2.Done()
+		for r := range results {
+			merge(r.name, r.index)
+		}
+	}()
+
+	for _, pv := range pkgvers {
+		jobs <- pv
+	}
+
+	close(jobs)
+	wg.Wait()
+	close(results)
+	wg2.Wait()
+}
+
+// putJSON uploads a consolidated index as indented JSON, spooling it to disk
+// first since it can be large.
+func putJSON(b *Bucket, remote string, v any) {
+	var spool = createSpool(path.Base(remote))
+	var out = bufio.NewWriter(spool)
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		panic(err)
+	}
+	if err := out.Flush(); err != nil {
+		panic(err)
+	}
+	putSpool(b, remote, spool, "application/json")
+}
+
+func (up *Uploader) UploadTree(a analysis.Archive) {
+	spool := createSpool(a.Pkg.Name + "-tree")
+	if err := analysis.WriteTree(spool, a.Tree); err != nil {
+		panic(err)
+	}
 	filename := fmt.Sprintf(
 		"%s_%s:%d.json", a.Pkg.Name, a.Pkg.Version, publisher.Epoch,
 	)
 	remote := path.Join(a.Pkg.Source.Distro, a.Pkg.Name, filename)
-	if err := up.ls.Put(remote, in, "application/json"); err != nil {
+	putSpool(up.ls, remote, spool, "application/json")
+}
+
+// DownloadTree fetches the tree previously uploaded for the given package
+// version.
+func (up *Uploader) DownloadTree(distro string, pv database.PackageVersion) ([]byte, error) {
+	filename := fmt.Sprintf(
+		"%s_%s:%d.json", pv.Name, pv.Version, pv.Epoch,
+	)
+	data, err := up.ls.Get(path.Join(distro, pv.Name, filename))
+	if err != nil {
+		return nil, err
+	}
+	return data.Bytes(), nil
+}
+
+func (up *Uploader) UploadDiffPackageIndex(pkg apt.Package, diff analysis.Diff) {
+	data, err := json.MarshalIndent(diff, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	filename := fmt.Sprintf(
+		"%s_%s:%d.diff", pkg.Name, pkg.Version, publisher.Epoch,
+	)
+	remote := path.Join(pkg.Source.Distro, pkg.Name, filename)
+	if err := up.ls.Put(remote, bytes.NewBuffer(data), "application/json"); err != nil {
 		panic(err)
 	}
 }
@@ -116,7 +245,8 @@ func (up *Uploader) ConsolidateFzfIndex(distro string, pkgvers []database.Packag
 	pkgvers = filtered
 
 	// Download and concatenate indexes for each package
-	var consolidated = new(bytes.Buffer)
+	var spool = createSpool("paths")
+	var consolidated = bufio.NewWriter(spool)
 	enc := msgpack.NewEncoder(consolidated)
 	if err := enc.EncodeArrayLen(len(pkgvers)); err != nil {
 		panic(err)
@@ -164,19 +294,39 @@ func (up *Uploader) ConsolidateFzfIndex(distro string, pkgvers []database.Packag
 	close(results)
 	wg2.Wait()
 
-	remote := path.Join(distro, "paths.fzf")
-	if err := up.meta.Put(remote, consolidated, ""); err != nil {
+	if err := consolidated.Flush(); err != nil {
 		panic(err)
 	}
+	remote := path.Join(distro, "paths.fzf")
+	putSpool(up.meta, remote, spool, "")
 }
 
-func (up *Uploader) UploadCtagsPackageIndex(pkg apt.Package, ctags []byte) {
-	in := bytes.NewReader(ctags)
+func (up *Uploader) UploadCtagsPackageIndex(pkg apt.Package, ctags analysis.Artifact) {
 	filename := fmt.Sprintf(
 		"%s_%s:%d.tags", pkg.Name, pkg.Version, publisher.Epoch,
 	)
 	remote := path.Join(pkg.Source.Distro, pkg.Name, filename)
-	if err := up.ls.Put(remote, in, "text/plain"); err != nil {
+	if err := up.ls.PutFile(remote, ctags.Path, "text/plain"); err != nil {
+		panic(err)
+	}
+}
+
+func (up *Uploader) UploadOutlinePackageIndex(pkg apt.Package, outline analysis.Artifact) {
+	filename := fmt.Sprintf(
+		"%s_%s:%d.outline", pkg.Name, pkg.Version, publisher.Epoch,
+	)
+	remote := path.Join(pkg.Source.Distro, pkg.Name, filename)
+	if err := up.ls.PutFile(remote, outline.Path, ""); err != nil {
+		panic(err)
+	}
+}
+
+func (up *Uploader) UploadLSIFPackageIndex(pkg apt.Package, lsif analysis.Artifact) {
+	filename := fmt.Sprintf(
+		"%s_%s:%d.lsif", pkg.Name, pkg.Version, publisher.Epoch,
+	)
+	remote := path.Join(pkg.Source.Distro, pkg.Name, filename)
+	if err := up.ls.PutFile(remote, lsif.Path, "application/x-ndjson"); err != nil {
 		panic(err)
 	}
 }
@@ -217,13 +367,13 @@ func (up *Uploader) ConsolidateSymbolsIndex(distro string, pkgvers []database.Pa
 		}(w, jobs, &wg)
 	}
 
-	var in bytes.Buffer
+	var spool = createSpool("symbols")
 	var wg2 sync.WaitGroup
 	wg2.Add(1)
 	go func() {
 		defer wg2.Done()
 		for symbols := range results {
-			_, err := io.Copy(&in, &symbols)
+			_, err := io.Copy(spool, &symbols)
 			if err != nil {
 				panic(err)
 			}
@@ -242,40 +392,296 @@ func (up *Uploader) ConsolidateSymbolsIndex(distro string, pkgvers []database.Pa
 	wg2.Wait()
 
 	remote := path.Join(distro, "symbols.txt")
-	if err := up.meta.Put(remote, &in, "text/plain"); err != nil {
+	putSpool(up.meta, remote, spool, "text/plain")
+}
+
+func (up *Uploader) UploadCodesearchPackageIndex(pkg apt.Package, cs analysis.CodesearchIndex) {
+	var files = []struct {
+		ext      string
+		artifact analysis.Artifact
+	}{
+		{".csi", cs.Index},
+		{".tar.zst", cs.Source},
+		{".large.csi", cs.LargeIndex},
+		{".large.tar.zst", cs.LargeSource},
+	}
+	for _, f := range files {
+		filename := fmt.Sprintf(
+			"%s_%s:%d%s", pkg.Name, pkg.Version, publisher.Epoch, f.ext,
+		)
+		remote := path.Join(pkg.Source.Distro, pkg.Name, filename)
+		if err := up.ls.PutFile(remote, f.artifact.Path, ""); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func (up *Uploader) UploadLicensePackageIndex(pkg apt.Package, licenses analysis.LicenseSummary) {
+	data, err := json.MarshalIndent(licenses, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	filename := fmt.Sprintf(
+		"%s_%s:%d.licenses", pkg.Name, pkg.Version, publisher.Epoch,
+	)
+	remote := path.Join(pkg.Source.Distro, pkg.Name, filename)
+	if err := up.ls.Put(remote, bytes.NewBuffer(data), "application/json"); err != nil {
+		panic(err)
+	}
+}
+
+func (up *Uploader) ConsolidateLicenseIndex(distro string, pkgvers []database.PackageVersion) {
+	// The summary lists each package's licenses, plus the total number of
+	// files under each license across the distro.
+	var summary = struct {
+		Packages map[string]analysis.LicenseSummary `json:"packages"`
+		Totals   map[string]int                     `json:"totals"`
+	}{
+		Packages: make(map[string]analysis.LicenseSummary),
+		Totals:   make(map[string]int),
+	}
+	consolidate(up, distro, "licenses", pkgvers, json.Unmarshal,
+		func(name string, licenses analysis.LicenseSummary) {
+			summary.Packages[name] = licenses
+			for license, count := range licenses.Licenses {
+				summary.Totals[license] += count
+			}
+		})
+	putJSON(up.meta, path.Join(distro, "licenses.json"), summary)
+}
+
+func (up *Uploader) UploadSLOCPackageIndex(pkg apt.Package, sloc analysis.SLOCStats) {
+	data, err := json.MarshalIndent(sloc, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	filename := fmt.Sprintf(
+		"%s_%s:%d.sloc", pkg.Name, pkg.Version, publisher.Epoch,
+	)
+	remote := path.Join(pkg.Source.Distro, pkg.Name, filename)
+	if err := up.ls.Put(remote, bytes.NewBuffer(data), "application/json"); err != nil {
+		panic(err)
+	}
+}
+
+func (up *Uploader) UploadSLOCDistroIndex(distro string, sloc database.DistroSLOC) {
+	data, err := json.MarshalIndent(sloc, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	remote := path.Join(distro, "sloc.json")
+	if err := up.meta.Put(remote, bytes.NewBuffer(data), "application/json"); err != nil {
 		panic(err)
 	}
 }
 
-func (up *Uploader) UploadCodesearchPackageIndex(pkg apt.Package, codesearch, sourcetar []byte) {
-	in := bytes.NewBuffer(codesearch)
+func (up *Uploader) UploadVendorPackageIndex(pkg apt.Package, fingerprints []analysis.DirectoryFingerprint) {
+	data, err := msgpack.Marshal(fingerprints)
+	if err != nil {
+		panic(err)
+	}
 	filename := fmt.Sprintf(
-		"%s_%s:%d.csi", pkg.Name, pkg.Version, publisher.Epoch,
+		"%s_%s:%d.vendor", pkg.Name, pkg.Version, publisher.Epoch,
 	)
 	remote := path.Join(pkg.Source.Distro, pkg.Name, filename)
-	if err := up.ls.Put(remote, in, ""); err != nil {
+	if err := up.ls.Put(remote, bytes.NewBuffer(data), ""); err != nil {
 		panic(err)
 	}
+}
+
+// ConsolidateVendorIndex groups copies of the same directory found in
+// different packages, e.g. bundled copies of a library.
+func (up *Uploader) ConsolidateVendorIndex(distro string, pkgvers []database.PackageVersion) {
+	var packages = make(map[string][]analysis.DirectoryFingerprint)
+	consolidate(up, distro, "vendor", pkgvers, msgpack.Unmarshal,
+		func(name string, fingerprints []analysis.DirectoryFingerprint) {
+			packages[name] = fingerprints
+		})
+	groups := analysis.GroupVendoredCopies(packages, analysis.DefaultVendorThreshold)
+	putJSON(up.meta, path.Join(distro, "vendored.json"), groups)
+}
+
+func (up *Uploader) UploadIncludePackageIndex(pkg apt.Package, includes map[string][]analysis.Include, headers map[string]string) {
+	for _, f := range []struct {
+		ext  string
+		data any
+	}{
+		{".includes", includes},
+		{".headers", headers},
+	} {
+		data, err := json.MarshalIndent(f.data, "", "  ")
+		if err != nil {
+			panic(err)
+		}
+		filename := fmt.Sprintf(
+			"%s_%s:%d%s", pkg.Name, pkg.Version, publisher.Epoch, f.ext,
+		)
+		remote := path.Join(pkg.Source.Distro, pkg.Name, filename)
+		if err := up.ls.Put(remote, bytes.NewBuffer(data), "application/json"); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// ConsolidateHeaderIndex builds a distro-wide index of the headers shipped by
+// each package, for resolving #includes across packages.
+func (up *Uploader) ConsolidateHeaderIndex(distro string, pkgvers []database.PackageVersion) {
+	var index = make(map[string][]analysis.HeaderLocation)
+	consolidate(up, distro, "headers", pkgvers, json.Unmarshal,
+		func(pkg string, headers map[string]string) {
+			for name, p := range headers {
+				index[name] = append(index[name], analysis.HeaderLocation{
+					Package: pkg,
+					Path:    p,
+				})
+			}
+		})
+	for _, locations := range index {
+		sort.Slice(locations, func(i, j int) bool {
+			return locations[i].Package < locations[j].Package
+		})
+	}
+	putJSON(up.meta, path.Join(distro, "headers.json"), index)
+}
+
+func (up *Uploader) UploadImportPackageIndex(pkg apt.Package, imports map[string][]analysis.Import, modules analysis.ModuleSummary) {
+	for _, f := range []struct {
+		ext  string
+		data any
+	}{
+		{".imports", imports},
+		{".modules", modules},
+	} {
+		data, err := json.MarshalIndent(f.data, "", "  ")
+		if err != nil {
+			panic(err)
+		}
+		filename := fmt.Sprintf(
+			"%s_%s:%d%s", pkg.Name, pkg.Version, publisher.Epoch, f.ext,
+		)
+		remote := path.Join(pkg.Source.Distro, pkg.Name, filename)
+		if err := up.ls.Put(remote, bytes.NewBuffer(data), "application/json"); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// ConsolidateModuleIndex builds a distro-wide index of the Python, Go and Rust
+// modules provided by each package, and the dependency graph between packages.
+func (up *Uploader) ConsolidateModuleIndex(distro string, pkgvers []database.PackageVersion) {
+	var summaries = make(map[string]analysis.ModuleSummary)
+	consolidate(up, distro, "modules", pkgvers, json.Unmarshal,
+		func(name string, modules analysis.ModuleSummary) {
+			summaries[name] = modules
+		})
+	putJSON(up.meta, path.Join(distro, "modules.json"), analysis.ConstructModuleIndex(summaries))
+}
 
-	filename = fmt.Sprintf(
-		"%s_%s:%d.tar.zst", pkg.Name, pkg.Version, publisher.Epoch,
+func (up *Uploader) UploadChangelogPackageIndex(pkg apt.Package, changelog []analysis.ChangelogEntry) {
+	data, err := json.MarshalIndent(changelog, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	filename := fmt.Sprintf(
+		"%s_%s:%d.changelog", pkg.Name, pkg.Version, publisher.Epoch,
 	)
-	remote = path.Join(pkg.Source.Distro, pkg.Name, filename)
-	if err := up.ls.Put(remote, bytes.NewBuffer(sourcetar), ""); err != nil {
+	remote := path.Join(pkg.Source.Distro, pkg.Name, filename)
+	if err := up.ls.Put(remote, bytes.NewBuffer(data), "application/json"); err != nil {
 		panic(err)
 	}
 }
 
-func (up *Uploader) UploadPackageList(distro string, pkgvers []database.PackageVersion) {
+// UpdateChangelogFeed adds the latest changelog entry for each of the given
+// (newly processed) package versions to the distro's changelog feed, which
+// lists the most recent entries across all packages, newest first.
+func (up *Uploader) UpdateChangelogFeed(distro string, updated []database.PackageVersion) {
+	remote := path.Join(distro, "changelog.json")
+
+	var feed []analysis.ChangelogEntry
+	if data, err := up.meta.Get(remote); errors.Is(err, ErrNotFound) {
+		log.Printf("No existing changelog feed, starting a new one\n")
+	} else if err != nil {
+		panic(err)
+	} else if err := json.Unmarshal(data.Bytes(), &feed); err != nil {
+		log.Printf("Could not parse existing changelog feed, starting over: %s\n", err)
+		feed = nil
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var latest = make(map[string]analysis.ChangelogEntry)
+	jobs := make(chan database.PackageVersion)
+	for w := 0; w < up.downloadThreads; w++ {
+		wg.Add(1)
+		go func(w int, jobs <-chan database.PackageVersion, wg *sync.WaitGroup) {
+			defer wg.Done()
+			for pv := range jobs {
+				path := path.Join(distro, pv.Name, fmt.Sprintf(
+					"%s_%s:%d.changelog", pv.Name, pv.Version, pv.Epoch,
+				))
+				log.Printf("Downloading %s\n", path)
+				data, err := up.ls.Get(path)
+				if err != nil {
+					panic(err)
+				}
+				var entries []analysis.ChangelogEntry
+				if err := json.Unmarshal(data.Bytes(), &entries); err != nil {
+					panic(err)
+				}
+				if len(entries) > 0 {
+					mu.Lock()
+					latest[pv.Name] = entries[0]
+					mu.Unlock()
+				}
+				log.Printf("  done %s\n", path)
+			}
+		}(w, jobs, &wg)
+	}
+	for _, pv := range updated {
+		jobs <- pv
+	}
+	close(jobs)
+	wg.Wait()
+
+	// Replace any older entries for the updated packages
+	var merged []analysis.ChangelogEntry
+	for _, entry := range feed {
+		if _, found := latest[entry.Package]; !found {
+			merged = append(merged, entry)
+		}
+	}
+	for _, entry := range latest {
+		merged = append(merged, entry)
+	}
+	analysis.SortChangelogFeed(merged)
+	if len(merged) > changelogFeedLength {
+		merged = merged[:changelogFeedLength]
+	}
+
+	data, err := json.MarshalIndent(merged, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	if err := up.meta.Put(remote, bytes.NewBuffer(data), "application/json"); err != nil {
+		panic(err)
+	}
+}
+
+func (up *Uploader) UploadPackageList(distro string, pkgvers []database.PackageVersion, upstream map[string]analysis.Upstream) {
 	var list = make(map[string]any)
 	for _, pv := range pkgvers {
-		list[pv.Name] = struct {
-			Version string `json:"version"`
-			Epoch   int    `json:"epoch"`
+		var entry = struct {
+			Version  string             `json:"version"`
+			Epoch    int                `json:"epoch"`
+			Upstream *analysis.Upstream `json:"upstream,omitempty"`
 		}{
 			Version: pv.Version,
 			Epoch:   pv.Epoch,
 		}
+		if u, found := upstream[pv.Name]; found {
+			entry.Upstream = &u
+		}
+		list[pv.Name] = entry
 	}
 	data, err := json.MarshalIndent(list, "", "  ")
 	if err != nil {
@@ -286,3 +692,157 @@ func (up *Uploader) UploadPackageList(distro string, pkgvers []database.PackageV
 		panic(err)
 	}
 }
+
+// UploadSnapshot publishes the package list for a snapshot of the distribution,
+// in the same format as packages.json (minus the upstream metadata, which isn't
+// kept historically). Clients use the version and epoch to find the package's
+// indexes, which are left in storage after the distro moves on.
+func (up *Uploader) UploadSnapshot(distro string, snapshot database.Snapshot, pkgvers []database.PackageVersion) {
+	var list = make(map[string]any)
+	for _, pv := range pkgvers {
+		list[pv.Name] = struct {
+			Version string `json:"version"`
+			Epoch   int    `json:"epoch"`
+		}{pv.Version, pv.Epoch}
+	}
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	remote := path.Join(distro, "snapshots", fmt.Sprintf("%d.json", snapshot.ID))
+	if err := up.meta.Put(remote, bytes.NewBuffer(data), "application/json"); err != nil {
+		panic(err)
+	}
+}
+
+// UploadSnapshotIndex publishes the list of snapshots of the distribution,
+// oldest first. To see the distro as of a given date, clients pick the last
+// snapshot taken on or before it and fetch snapshots/<id>.json.
+func (up *Uploader) UploadSnapshotIndex(distro string, snapshots []database.Snapshot) {
+	if snapshots == nil {
+		snapshots = []database.Snapshot{}
+	}
+	data, err := json.MarshalIndent(snapshots, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	remote := path.Join(distro, "snapshots.json")
+	if err := up.meta.Put(remote, bytes.NewBuffer(data), "application/json"); err != nil {
+		panic(err)
+	}
+}
+
+// ListPackageIndexes lists the index files in storage for every package in a
+// distribution, by package name. This includes indexes for old package
+// versions and epochs.
+func (up *Uploader) ListPackageIndexes(distro string) map[string][]Object {
+	dirs, err := up.ls.List(distro)
+	if err != nil {
+		panic(err)
+	}
+
+	type result struct {
+		name    string
+		objects []Object
+	}
+	var wg sync.WaitGroup
+	jobs := make(chan string)
+	results := make(chan result, 16)
+	for w := 0; w < up.downloadThreads; w++ {
+		wg.Add(1)
+		go func(w int, jobs <-chan string, wg *sync.WaitGroup) {
+			defer wg.Done()
+			for name := range jobs {
+				objects, err := up.ls.List(path.Join(distro, name))
+				if err != nil {
+					panic(err)
+				}
+				results <- result{name, objects}
+			}
+		}(w, jobs, &wg)
+	}
+
+	var indexes = make(map[string][]Object)
+	var wg2 sync.WaitGroup
+	wg2.Add(1)
+	go func() {
+		defer wg2.Done()
+		for r := range results {
+			for _, obj := range r.objects {
+				if !obj.IsDirectory {
+					indexes[r.name] = append(indexes[r.name], obj)
+				}
+			}
+		}
+	}()
+
+	for _, dir := range dirs {
+		if dir.IsDirectory {
+			jobs <- dir.ObjectName
+		}
+	}
+
+	close(jobs)
+	wg.Wait()
+	close(results)
+	wg2.Wait()
+	return indexes
+}
+
+// DeletePackageIndex removes an index file found with ListPackageIndexes.
+func (up *Uploader) DeletePackageIndex(distro, name, filename string) {
+	if err := up.ls.Delete(path.Join(distro, name, filename)); err != nil {
+		panic(err)
+	}
+}
+
+// DeleteFile removes the file with the given hash from storage.
+func (up *Uploader) DeleteFile(hash [32]byte) {
+	hex := hex.EncodeToString(hash[:])
+	if err := up.cat.Delete(path.Join(hex[0:2], hex[0:4], hex)); err != nil {
+		panic(err)
+	}
+}
+
+// DeleteSnapshot removes the package list of a snapshot uploaded with
+// UploadSnapshot. Update the snapshot index first, so clients don't look for
+// it.
+func (up *Uploader) DeleteSnapshot(distro string, id int64) {
+	remote := path.Join(distro, "snapshots", fmt.Sprintf("%d.json", id))
+	if err := up.meta.Delete(remote); err != nil {
+		panic(err)
+	}
+}
+
+// CopyPackageIndexes copies a package version's index files from another
+// distro, so that it doesn't have to be processed again. Its diff is skipped,
+// since it's relative to the previous version in the other distro. Returns the
+// number of files copied.
+func (up *Uploader) CopyPackageIndexes(from, to string, pv database.PackageVersion) int {
+	objects, err := up.ls.List(path.Join(from, pv.Name))
+	if err != nil {
+		panic(err)
+	}
+
+	var prefix = fmt.Sprintf("%s_%s:%d.", pv.Name, pv.Version, pv.Epoch)
+	var count int
+	for _, obj := range objects {
+		ext, found := strings.CutPrefix(obj.ObjectName, prefix)
+		if obj.IsDirectory || !found || strings.Contains(ext, ":") || ext == "diff" {
+			// Skip the diff and other versions' indexes (versions can
+			// contain colons, but extensions can't)
+			continue
+		}
+
+		spool := createSpool(pv.Name + "-copy")
+		contentType, err := up.ls.Download(path.Join(from, pv.Name, obj.ObjectName), spool)
+		if err != nil {
+			spool.Close()
+			os.Remove(spool.Name())
+			panic(err)
+		}
+		putSpool(up.ls, path.Join(to, pv.Name, obj.ObjectName), spool, contentType)
+		count++
+	}
+	return count
+}